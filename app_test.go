@@ -6,6 +6,8 @@ import (
 	"io"
 	"math/rand"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 	"todo_pikpo/config"
@@ -18,10 +20,15 @@ import (
 
 	midw "todo_pikpo/middleware"
 
+	"github.com/golang-jwt/jwt/v5"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type AppTest struct {
@@ -37,13 +44,24 @@ func (s *AppTest) grpcRunner() (net.Listener, error) {
 	if err != nil {
 		panic(err)
 	}
-	mdl := midw.NewMiddleware(s.conf)
+	mdl, err := midw.NewMiddleware(s.conf)
+	if err != nil {
+		panic(err)
+	}
+	mdl.SetApiKeys(&s.cnt)
+	limiter, err := midw.NewRateLimiter(s.conf, s.db.Limits)
+	if err != nil {
+		panic(err)
+	}
 	sr := grpc.NewServer(
-		grpc.UnaryInterceptor(mdl.UnaryAuth),
-		grpc.StreamInterceptor(mdl.StreamAuth),
+		grpc.ChainUnaryInterceptor(mdl.UnaryAuth, limiter.UnaryLimit),
+		grpc.ChainStreamInterceptor(mdl.StreamAuth, limiter.StreamLimit),
 	)
 	pb.RegisterTodoServiceServer(sr, &s.grpc)
 	pb.RegisterStreamServiceServer(sr, &s.grpc)
+	pb.RegisterKeyServiceServer(sr, &s.grpc)
+	pb.RegisterAuditServiceServer(sr, &s.grpc)
+	pb.RegisterWebhookServiceServer(sr, &s.grpc)
 
 	log.Printf("ToDo Service started with gRPC on port %d\n", s.conf.Port)
 	if err = sr.Serve(lis); err != nil {
@@ -60,6 +78,16 @@ func (s *AppTest) SetupSuite() {
 		return
 	}
 
+	// run fully in-process when .env.test does not point to a postgres server
+	if c.DbDriver == database.DriverPostgres && c.DbHost == "" {
+		c.DbDriver = database.DriverMemory
+	}
+	if c.Port == 0 {
+		c.Port = 9090
+	}
+	if c.EncryptKey == "" {
+		c.EncryptKey = "testkey"
+	}
 	s.conf = c
 
 	db, err := database.NewDatabase(c)
@@ -74,27 +102,28 @@ func (s *AppTest) SetupSuite() {
 		s.T().Error("Failed to create controller:", err)
 		return
 	}
+	cnt.SetWebhookPrivateHosts(true)
 	s.cnt = cnt
 
 	s.grpc = myGrpc.StartGrpc(&cnt)
 }
 
 func (s *AppTest) createDummyData() {
-	s.cnt.AddTodo(model.TodoModel{
+	s.cnt.AddTodo(context.Background(), model.TodoModel{
 		Author:      "james",
 		Title:       "test this is title",
 		Description: "lorem ipsom dolom amet",
 		StartDate:   time.Now(),
 		EndDate:     time.Now().Add(1 * time.Hour),
 	})
-	s.cnt.AddTodo(model.TodoModel{
+	s.cnt.AddTodo(context.Background(), model.TodoModel{
 		Author:      "robert",
 		Title:       "jakarta unit test",
 		Description: "lorem ipsom dolom amet",
 		StartDate:   time.Now(),
 		EndDate:     time.Now().Add(1 * time.Hour),
 	})
-	s.cnt.AddTodo(model.TodoModel{
+	s.cnt.AddTodo(context.Background(), model.TodoModel{
 		Author:      "ali",
 		Title:       "singapore is awesome",
 		Description: "lorem ipsom dolom amet",
@@ -108,7 +137,7 @@ func (s *AppTest) TearDownSuite() {
 }
 
 func (s *AppTest) SetupTest() {
-	s.conf.Port += uint16(rand.Intn(500))
+	s.conf.Port += uint16(rand.Intn(500)) + 1
 	s.db.Migrate()
 
 }
@@ -204,12 +233,10 @@ func (s *AppTest) TestRPC2() {
 	a.Equal(err, nil)
 	a.Equal(resp.GetIsOk(), true)
 
-	resp2, err := client.GetOneTodo(ctx, &pb.IdQuery{Id: "1"})
-	a.Equal(err, nil)
-	a.Equal(resp2.GetIsOk(), false)
-	a.Equal(int(resp2.GetError().GetCode()), 404)
+	_, err = client.GetOneTodo(ctx, &pb.IdQuery{Id: "1"})
+	a.Equal(status.Code(err), codes.NotFound)
 
-	resp2, err = client.GetOneTodo(ctx, &pb.IdQuery{Id: resp.GetValue()[0].GetId()})
+	resp2, err := client.GetOneTodo(ctx, &pb.IdQuery{Id: resp.GetValue()[0].GetId()})
 	a.Equal(err, nil)
 	a.Equal(resp2.GetIsOk(), true)
 	a.Equal(resp2.GetValue().GetId(), resp.GetValue()[0].GetId())
@@ -252,11 +279,10 @@ func (s *AppTest) TestRPC3() {
 	a.Equal(data.GetIsOk(), true)
 	a.Equal(len(data.GetValue()), 2)
 
-	resp, err = client.DeleteTodo(ctx, &pb.IdQuery{
+	_, err = client.DeleteTodo(ctx, &pb.IdQuery{
 		Id: "fasfad",
 	})
-	a.Equal(resp.GetIsOk(), false)
-	a.Equal(int(resp.GetError().GetCode()), 404)
+	a.Equal(status.Code(err), codes.NotFound)
 }
 
 func (s *AppTest) TestRPC4() {
@@ -298,7 +324,7 @@ func (s *AppTest) TestRPC4() {
 	a.Equal(data.GetIsOk(), true)
 	a.Equal(len(data.GetValue()), 4)
 
-	resp, err = client.AddTodo(ctx, &pb.AddRequest{
+	_, err = client.AddTodo(ctx, &pb.AddRequest{
 		Title:       "jakarta unit test3",
 		Author:      "james roberto",
 		Description: "just description",
@@ -306,8 +332,13 @@ func (s *AppTest) TestRPC4() {
 		StartDate:   uint64(time.Now().Unix()),
 		EndDate:     uint64(time.Now().Unix()),
 	})
-	a.Equal(resp.GetIsOk(), false)
-	a.Equal(int(resp.GetError().GetCode()), 400)
+	st := status.Convert(err)
+	a.Equal(st.Code(), codes.InvalidArgument)
+	a.Equal(len(st.Details()), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	a.Equal(ok, true)
+	a.Equal(len(badRequest.GetFieldViolations()), 2)
+	a.Equal(badRequest.GetFieldViolations()[0].GetField(), "endDate")
 }
 
 func (s *AppTest) TestRPC5() {
@@ -368,10 +399,49 @@ func (s *AppTest) TestRPC5() {
 				EndDate:     v.GetStartDate(),
 			},
 		})
-		a.Equal(err, nil)
-		a.Equal(resp.GetIsOk(), false)
-		a.Equal(int(resp.GetError().GetCode()), 400)
+		a.Equal(resp, (*pb.Response)(nil))
+		a.Equal(status.Code(err), codes.InvalidArgument)
+	}
+}
+
+func (s *AppTest) TestRPCErrorEnvelope() {
+	a := s.Suite.Assert()
+
+	s.grpc.SetErrorEnvelope(true)
+	defer s.grpc.SetErrorEnvelope(false)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
 	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+
+	resp, err := client.GetOneTodo(ctx, &pb.IdQuery{Id: "1"})
+	a.Equal(err, nil)
+	a.Equal(resp.GetIsOk(), false)
+	a.Equal(int(resp.GetError().GetCode()), 404)
+
+	resp, err = client.AddTodo(ctx, &pb.AddRequest{
+		Title:     "jakarta unit test3",
+		Author:    "james roberto",
+		StartDate: uint64(time.Now().Unix()),
+		EndDate:   uint64(time.Now().Unix()),
+	})
+	a.Equal(err, nil)
+	a.Equal(resp.GetIsOk(), false)
+	a.Equal(int(resp.GetError().GetCode()), 400)
+	a.Equal(len(resp.GetError().GetDetails().GetFields()["fieldViolations"].GetListValue().GetValues()), 2)
 }
 
 func (s *AppTest) TestRPCStream() {
@@ -409,6 +479,428 @@ func (s *AppTest) TestRPCStream() {
 
 }
 
+func (s *AppTest) TestRPCPageToken() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	s.createDummyData()
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	resp, err := client.GetTodo(ctx, &pb.FilterRequest{Limit: 2})
+	a.Equal(err, nil)
+	a.Equal(len(resp.GetValue()), 2)
+	a.Equal(resp.GetTotalSize(), int64(3))
+	a.NotEqual(resp.GetNextPageToken(), "")
+
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{Limit: 2, PageToken: resp.GetNextPageToken()})
+	a.Equal(err, nil)
+	a.Equal(len(resp.GetValue()), 1)
+	a.Equal(resp.GetValue()[0].GetAuthor(), "ali")
+	a.Equal(resp.GetNextPageToken(), "")
+
+	// isDone filters both ways now, unset lists everything
+	_, err = client.EditTodo(ctx, &pb.EditRequest{
+		Id:         &pb.IdQuery{Id: resp.GetValue()[0].GetId()},
+		Data:       &pb.AddRequest{IsDone: true},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"isDone"}},
+	})
+	a.Equal(err, nil)
+	isDone, isOpen := true, false
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{IsDone: &isDone})
+	a.Equal(err, nil)
+	a.Equal(len(resp.GetValue()), 1)
+	a.Equal(resp.GetValue()[0].GetAuthor(), "ali")
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{IsDone: &isOpen, Authors: []string{"james", "ali"}})
+	a.Equal(err, nil)
+	a.Equal(len(resp.GetValue()), 1)
+	a.Equal(resp.GetValue()[0].GetAuthor(), "james")
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{Search: "JAKARTA"})
+	a.Equal(err, nil)
+	a.Equal(len(resp.GetValue()), 1)
+
+	_, err = client.GetTodo(ctx, &pb.FilterRequest{PageToken: "garbage"})
+	a.Equal(status.Code(err), codes.InvalidArgument)
+
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{OrderBy: "title desc", Limit: 2})
+	a.Equal(err, nil)
+	a.Equal(resp.GetValue()[0].GetAuthor(), "james")
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{OrderBy: "title desc", Limit: 2, PageToken: resp.GetNextPageToken()})
+	a.Equal(err, nil)
+	a.Equal(len(resp.GetValue()), 1)
+	a.Equal(resp.GetValue()[0].GetAuthor(), "robert")
+	_, err = client.GetTodo(ctx, &pb.FilterRequest{OrderBy: "isDone"})
+	a.Equal(status.Code(err), codes.InvalidArgument)
+
+	stream, err := pb.NewStreamServiceClient(cc).GetStreamingTodo(ctx, &pb.FilterRequest{Limit: 2, OrderBy: "title"})
+	a.Equal(err, nil)
+	var titles []string
+	for {
+		d, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		a.Equal(err, nil)
+		titles = append(titles, d.GetTitle())
+	}
+	a.Equal(titles, []string{"jakarta unit test", "singapore is awesome"})
+	a.NotEqual(stream.Trailer().Get("next-page-token")[0], "")
+	a.Equal(stream.Trailer().Get("total-size")[0], "3")
+}
+
+func (s *AppTest) TestRPCSearch() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	s.createDummyData()
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	resp, err := client.SearchTodos(ctx, &pb.SearchRequest{Query: "singapore"})
+	a.Equal(err, nil)
+	a.Equal(resp.GetTotalSize(), int64(1))
+	a.Equal(resp.GetValue()[0].GetValue().GetAuthor(), "ali")
+	a.Contains(resp.GetValue()[0].GetSnippet(), "<b>singapore</b>")
+
+	_, err = client.SearchTodos(ctx, &pb.SearchRequest{})
+	a.Equal(status.Code(err), codes.InvalidArgument)
+}
+
+func (s *AppTest) TestRPCWatch() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	streamClient := pb.NewStreamServiceClient(cc)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	stream, err := streamClient.WatchTodos(watchCtx, &pb.FilterRequest{Author: "james"})
+	a.Equal(err, nil)
+	// the subscription is registered once the server sent headers
+	_, err = stream.Header()
+	a.Equal(err, nil)
+
+	s.createDummyData()
+
+	msg, err := stream.Recv()
+	a.Equal(err, nil)
+	a.Equal(msg.GetType(), pb.EventType_CREATED)
+	a.Equal(msg.GetValue().GetAuthor(), "james")
+	token := msg.GetResumeToken()
+	cancel()
+
+	_, err = client.DeleteTodo(ctx, &pb.IdQuery{Id: msg.GetValue().GetId()})
+	a.Equal(err, nil)
+
+	// resuming replays what happened while disconnected
+	stream, err = streamClient.WatchTodos(ctx, &pb.FilterRequest{Author: "james", ResumeToken: token})
+	a.Equal(err, nil)
+	msg, err = stream.Recv()
+	a.Equal(err, nil)
+	a.Equal(msg.GetType(), pb.EventType_DELETED)
+	a.Equal(msg.GetValue().GetAuthor(), "james")
+
+	stream, err = streamClient.WatchTodos(ctx, &pb.FilterRequest{ResumeToken: "not a token"})
+	a.Equal(err, nil)
+	_, err = stream.Recv()
+	a.Equal(status.Code(err), codes.InvalidArgument)
+}
+
+func (s *AppTest) TestRPCBatch() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	streamClient := pb.NewStreamServiceClient(cc)
+	item := func(author string) *pb.AddRequest {
+		return &pb.AddRequest{
+			Author:    author,
+			Title:     "batch imported title",
+			StartDate: uint64(time.Now().Unix()),
+			EndDate:   uint64(time.Now().Add(1 * time.Hour).Unix()),
+		}
+	}
+
+	resp, err := client.BatchAddTodo(ctx, &pb.BatchAddRequest{Data: []*pb.AddRequest{item("james"), item("-")}})
+	a.Equal(err, nil)
+	a.Equal(resp.GetIsOk(), false)
+	a.Equal(resp.GetValue()[0].GetIsOk(), true)
+	a.Equal(resp.GetValue()[1].GetIsOk(), false)
+	a.Equal(resp.GetValue()[1].GetError().GetCode(), uint32(400))
+	created := resp.GetValue()[0].GetValue().GetId()
+
+	resp, err = client.BatchEditTodo(ctx, &pb.BatchEditRequest{Atomic: true, Data: []*pb.EditRequest{
+		{Id: &pb.IdQuery{Id: created}, Data: item("robert")},
+	}})
+	a.Equal(err, nil)
+	a.Equal(resp.GetIsOk(), true)
+	a.Equal(resp.GetValue()[0].GetValue().GetAuthor(), "robert")
+
+	upload, err := streamClient.StreamAddTodo(ctx)
+	a.Equal(err, nil)
+	a.Equal(upload.Send(&pb.BatchAddRequest{Atomic: true, Data: []*pb.AddRequest{item("james"), item("ali")}}), nil)
+	a.Equal(upload.Send(&pb.BatchAddRequest{Data: []*pb.AddRequest{item("robert")}}), nil)
+	resp, err = upload.CloseAndRecv()
+	a.Equal(err, nil)
+	a.Equal(resp.GetIsOk(), true)
+	a.Equal(len(resp.GetValue()), 3)
+	a.Equal(resp.GetValue()[2].GetIndex(), uint32(2))
+
+	resp, err = client.BatchDeleteTodo(ctx, &pb.BatchDeleteRequest{Atomic: true, Data: []*pb.IdQuery{{Id: created}}})
+	a.Equal(err, nil)
+	a.Equal(resp.GetIsOk(), true)
+
+	list, err := client.GetTodo(ctx, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	a.Equal(len(list.GetValue()), 3)
+
+	_, err = client.BatchDeleteTodo(ctx, &pb.BatchDeleteRequest{})
+	a.Equal(status.Code(err), codes.InvalidArgument)
+}
+
+func (s *AppTest) TestRPCEditMask() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	s.createDummyData()
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	list, err := client.GetTodo(ctx, &pb.FilterRequest{Author: "james"})
+	a.Equal(err, nil)
+	id := list.GetValue()[0].GetId()
+
+	resp, err := client.EditTodo(ctx, &pb.EditRequest{
+		Id:         &pb.IdQuery{Id: id},
+		Data:       &pb.AddRequest{IsDone: true},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"isDone"}},
+	})
+	a.Equal(err, nil)
+	a.Equal(resp.GetValue().GetIsDone(), true)
+	a.Equal(resp.GetValue().GetAuthor(), "james")
+
+	_, err = client.EditTodo(ctx, &pb.EditRequest{
+		Id:         &pb.IdQuery{Id: id},
+		Data:       &pb.AddRequest{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"createdAt"}},
+	})
+	a.Equal(status.Code(err), codes.InvalidArgument)
+}
+
+func (s *AppTest) TestRPCVersion() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	s.createDummyData()
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	list, err := client.GetTodo(ctx, &pb.FilterRequest{Author: "james"})
+	a.Equal(err, nil)
+	todo := list.GetValue()[0]
+	a.Equal(todo.GetVersion(), uint64(1))
+
+	edit := &pb.EditRequest{
+		Id:              &pb.IdQuery{Id: todo.GetId()},
+		Data:            &pb.AddRequest{IsDone: true},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"isDone"}},
+		ExpectedVersion: todo.GetVersion(),
+	}
+	resp, err := client.EditTodo(ctx, edit)
+	a.Equal(err, nil)
+	a.Equal(resp.GetValue().GetVersion(), uint64(2))
+
+	_, err = client.EditTodo(ctx, edit)
+	a.Equal(status.Code(err), codes.Aborted)
+
+	_, err = client.DeleteTodo(ctx, &pb.IdQuery{Id: todo.GetId(), ExpectedVersion: 1})
+	a.Equal(status.Code(err), codes.Aborted)
+	_, err = client.DeleteTodo(ctx, &pb.IdQuery{Id: todo.GetId(), ExpectedVersion: 2})
+	a.Equal(err, nil)
+}
+
+func (s *AppTest) TestRPCTrash() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	s.createDummyData()
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	list, err := client.GetTodo(ctx, &pb.FilterRequest{Author: "ali"})
+	a.Equal(err, nil)
+	id := list.GetValue()[0].GetId()
+
+	resp, err := client.DeleteTodo(ctx, &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+	a.Equal(resp.GetValue().GetAuthor(), "ali")
+	a.Greater(resp.GetValue().GetDeletedAt(), uint64(0))
+
+	trash, err := client.ListDeletedTodos(ctx, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	a.Equal(len(trash.GetValue()), 1)
+
+	resp, err = client.RestoreTodo(ctx, &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+	a.Equal(resp.GetValue().GetDeletedAt(), uint64(0))
+
+	_, err = client.RestoreTodo(ctx, &pb.IdQuery{Id: id})
+	a.Equal(status.Code(err), codes.NotFound)
+
+	list, err = client.GetTodo(ctx, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	a.Equal(len(list.GetValue()), 3)
+}
+
+func (s *AppTest) TestRPCTenant() {
+	a := s.Suite.Assert()
+	defer func(keys string) { s.conf.TenantKeys = keys }(s.conf.TenantKeys)
+	s.conf.TenantKeys = "acme:acmekey"
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	acme := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer acmekey")
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	streamClient := pb.NewStreamServiceClient(cc)
+
+	watchCtx, cancel := context.WithCancel(acme)
+	defer cancel()
+	watch, err := streamClient.WatchTodos(watchCtx, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	_, err = watch.Header()
+	a.Equal(err, nil)
+
+	s.createDummyData()
+	created, err := client.AddTodo(acme, &pb.AddRequest{
+		Author:    "james",
+		Title:     "acme only",
+		StartDate: uint64(time.Now().Unix()),
+		EndDate:   uint64(time.Now().Add(time.Hour).Unix()),
+	})
+	a.Equal(err, nil)
+
+	// the default workspace's todos were created first, acme's watch skips them
+	msg, err := watch.Recv()
+	a.Equal(err, nil)
+	a.Equal(msg.GetValue().GetId(), created.GetValue().GetId())
+
+	resp, err := client.GetTodo(acme, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	a.Equal(resp.GetTotalSize(), int64(1))
+	resp, err = client.GetTodo(ctx, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	a.Equal(resp.GetTotalSize(), int64(3))
+
+	_, err = client.GetOneTodo(ctx, &pb.IdQuery{Id: created.GetValue().GetId()})
+	a.Equal(status.Code(err), codes.NotFound)
+
+	stream, err := streamClient.GetStreamingTodo(acme, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	var c = 0
+	for {
+		_, err := stream.Recv()
+		if err != nil {
+			a.Equal(err, io.EOF)
+			break
+		}
+		c += 1
+	}
+	a.Equal(c, 1)
+}
+
 func (s *AppTest) TestRPCNonAuth() {
 	a := s.Suite.Assert()
 
@@ -429,5 +921,329 @@ func (s *AppTest) TestRPCNonAuth() {
 
 	client := pb.NewTodoServiceClient(cc)
 	_, err = client.GetTodo(context.Background(), &pb.FilterRequest{})
-	a.NotEqual(err, nil)
+	a.Equal(status.Code(err), codes.Unauthenticated)
+}
+
+func (s *AppTest) TestRPCRoles() {
+	a := s.Suite.Assert()
+	defer func(secret string) { s.conf.JwtSecret = secret }(s.conf.JwtSecret)
+	s.conf.JwtSecret = "jwtsecret"
+	as := func(sub string, roles ...string) context.Context {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   sub,
+			"roles": roles,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("jwtsecret"))
+		a.Equal(err, nil)
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	created, err := client.AddTodo(as("james", "editor"), &pb.AddRequest{
+		Title:     "written by james",
+		StartDate: uint64(time.Now().Unix()),
+		EndDate:   uint64(time.Now().Add(time.Hour).Unix()),
+	})
+	a.Equal(err, nil)
+	id := created.GetValue().GetId()
+
+	// the interceptor refuses the method, the controller the todo
+	_, err = client.GetOneTodo(as("robert", "viewer"), &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+	_, err = client.DeleteTodo(as("robert", "viewer"), &pb.IdQuery{Id: id})
+	a.Equal(status.Code(err), codes.PermissionDenied)
+	_, err = client.DeleteTodo(as("robert", "editor"), &pb.IdQuery{Id: id})
+	a.Equal(status.Code(err), codes.PermissionDenied)
+	_, err = client.DeleteTodo(as("james", "editor"), &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+}
+
+func (s *AppTest) TestRPCApiKeys() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	keyClient := pb.NewKeyServiceClient(cc)
+
+	created, err := keyClient.CreateApiKey(ctx, &pb.CreateApiKeyRequest{Name: "dashboard", Scopes: []string{"read"}})
+	a.Equal(err, nil)
+	a.NotEqual(created.GetKey(), "")
+	a.Equal(created.GetValue().GetScopes(), []string{"read"})
+	reader := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+created.GetKey())
+
+	_, err = client.GetTodo(reader, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	_, err = client.AddTodo(reader, &pb.AddRequest{
+		Title:     "read only key",
+		StartDate: uint64(time.Now().Unix()),
+		EndDate:   uint64(time.Now().Add(time.Hour).Unix()),
+	})
+	a.Equal(status.Code(err), codes.PermissionDenied)
+	_, err = keyClient.ListApiKeys(reader, &pb.ListApiKeysRequest{})
+	a.Equal(status.Code(err), codes.PermissionDenied)
+
+	// the key is never listed, only what it is
+	list, err := keyClient.ListApiKeys(ctx, &pb.ListApiKeysRequest{})
+	a.Equal(err, nil)
+	a.Equal(len(list.GetValue()), 1)
+	a.NotEqual(list.GetValue()[0].GetLastUsedAt(), uint64(0))
+
+	revoked, err := keyClient.RevokeApiKey(ctx, &pb.RevokeApiKeyRequest{Id: created.GetValue().GetId()})
+	a.Equal(err, nil)
+	a.NotEqual(revoked.GetValue().GetRevokedAt(), uint64(0))
+	a.Equal(revoked.GetKey(), "")
+	_, err = client.GetTodo(reader, &pb.FilterRequest{})
+	a.Equal(status.Code(err), codes.Unauthenticated)
+
+	// a rotated key works until its grace period ends
+	rotated, err := keyClient.CreateApiKey(ctx, &pb.CreateApiKeyRequest{Name: "dashboard v1", Scopes: []string{"read"}})
+	a.Equal(err, nil)
+	old := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+rotated.GetKey())
+	_, err = keyClient.RevokeApiKey(ctx, &pb.RevokeApiKeyRequest{Id: rotated.GetValue().GetId(), GraceSeconds: 1})
+	a.Equal(err, nil)
+	_, err = client.GetTodo(old, &pb.FilterRequest{})
+	a.Equal(err, nil)
+	time.Sleep(1100 * time.Millisecond)
+	_, err = client.GetTodo(old, &pb.FilterRequest{})
+	a.Equal(status.Code(err), codes.Unauthenticated)
+}
+
+func (s *AppTest) TestRPCRateLimit() {
+	a := s.Suite.Assert()
+	defer func(limits string) { s.conf.RateLimits = limits }(s.conf.RateLimits)
+	s.conf.RateLimits = "GetOneTodo=0.01:2"
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	for i := 0; i < 2; i++ {
+		_, err = client.GetOneTodo(ctx, &pb.IdQuery{Id: "missing"})
+		a.NotEqual(status.Code(err), codes.ResourceExhausted)
+	}
+
+	var header metadata.MD
+	_, err = client.GetOneTodo(ctx, &pb.IdQuery{Id: "missing"}, grpc.Header(&header))
+	a.Equal(status.Code(err), codes.ResourceExhausted)
+	a.Equal(len(status.Convert(err).Details()), 1)
+	if retry, ok := status.Convert(err).Details()[0].(*errdetails.RetryInfo); a.True(ok) {
+		a.Greater(retry.GetRetryDelay().AsDuration(), time.Minute)
+	}
+	a.Equal(header.Get("retry-after"), []string{"100"})
+
+	// other methods are not limited
+	_, err = client.GetTodo(ctx, &pb.FilterRequest{})
+	a.Equal(err, nil)
+}
+
+func (s *AppTest) TestRPCAuditEvents() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey, "x-request-id", "audit-req-1")
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	auditClient := pb.NewAuditServiceClient(cc)
+
+	var header metadata.MD
+	added, err := client.AddTodo(ctx, &pb.AddRequest{
+		Author:    "james",
+		Title:     "audit me",
+		StartDate: uint64(time.Now().Unix()),
+		EndDate:   uint64(time.Now().Add(time.Hour).Unix()),
+	}, grpc.Header(&header))
+	a.Equal(err, nil)
+	a.Equal(header.Get("x-request-id"), []string{"audit-req-1"})
+	id := added.GetValue().GetId()
+	_, err = client.EditTodo(ctx, &pb.EditRequest{
+		Id:         &pb.IdQuery{Id: id},
+		Data:       &pb.AddRequest{Title: "audited"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	a.Equal(err, nil)
+
+	res, err := auditClient.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{TodoId: id, Limit: 1})
+	a.Equal(err, nil)
+	a.Equal(len(res.GetValue()), 1)
+	updated := res.GetValue()[0]
+	a.Equal(updated.GetAction(), "updated")
+	a.Equal(updated.GetActor(), "key")
+	a.Equal(updated.GetMethod(), "/todoproto.TodoService/EditTodo")
+	a.Equal(updated.GetRequestId(), "audit-req-1")
+	a.Equal(updated.GetBefore().AsMap()["title"], "audit me")
+	a.Equal(updated.GetAfter().AsMap()["title"], "audited")
+	if a.Equal(len(updated.GetChanges()), 1) {
+		a.Equal(updated.GetChanges()[0].GetField(), "title")
+		a.Equal(updated.GetChanges()[0].GetBefore().GetStringValue(), "audit me")
+	}
+
+	res, err = auditClient.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{TodoId: id, PageToken: res.GetNextPageToken()})
+	a.Equal(err, nil)
+	a.Equal(len(res.GetValue()), 1)
+	a.Equal(res.GetValue()[0].GetAction(), "created")
+	a.Nil(res.GetValue()[0].GetBefore())
+	a.Equal(res.GetNextPageToken(), "")
+
+	res, err = auditClient.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{TodoId: id, From: uint64(time.Now().Add(time.Hour).Unix())})
+	a.Equal(err, nil)
+	a.Equal(len(res.GetValue()), 0)
+}
+
+func (s *AppTest) TestRPCHistory() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewTodoServiceClient(cc)
+	added, err := client.AddTodo(ctx, &pb.AddRequest{
+		Author:    "james",
+		Title:     "first",
+		StartDate: uint64(time.Now().Unix()),
+		EndDate:   uint64(time.Now().Add(time.Hour).Unix()),
+	})
+	a.Equal(err, nil)
+	id := added.GetValue().GetId()
+	_, err = client.EditTodo(ctx, &pb.EditRequest{
+		Id:         &pb.IdQuery{Id: id},
+		Data:       &pb.AddRequest{Title: "second"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	a.Equal(err, nil)
+
+	history, err := client.GetTodoHistory(ctx, &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+	if a.Equal(len(history.GetValue()), 2) {
+		second := history.GetValue()[1]
+		a.Equal(second.GetVersion(), uint64(2))
+		a.Equal(second.GetValue().GetTitle(), "second")
+		a.Equal(second.GetActor(), "key")
+		if a.Equal(len(second.GetChanges()), 1) {
+			a.Equal(second.GetChanges()[0].GetField(), "title")
+			a.Equal(second.GetChanges()[0].GetBefore().GetStringValue(), "first")
+		}
+		a.NotEqual(history.GetValue()[0].GetChanges()[0].GetAfter().GetStringValue(), "")
+	}
+
+	reverted, err := client.RevertTodo(ctx, &pb.RevertRequest{Id: id, Version: 1, ExpectedVersion: 2})
+	a.Equal(err, nil)
+	a.Equal(reverted.GetValue().GetTitle(), "first")
+	a.Equal(reverted.GetValue().GetVersion(), uint64(3))
+
+	_, err = client.RevertTodo(ctx, &pb.RevertRequest{Id: id, Version: 2, ExpectedVersion: 2})
+	a.Equal(status.Code(err), codes.Aborted)
+	_, err = client.RevertTodo(ctx, &pb.RevertRequest{Id: id, Version: 7})
+	a.Equal(status.Code(err), codes.NotFound)
+	_, err = client.GetTodoHistory(ctx, &pb.IdQuery{Id: "missing"})
+	a.Equal(status.Code(err), codes.NotFound)
+}
+
+func (s *AppTest) TestRPCWebhooks() {
+	a := s.Suite.Assert()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.conf.EncryptKey)
+	pings := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings <- r.Header.Get(controllers.WebhookEventHeader)
+	}))
+	defer server.Close()
+	go func() {
+		l, e := s.grpcRunner()
+		if e != nil {
+			s.Suite.T().Error()
+		}
+		defer l.Close()
+	}()
+
+	cc, err := grpc.Dial(fmt.Sprintf(":%d", s.conf.Port), grpc.WithInsecure())
+	if err != nil {
+		s.T().Error(err)
+	}
+	defer cc.Close()
+
+	client := pb.NewWebhookServiceClient(cc)
+	_, err = client.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{Url: "not a url"})
+	a.Equal(status.Code(err), codes.InvalidArgument)
+
+	registered, err := client.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{Url: server.URL, Events: []string{"todo.completed"}})
+	a.Equal(err, nil)
+	a.NotEqual(registered.GetSecret(), "")
+	id := registered.GetValue().GetId()
+
+	list, err := client.ListWebhooks(ctx, &pb.ListWebhooksRequest{})
+	a.Equal(err, nil)
+	if a.Equal(len(list.GetValue()), 1) {
+		a.Equal(list.GetValue()[0].GetUrl(), server.URL)
+		a.Equal(list.GetValue()[0].GetEvents(), []string{"todo.completed"})
+	}
+
+	test, err := client.TestWebhook(ctx, &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+	a.Equal(test.GetDelivered(), true)
+	a.Equal(test.GetStatusCode(), uint32(http.StatusOK))
+	a.Equal(<-pings, model.WebhookPing)
+
+	_, err = client.DeleteWebhook(ctx, &pb.IdQuery{Id: id})
+	a.Equal(err, nil)
+	_, err = client.TestWebhook(ctx, &pb.IdQuery{Id: id})
+	a.Equal(status.Code(err), codes.NotFound)
 }
@@ -0,0 +1,140 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindNotFound
+	KindConflict
+	KindUnavailable
+	KindPermissionDenied
+	KindResourceExhausted
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindValidation:
+		return "validation"
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindUnavailable:
+		return "unavailable"
+	case KindPermissionDenied:
+		return "permission_denied"
+	case KindResourceExhausted:
+		return "resource_exhausted"
+	}
+	return "internal"
+}
+
+// FieldViolation describes why a single request field was rejected.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// Error is the domain error returned by controllers, transports translate Kind
+// into their own status codes and may expose Fields to the caller.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  []FieldViolation
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Validation builds a validation error out of one or more field violations.
+func Validation(fields ...FieldViolation) *Error {
+	descriptions := make([]string, 0, len(fields))
+	for _, f := range fields {
+		descriptions = append(descriptions, f.Description)
+	}
+	return &Error{
+		Kind:    KindValidation,
+		Message: strings.Join(descriptions, "; "),
+		Fields:  fields,
+	}
+}
+
+func NotFound(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func PermissionDenied(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindPermissionDenied, Message: fmt.Sprintf(format, args...)}
+}
+
+func ResourceExhausted(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindResourceExhausted, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap keeps err as the cause of a domain error of the given kind.
+func Wrap(kind Kind, err error) *Error {
+	return &Error{Kind: kind, Message: err.Error(), Err: err}
+}
+
+func Unavailable(err error) *Error {
+	return &Error{Kind: KindUnavailable, Message: err.Error(), Err: err}
+}
+
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Message: err.Error(), Err: err}
+}
+
+// KindOf returns the Kind of err, errors that are not domain errors are internal.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
+// FieldsOf returns the field violations carried by err, if any.
+func FieldsOf(err error) []FieldViolation {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Fields
+	}
+	return nil
+}
+
+// HttpStatus maps err to the HTTP status code used by the legacy ErrorResponse envelope
+// and HTTP transports.
+func HttpStatus(err error) int {
+	switch KindOf(err) {
+	case KindValidation:
+		return 400
+	case KindNotFound:
+		return 404
+	case KindConflict:
+		return 409
+	case KindUnavailable:
+		return 503
+	case KindPermissionDenied:
+		return 403
+	case KindResourceExhausted:
+		return 429
+	}
+	return 500
+}
@@ -0,0 +1,47 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(KindOf(NotFound("todo %s not found", "1")), KindNotFound)
+	a.Equal(KindOf(Conflict("stale")), KindConflict)
+	a.Equal(KindOf(Unavailable(errors.New("down"))), KindUnavailable)
+	a.Equal(KindOf(PermissionDenied("not yours")), KindPermissionDenied)
+	a.Equal(HttpStatus(PermissionDenied("not yours")), 403)
+	a.Equal(HttpStatus(ResourceExhausted("quota used up")), 429)
+	a.Equal(KindOf(errors.New("plain")), KindInternal)
+
+	wrapped := fmt.Errorf("controller: %w", NotFound("missing"))
+	a.Equal(KindOf(wrapped), KindNotFound)
+	a.Equal(HttpStatus(wrapped), 404)
+}
+
+func TestValidation(t *testing.T) {
+	a := assert.New(t)
+
+	err := Validation(
+		FieldViolation{Field: "author", Description: "author is too short"},
+		FieldViolation{Field: "title", Description: "title is too short"},
+	)
+	a.Equal(err.Error(), "author is too short; title is too short")
+	a.Equal(HttpStatus(err), 400)
+	a.Equal(len(FieldsOf(err)), 2)
+	a.Equal(FieldsOf(err)[1].Field, "title")
+	a.Equal(len(FieldsOf(errors.New("plain"))), 0)
+}
+
+func TestUnwrap(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := Unavailable(cause)
+
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, HttpStatus(err), 503)
+}
@@ -0,0 +1,66 @@
+// Package auth carries the identity of the authenticated caller through its context.
+package auth
+
+import (
+	"context"
+	"strings"
+	"todo_pikpo/tenant"
+)
+
+// Claims is who a validated token says the caller is, static keys carry roles but no subject.
+type Claims struct {
+	// Subject is the token's sub claim, the id of the calling user.
+	Subject string `json:"sub"`
+	// Tenant is the workspace the token was issued for, empty means tenant.Default.
+	Tenant string `json:"tenant,omitempty"`
+	// Roles decide what the caller may do under the configured policy.
+	Roles []string `json:"roles,omitempty"`
+	// KeyId is the id of the API key the caller authenticated with, if any.
+	KeyId string `json:"keyId,omitempty"`
+	// ClientCert is the common name of the caller's verified TLS client certificate, empty without mTLS.
+	ClientCert string `json:"clientCert,omitempty"`
+}
+
+// keySubjectPrefix sets the subjects of API keys apart from user ids.
+const keySubjectPrefix = "apikey:"
+
+// KeySubject is the subject of callers authenticated with the API key id. Key names are chosen
+// freely by admins and only label the key, so they never serve as an identity.
+func KeySubject(id string) string {
+	return keySubjectPrefix + id
+}
+
+// IsKeySubject reports whether subject belongs to an API key.
+func IsKeySubject(subject string) bool {
+	return strings.HasPrefix(subject, keySubjectPrefix)
+}
+
+// Caller names the caller for rate limits and quotas: the API key, else the subject, else the
+// workspace, so static key holders of one workspace share their limits.
+func (c Claims) Caller() string {
+	tenantId := c.Tenant
+	if tenantId == "" {
+		tenantId = tenant.Default
+	}
+	switch {
+	case c.KeyId != "":
+		return "key:" + c.KeyId
+	case c.Subject != "":
+		return "sub:" + tenantId + ":" + c.Subject
+	}
+	return "tenant:" + tenantId
+}
+
+type ctxKey struct{}
+
+// WithClaims returns a copy of ctx carrying claims.
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, ctxKey{}, claims)
+}
+
+// ClaimsFrom returns the claims of the caller, ok is false for work that did not come
+// through the middleware such as background jobs.
+func ClaimsFrom(ctx context.Context) (claims Claims, ok bool) {
+	claims, ok = ctx.Value(ctxKey{}).(Claims)
+	return claims, ok
+}
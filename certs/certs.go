@@ -0,0 +1,147 @@
+// Package certs serves the TLS certificate and client CA bundle named in config and reloads
+// them whenever their files change, so certificates rotate without a restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"todo_pikpo/config"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ClientAuthRequire refuses connections without a client certificate signed by the CA bundle.
+	ClientAuthRequire = "require"
+	// ClientAuthOptional verifies client certificates that are sent but also lets callers in without one.
+	ClientAuthOptional = "optional"
+)
+
+// Reloader holds the current server TLS configuration, connections pick up a reloaded
+// certificate from their next handshake on.
+type Reloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	current    atomic.Pointer[tls.Config]
+}
+
+// NewReloader loads TLS_CERT_FILE, TLS_KEY_FILE and TLS_CLIENT_CA_FILE, it returns nil when
+// TLS is not configured.
+func NewReloader(conf config.ConfigApp) (*Reloader, error) {
+	if conf.TlsCertFile == "" && conf.TlsKeyFile == "" && conf.TlsClientCa == "" {
+		return nil, nil
+	}
+	if conf.TlsCertFile == "" || conf.TlsKeyFile == "" {
+		return nil, errors.New("TLS needs both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	r := &Reloader{certFile: conf.TlsCertFile, keyFile: conf.TlsKeyFile, caFile: conf.TlsClientCa}
+	if r.caFile != "" {
+		switch conf.TlsClientAuth {
+		case "", ClientAuthRequire:
+			r.clientAuth = tls.RequireAndVerifyClientCert
+		case ClientAuthOptional:
+			r.clientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("TLS_CLIENT_AUTH %q is not %s or %s", conf.TlsClientAuth, ClientAuthRequire, ClientAuthOptional)
+		}
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again, on error the previous configuration stays in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls certificate: %w", err)
+	}
+
+	next := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+		ClientAuth:   r.clientAuth,
+	}
+	if r.caFile != "" {
+		raw, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("tls client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return fmt.Errorf("tls client CA %s has no PEM certificates", r.caFile)
+		}
+		next.ClientCAs = pool
+	}
+
+	r.current.Store(next)
+	return nil
+}
+
+// TLSConfig is the server configuration to hand to grpc credentials or an http.Server,
+// every handshake uses the configuration loaded last.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Watch reloads the certificates when anything changes in the directories holding them until
+// ctx is done. Directories are watched rather than files so renames and symlink swaps, as used
+// by secret mounts, are noticed too.
+func (r *Reloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := map[string]bool{}
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file != "" {
+			dirs[filepath.Dir(file)] = true
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	// a certificate and its key are rarely written at the same instant, changes are
+	// collected for a moment before reloading
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " tls watch ", err)
+		case <-watcher.Events:
+			settle = time.After(100 * time.Millisecond)
+		case <-settle:
+			if err := r.Reload(); err != nil {
+				log.Error(time.Now().Format("2006-01-02 15:04:05"), " tls reload, keeping the previous certificate ", err)
+				continue
+			}
+			log.Info(time.Now().Format("2006-01-02 15:04:05"), " tls certificates reloaded")
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"todo_pikpo/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pikpo test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a leaf certificate and returns it as a tls.Certificate and as PEM cert and key.
+func (ca issuer) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	pair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, certPem, keyPem
+}
+
+func write(t *testing.T, path string, data []byte) {
+	// written next to the target and renamed, like a secret mount swaps files
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects to addr and returns the common name the server presented.
+func handshake(addr string, ca issuer, client *tls.Certificate) (string, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conf := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	if client != nil {
+		conf.Certificates = []tls.Certificate{*client}
+	}
+	conn, err := tls.Dial("tcp", addr, conf)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// TLS 1.3 reports a refused client certificate on the first read
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return "", err
+		}
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestNewReloader(t *testing.T) {
+	a := assert.New(t)
+
+	r, err := NewReloader(config.ConfigApp{})
+	a.Equal(err, nil)
+	a.Nil(r)
+
+	dir := t.TempDir()
+	_, certPem, keyPem := newCA(t).issue(t, "server", x509.ExtKeyUsageServerAuth)
+	write(t, filepath.Join(dir, "tls.crt"), certPem)
+	write(t, filepath.Join(dir, "tls.key"), keyPem)
+	for _, conf := range []config.ConfigApp{
+		{TlsCertFile: filepath.Join(dir, "tls.crt")},
+		{TlsClientCa: filepath.Join(dir, "tls.crt")},
+		{TlsCertFile: filepath.Join(dir, "tls.crt"), TlsKeyFile: filepath.Join(dir, "missing.key")},
+		{TlsCertFile: filepath.Join(dir, "tls.crt"), TlsKeyFile: filepath.Join(dir, "tls.key"), TlsClientCa: filepath.Join(dir, "tls.key")},
+		{TlsCertFile: filepath.Join(dir, "tls.crt"), TlsKeyFile: filepath.Join(dir, "tls.key"), TlsClientCa: filepath.Join(dir, "tls.crt"), TlsClientAuth: "sometimes"},
+	} {
+		_, err := NewReloader(conf)
+		a.NotEqual(err, nil, conf)
+	}
+}
+
+func TestMutualTlsReload(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	ca := newCA(t)
+	_, certPem, keyPem := ca.issue(t, "server one", x509.ExtKeyUsageServerAuth)
+	client, _, _ := ca.issue(t, "robot", x509.ExtKeyUsageClientAuth)
+	conf := config.ConfigApp{
+		TlsCertFile: filepath.Join(dir, "tls.crt"),
+		TlsKeyFile:  filepath.Join(dir, "tls.key"),
+		TlsClientCa: filepath.Join(dir, "ca.crt"),
+	}
+	write(t, conf.TlsCertFile, certPem)
+	write(t, conf.TlsKeyFile, keyPem)
+	write(t, conf.TlsClientCa, ca.pem)
+
+	r, err := NewReloader(conf)
+	a.Equal(err, nil)
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	a.Equal(err, nil)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+				_, _ = conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Watch(ctx) }()
+
+	cn, err := handshake(lis.Addr().String(), ca, &client)
+	a.Equal(err, nil)
+	a.Equal(cn, "server one")
+	_, err = handshake(lis.Addr().String(), ca, nil)
+	a.NotEqual(err, nil)
+
+	// give the watcher a moment to start, then rotate the server certificate on disk
+	time.Sleep(100 * time.Millisecond)
+	_, certPem, keyPem = ca.issue(t, "server two", x509.ExtKeyUsageServerAuth)
+	write(t, conf.TlsKeyFile, keyPem)
+	write(t, conf.TlsCertFile, certPem)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for cn != "server two" && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		cn, err = handshake(lis.Addr().String(), ca, &client)
+		a.Equal(err, nil)
+	}
+	a.Equal(cn, "server two")
+
+	// a broken file keeps the previous certificate
+	write(t, conf.TlsCertFile, []byte("not a certificate"))
+	time.Sleep(300 * time.Millisecond)
+	cn, err = handshake(lis.Addr().String(), ca, &client)
+	a.Equal(err, nil)
+	a.Equal(cn, "server two")
+}
@@ -6,17 +6,45 @@ import (
 )
 
 type ConfigApp struct {
-	DbUsername string `mapstructure:"PG_USERNAME"`
-	DbPassword string `mapstructure:"PG_PASS"`
-	DbName     string `mapstructure:"PG_DB"`
-	DbPort     uint16 `mapstructure:"PG_PORT"`
-	DbHost     string `mapstructure:"PG_HOST"`
-	RedisHost  string `mapstructure:"REDIS_HOST"`
-	RedisPort  string `mapstructure:"REDIS_PORT"`
-	RedisUsn   string `mapstructure:"REDIS_USN"`
-	RedisPass  string `mapstructure:"REDIS_PASS"`
-	EncryptKey string `mapstructure:"KEY"`
-	Port       uint16 `mapstructure:"PORT"`
+	DbDriver       string `mapstructure:"DB_DRIVER"`
+	DbUsername     string `mapstructure:"PG_USERNAME"`
+	DbPassword     string `mapstructure:"PG_PASS"`
+	DbName         string `mapstructure:"PG_DB"`
+	DbPort         uint16 `mapstructure:"PG_PORT"`
+	DbHost         string `mapstructure:"PG_HOST"`
+	SqlitePath     string `mapstructure:"SQLITE_PATH"`
+	RedisHost      string `mapstructure:"REDIS_HOST"`
+	RedisPort      string `mapstructure:"REDIS_PORT"`
+	RedisUsn       string `mapstructure:"REDIS_USN"`
+	RedisPass      string `mapstructure:"REDIS_PASS"`
+	CacheDriver    string `mapstructure:"CACHE_DRIVER"`
+	CachePrefix    string `mapstructure:"CACHE_PREFIX"`
+	CacheTtl       uint   `mapstructure:"CACHE_TTL"`
+	CacheSize      int    `mapstructure:"CACHE_SIZE"`
+	BrokerDriver   string `mapstructure:"BROKER_DRIVER"`
+	BrokerBuffer   int    `mapstructure:"BROKER_BUFFER"`
+	TrashRetention uint   `mapstructure:"TRASH_RETENTION"`
+	PurgeInterval  uint   `mapstructure:"PURGE_INTERVAL"`
+	EncryptKey     string `mapstructure:"KEY"`
+	TenantKeys     string `mapstructure:"TENANT_KEYS"`
+	JwtSecret      string `mapstructure:"JWT_SECRET"`
+	JwtJwksFile    string `mapstructure:"JWT_JWKS_FILE"`
+	JwtIssuer      string `mapstructure:"JWT_ISSUER"`
+	JwtAudience    string `mapstructure:"JWT_AUDIENCE"`
+	PolicyFile     string `mapstructure:"POLICY_FILE"`
+	TlsCertFile    string `mapstructure:"TLS_CERT_FILE"`
+	TlsKeyFile     string `mapstructure:"TLS_KEY_FILE"`
+	TlsClientCa    string `mapstructure:"TLS_CLIENT_CA_FILE"`
+	TlsClientAuth  string `mapstructure:"TLS_CLIENT_AUTH"`
+	RateDriver     string `mapstructure:"RATE_DRIVER"`
+	RateLimits     string `mapstructure:"RATE_LIMITS"`
+	DailyQuota     int    `mapstructure:"DAILY_TODO_QUOTA"`
+	WebhookEvery   uint   `mapstructure:"WEBHOOK_INTERVAL"`
+	WebhookRetries int    `mapstructure:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookPrivate bool   `mapstructure:"WEBHOOK_ALLOW_PRIVATE"`
+	Port           uint16 `mapstructure:"PORT"`
+	HttpPort       uint16 `mapstructure:"HTTP_PORT"`
+	ErrorEnvelope  bool   `mapstructure:"GRPC_ERROR_ENVELOPE"`
 }
 
 func NewAppConfig(filePath string) (c ConfigApp, e error) {
@@ -24,6 +52,18 @@ func NewAppConfig(filePath string) (c ConfigApp, e error) {
 	viper.SetConfigFile(filePath)
 	viper.SetConfigType("env")
 	viper.AutomaticEnv()
+	viper.SetDefault("DB_DRIVER", "postgres")
+	viper.SetDefault("CACHE_DRIVER", "redis")
+	viper.SetDefault("CACHE_PREFIX", "pikpo-")
+	viper.SetDefault("CACHE_TTL", 1200)
+	viper.SetDefault("CACHE_SIZE", 1000)
+	viper.SetDefault("BROKER_DRIVER", "memory")
+	viper.SetDefault("BROKER_BUFFER", 1000)
+	viper.SetDefault("RATE_DRIVER", "memory")
+	viper.SetDefault("TRASH_RETENTION", 720)
+	viper.SetDefault("PURGE_INTERVAL", 3600)
+	viper.SetDefault("WEBHOOK_INTERVAL", 5)
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
 	if e := viper.ReadInConfig(); e != nil {
 		log.Error("error in creating NewAppConfig with error ", e)
 	}
@@ -3,6 +3,7 @@ package config
 import (
 	"bufio"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -30,17 +31,21 @@ func createEnvFile(envVars map[string]string) error {
 func TestNewAppConfig(t *testing.T) {
 	// Call func to generate .env file
 	err := createEnvFile(map[string]string{
-		"PG_USERNAME": "testuser",
-		"PG_PASS":     "testpassword",
-		"PG_DB":       "testdb",
-		"PG_PORT":     "5432",
-		"PG_HOST":     "localhost",
-		"REDIS_HOST":  "localhost",
-		"REDIS_PORT":  "6379",
-		"REDIS_USN":   "testuser",
-		"REDIS_PASS":  "testpassword",
-		"KEY":         "testkey",
-		"PORT":        "8080",
+		"DB_DRIVER":    "sqlite",
+		"SQLITE_PATH":  "test.db",
+		"PG_USERNAME":  "testuser",
+		"PG_PASS":      "testpassword",
+		"PG_DB":        "testdb",
+		"PG_PORT":      "5432",
+		"PG_HOST":      "localhost",
+		"REDIS_HOST":   "localhost",
+		"REDIS_PORT":   "6379",
+		"REDIS_USN":    "testuser",
+		"REDIS_PASS":   "testpassword",
+		"CACHE_DRIVER": "lru",
+		"CACHE_TTL":    "60",
+		"KEY":          "testkey",
+		"PORT":         "8080",
 	})
 	if err != nil {
 		t.Error("Failed to create .env file:", err)
@@ -53,6 +58,12 @@ func TestNewAppConfig(t *testing.T) {
 		t.Errorf("Error creating config: %v", err)
 		return
 	}
+	if config.DbDriver != "sqlite" {
+		t.Errorf("Expected DbDriver to be 'sqlite', got '%s'", config.DbDriver)
+	}
+	if config.SqlitePath != "test.db" {
+		t.Errorf("Expected SqlitePath to be 'test.db', got '%s'", config.SqlitePath)
+	}
 	if config.DbUsername != "testuser" {
 		t.Errorf("Expected DbUsername to be 'testuser', got '%s'", config.DbUsername)
 	}
@@ -80,6 +91,18 @@ func TestNewAppConfig(t *testing.T) {
 	if config.RedisPass != "testpassword" {
 		t.Errorf("Expected RedisPass to be 'testpassword', got '%s'", config.RedisPass)
 	}
+	if config.CacheDriver != "lru" {
+		t.Errorf("Expected CacheDriver to be 'lru', got '%s'", config.CacheDriver)
+	}
+	if config.CacheTtl != 60 {
+		t.Errorf("Expected CacheTtl to be 60, got '%d'", config.CacheTtl)
+	}
+	if config.CachePrefix != "pikpo-" {
+		t.Errorf("Expected CachePrefix to default to 'pikpo-', got '%s'", config.CachePrefix)
+	}
+	if config.TrashRetention != 720 {
+		t.Errorf("Expected TrashRetention to default to 720, got '%d'", config.TrashRetention)
+	}
 	if config.EncryptKey != "testkey" {
 		t.Errorf("Expected EncryptKey to be 'testkey', got '%s'", config.EncryptKey)
 	}
@@ -91,3 +114,42 @@ func TestNewAppConfig(t *testing.T) {
 		defer os.Remove(".env.testonly")
 	}
 }
+
+func TestLoadPolicy(t *testing.T) {
+	p, err := LoadPolicy("")
+	if err != nil {
+		t.Fatalf("default policy: %v", err)
+	}
+	if !p.Allows([]string{"admin"}, "/todoproto.TodoService/GetTodo") {
+		t.Error("admin should inherit the viewer methods")
+	}
+	if p.Allows([]string{"viewer"}, "/todoproto.TodoService/AddTodo") {
+		t.Error("viewer should not add todos")
+	}
+	if !p.ChangesAnyAuthor([]string{"admin"}) || p.ChangesAnyAuthor([]string{"editor"}) {
+		t.Error("only admins should change todos of other authors")
+	}
+
+	if roles := p.ScopeRoles([]string{"read", "root"}); len(roles) != 1 || roles[0] != "viewer" {
+		t.Errorf("read should grant viewer and root nothing, got %v", roles)
+	}
+
+	// inheritance cycles do not loop
+	looped := Policy{Inherits: map[string][]string{"a": {"b"}, "b": {"a"}}, Methods: map[string]string{"*": "b"}}
+	if !looped.Allows([]string{"a"}, "/any") {
+		t.Error("a should inherit b")
+	}
+
+	for name, body := range map[string]string{
+		"unknown field": "methods:\n  \"*\": admin\nroless: [admin]\n",
+		"no methods":    "keyRole: admin\n",
+	} {
+		path := filepath.Join(t.TempDir(), "policy.yaml")
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadPolicy(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
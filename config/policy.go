@@ -0,0 +1,115 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed policy.yaml
+var defaultPolicy []byte
+
+// Policy decides which roles may call which RPC and who may change a todo, see policy.yaml.
+type Policy struct {
+	// KeyRole is the role of callers authenticated with a static key.
+	KeyRole string `yaml:"keyRole"`
+	// TokenRole is the role of JWTs without a roles claim.
+	TokenRole string `yaml:"tokenRole"`
+	// CertRole is the role of callers identified by a TLS client certificate alone, their
+	// certificate's common name is the subject and its organization the workspace.
+	// Empty makes certificate holders send an authorization header too.
+	CertRole string `yaml:"certRole"`
+	// Scopes maps the scopes of API keys to roles.
+	Scopes map[string]string `yaml:"scopes"`
+	// Inherits lists for a role the roles whose methods it may call too.
+	Inherits map[string][]string `yaml:"inherits"`
+	// Methods maps a gRPC full method name to the role it needs, "*" applies to unlisted methods.
+	Methods map[string]string `yaml:"methods"`
+	// AnyAuthor are the roles that may edit and delete todos written by someone else.
+	AnyAuthor []string `yaml:"anyAuthor"`
+}
+
+func parsePolicy(raw []byte) (Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(raw, &p); err != nil {
+		return Policy{}, err
+	}
+	if len(p.Methods) == 0 {
+		return Policy{}, fmt.Errorf("policy grants no method")
+	}
+	return p, nil
+}
+
+// DefaultPolicy is the built in policy.yaml: viewers read, editors write their own todos, admins do everything.
+func DefaultPolicy() Policy {
+	p, err := parsePolicy(defaultPolicy)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// LoadPolicy reads the policy file at path, the default policy when path is empty.
+func LoadPolicy(path string) (Policy, error) {
+	if path == "" {
+		return DefaultPolicy(), nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	p, err := parsePolicy(raw)
+	if err != nil {
+		return Policy{}, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// expand returns roles together with every role they inherit.
+func (p Policy) expand(roles []string) map[string]bool {
+	held := map[string]bool{}
+	queue := append([]string{}, roles...)
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+		if held[role] {
+			continue
+		}
+		held[role] = true
+		queue = append(queue, p.Inherits[role]...)
+	}
+	return held
+}
+
+// Allows reports whether a caller holding roles may call fullMethod.
+func (p Policy) Allows(roles []string, fullMethod string) bool {
+	need, ok := p.Methods[fullMethod]
+	if !ok {
+		need, ok = p.Methods["*"]
+	}
+	return ok && p.expand(roles)[need]
+}
+
+// ScopeRoles returns the roles granted by the scopes of an API key, unknown scopes grant none.
+func (p Policy) ScopeRoles(scopes []string) []string {
+	var roles []string
+	for _, scope := range scopes {
+		if role, ok := p.Scopes[scope]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// ChangesAnyAuthor reports whether a caller holding roles may change todos of other authors.
+func (p Policy) ChangesAnyAuthor(roles []string) bool {
+	held := p.expand(roles)
+	for _, role := range p.AnyAuthor {
+		if held[role] {
+			return true
+		}
+	}
+	return false
+}
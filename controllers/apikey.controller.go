@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"todo_pikpo/apperror"
+	model "todo_pikpo/database/models"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApiKeyScopes are the scopes an API key may carry, the access policy maps them to roles.
+var ApiKeyScopes = []string{"read", "write", "admin"}
+
+// apiKeyPrefix starts every API key, the key reads pk_<id>_<secret>.
+const apiKeyPrefix = "pk_"
+
+// apiKeyTouchEvery limits how often a busy key writes its last used time.
+const apiKeyTouchEvery = time.Minute
+
+// IsApiKey tells API keys apart from other bearer tokens.
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashApiKey(salt string, secret string) string {
+	sum := sha256.Sum256([]byte(salt + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyScopes checks scopes against ApiKeyScopes and drops duplicates.
+func verifyScopes(scopes []string) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		known := false
+		for _, s := range ApiKeyScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, apperror.Validation(apperror.FieldViolation{Field: "scopes", Description: "scope " + scope + " is not one of " + strings.Join(ApiKeyScopes, ", ")})
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			res = append(res, scope)
+		}
+	}
+	if len(res) == 0 {
+		return nil, apperror.Validation(apperror.FieldViolation{Field: "scopes", Description: "an API key needs at least one scope"})
+	}
+	return res, nil
+}
+
+// CreateApiKey adds an API key to the caller's workspace and returns it together with the key
+// itself, which is not stored and cannot be shown again. A zero expiresAt never expires.
+func (tc TodoController) CreateApiKey(ctx context.Context, name string, scopes []string, expiresAt time.Time) (model.ApiKeyModel, string, error) {
+	var violations []apperror.FieldViolation
+	if len(strings.TrimSpace(name)) < 3 {
+		violations = append(violations, apperror.FieldViolation{Field: "name", Description: "name should be filled with minimum 3 characters"})
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		violations = append(violations, apperror.FieldViolation{Field: "expiresAt", Description: "expiresAt should be greater than now"})
+	}
+	if len(violations) > 0 {
+		return model.ApiKeyModel{}, "", apperror.Validation(violations...)
+	}
+	scopes, err := verifyScopes(scopes)
+	if err != nil {
+		return model.ApiKeyModel{}, "", err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return model.ApiKeyModel{}, "", apperror.Internal(err)
+	}
+	salt, err := randomHex(16)
+	if err != nil {
+		return model.ApiKeyModel{}, "", apperror.Internal(err)
+	}
+
+	key := model.ApiKeyModel{
+		Id:        uuid.New().String(),
+		Name:      strings.TrimSpace(name),
+		Scopes:    strings.Join(scopes, ","),
+		Salt:      salt,
+		Hash:      hashApiKey(salt, secret),
+		CreatedAt: time.Now(),
+	}
+	if !expiresAt.IsZero() {
+		key.ExpiresAt = &expiresAt
+	}
+
+	res, err := tc.keys.Create(ctx, key)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " CreateApiKey controller ", err)
+
+		return model.ApiKeyModel{}, "", storeError(err)
+	}
+	return res, apiKeyPrefix + res.Id + "_" + secret, nil
+}
+
+// ListApiKeys returns every key of the caller's workspace, revoked and expired ones included.
+func (tc TodoController) ListApiKeys(ctx context.Context) ([]model.ApiKeyModel, error) {
+	res, err := tc.keys.List(ctx)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListApiKeys controller ", err)
+
+		return []model.ApiKeyModel{}, storeError(err)
+	}
+	return res, nil
+}
+
+// RevokeApiKey stops a key from working once grace has passed, a grace period lets clients
+// move to a new key before the old one is refused.
+func (tc TodoController) RevokeApiKey(ctx context.Context, id string, grace time.Duration) (model.ApiKeyModel, error) {
+	res, err := tc.keys.Revoke(ctx, id, time.Now().Add(grace))
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RevokeApiKey controller ", err)
+
+		return model.ApiKeyModel{}, storeError(err)
+	}
+	return res, nil
+}
+
+// VerifyApiKey returns the stored key a bearer API key belongs to. The secret is checked in
+// constant time and the error never says which part of the key was wrong.
+func (tc TodoController) VerifyApiKey(ctx context.Context, token string) (model.ApiKeyModel, error) {
+	errWrong := errors.New("api key was wrong")
+
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+	if !IsApiKey(token) || !ok {
+		return model.ApiKeyModel{}, errWrong
+	}
+	key, err := tc.keys.Lookup(ctx, id)
+	if err != nil {
+		if apperror.KindOf(storeError(err)) == apperror.KindNotFound {
+			return model.ApiKeyModel{}, errWrong
+		}
+		return model.ApiKeyModel{}, storeError(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashApiKey(key.Salt, secret)), []byte(key.Hash)) != 1 {
+		return model.ApiKeyModel{}, errWrong
+	}
+
+	now := time.Now()
+	if !key.ValidAt(now) {
+		return model.ApiKeyModel{}, errors.New("api key " + key.Id + " is expired or revoked")
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchEvery {
+		if err := tc.keys.Touch(ctx, key.Id, now); err != nil {
+			log.Warn(time.Now().Format("2006-01-02 15:04:05"), " VerifyApiKey controller ", err)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+	return key, nil
+}
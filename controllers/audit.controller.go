@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"context"
+	"time"
+	"todo_pikpo/apperror"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AuditPage is one page of ListAuditEvents, newest first.
+type AuditPage struct {
+	Events        []model.AuditEventModel `json:"events"`
+	NextPageToken string                  `json:"nextPageToken"`
+}
+
+// ListAuditEvents pages through the audit log of the caller's workspace, newest first.
+// The audit log is not cached.
+func (tc TodoController) ListAuditEvents(ctx context.Context, filter _interface.AuditFilter, pageToken string, limit uint) (AuditPage, error) {
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		err := apperror.Validation(apperror.FieldViolation{Field: "to", Description: "to should not be before from"})
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListAuditEvents controller ", err)
+
+		return AuditPage{}, err
+	}
+	after, err := decodeAuditToken(pageToken, filter)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListAuditEvents controller ", err)
+
+		return AuditPage{}, err
+	}
+
+	// one extra event tells whether another page follows
+	events, err := tc.audit.List(ctx, filter, after, limit+1)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListAuditEvents controller ", err)
+
+		return AuditPage{}, storeError(err)
+	}
+
+	page := AuditPage{Events: events}
+	if len(events) > int(limit) {
+		page.Events = events[:limit]
+		page.NextPageToken = encodeAuditToken(page.Events[limit-1], filter)
+	}
+	return page, nil
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxBatchSize is the largest number of items a single batch call accepts.
+const MaxBatchSize = 500
+
+// BatchItem is the outcome of one item of a batch, Err is nil when the item was applied.
+type BatchItem struct {
+	Todo model.TodoModel
+	Err  error
+}
+
+// BatchEdit is one item of BatchEditTodo, Fields and Data.Version work as in EditTodo.
+type BatchEdit struct {
+	Id     string
+	Data   model.TodoModel
+	Fields []string
+}
+
+// BatchDelete is one item of BatchDeleteTodo, ExpectedVersion works as in DeleteTodo.
+type BatchDelete struct {
+	Id              string
+	ExpectedVersion uint64
+}
+
+// runBatch applies every item through apply. In atomic mode all items share one transaction
+// and the first failure rolls back the whole batch, otherwise each item stands on its own.
+// The returned error is only set when the batch as a whole could not run.
+func (tc TodoController) runBatch(ctx context.Context, size int, atomic bool, apply func(store _interface.DtoInterface[model.TodoModel], i int) (model.TodoModel, error)) ([]BatchItem, error) {
+	if size == 0 {
+		return nil, apperror.Validation(apperror.FieldViolation{Field: "data", Description: "batch should contain at least one item"})
+	}
+	if size > MaxBatchSize {
+		return nil, apperror.Validation(apperror.FieldViolation{Field: "data", Description: fmt.Sprintf("batch should contain at most %d items", MaxBatchSize)})
+	}
+
+	results := make([]BatchItem, size)
+	if !atomic {
+		for i := range results {
+			results[i].Todo, results[i].Err = apply(tc.dto, i)
+		}
+		return results, nil
+	}
+
+	failed := -1
+	err := tc.dto.Transaction(ctx, func(tx _interface.DtoInterface[model.TodoModel]) error {
+		for i := range results {
+			res, err := apply(tx, i)
+			if err != nil {
+				failed = i
+				results[i].Err = err
+				return err
+			}
+			results[i].Todo = res
+		}
+		return nil
+	})
+	if err != nil {
+		if failed < 0 {
+			// the items went through but the commit did not
+			return nil, storeError(err)
+		}
+		for i := range results {
+			if i != failed {
+				results[i] = BatchItem{Err: apperror.Conflict("batch rolled back, item %d failed", failed)}
+			}
+		}
+	}
+	return results, nil
+}
+
+// settleBatch does the per-write side effects once for the whole batch: a single list invalidation,
+// evicting the touched ids and publishing an event for every applied item.
+func (tc TodoController) settleBatch(ctx context.Context, eventType string, results []BatchItem) {
+	var ids []string
+	for _, r := range results {
+		if r.Err == nil {
+			ids = append(ids, r.Todo.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	if eventType != database.EventCreated {
+		tc.invalidateTodos(ctx, ids...)
+	}
+	tc.invalidateLists(ctx)
+	for _, r := range results {
+		if r.Err == nil {
+			tc.publish(eventType, r.Todo)
+		}
+	}
+}
+
+func (tc TodoController) BatchAddTodo(ctx context.Context, data []model.TodoModel, atomic bool) ([]BatchItem, error) {
+	// created counts the items that took quota, those a rolled back transaction took out again give it back
+	created := 0
+	results, err := tc.runBatch(ctx, len(data), atomic, func(store _interface.DtoInterface[model.TodoModel], i int) (model.TodoModel, error) {
+		res, err := tc.create(ctx, store, data[i])
+		if err == nil {
+			created++
+		}
+		return res, err
+	})
+	stored := 0
+	for _, r := range results {
+		if r.Err == nil {
+			stored++
+		}
+	}
+	tc.refundQuota(ctx, created-stored)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " BatchAddTodo controller ", err)
+
+		return nil, err
+	}
+
+	tc.settleBatch(ctx, database.EventCreated, results)
+
+	return results, nil
+}
+
+func (tc TodoController) BatchEditTodo(ctx context.Context, data []BatchEdit, atomic bool) ([]BatchItem, error) {
+	results, err := tc.runBatch(ctx, len(data), atomic, func(store _interface.DtoInterface[model.TodoModel], i int) (model.TodoModel, error) {
+		return tc.update(ctx, store, data[i].Id, data[i].Data, data[i].Fields)
+	})
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " BatchEditTodo controller ", err)
+
+		return nil, err
+	}
+
+	tc.settleBatch(ctx, database.EventUpdated, results)
+
+	return results, nil
+}
+
+// BatchDeleteTodo removes every id, each applied item carries the todo as it was before the delete.
+func (tc TodoController) BatchDeleteTodo(ctx context.Context, data []BatchDelete, atomic bool) ([]BatchItem, error) {
+	results, err := tc.runBatch(ctx, len(data), atomic, func(store _interface.DtoInterface[model.TodoModel], i int) (model.TodoModel, error) {
+		current, _, err := tc.remove(ctx, store, data[i].Id, data[i].ExpectedVersion)
+		return current, err
+	})
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " BatchDeleteTodo controller ", err)
+
+		return nil, err
+	}
+
+	tc.settleBatch(ctx, database.EventDeleted, results)
+
+	return results, nil
+}
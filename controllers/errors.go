@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"todo_pikpo/apperror"
+
+	"gorm.io/gorm"
+)
+
+// storeError translates errors coming from the DtoInterface into domain errors,
+// errors that already are domain errors are returned untouched.
+func storeError(err error) error {
+	var appErr *apperror.Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return apperror.Wrap(apperror.KindNotFound, err)
+	case errors.Is(err, driver.ErrBadConn), errors.As(err, &netErr):
+		return apperror.Unavailable(err)
+	}
+	return apperror.Internal(err)
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"todo_pikpo/apperror"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestStoreError(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(apperror.KindOf(storeError(gorm.ErrRecordNotFound)), apperror.KindNotFound)
+	a.Equal(apperror.KindOf(storeError(fmt.Errorf("query: %w", driver.ErrBadConn))), apperror.KindUnavailable)
+	a.Equal(apperror.KindOf(storeError(errors.New("syntax error"))), apperror.KindInternal)
+	a.Equal(apperror.KindOf(storeError(apperror.Conflict("stale"))), apperror.KindConflict)
+	a.True(errors.Is(storeError(gorm.ErrRecordNotFound), gorm.ErrRecordNotFound))
+}
+
+func TestVerifyFields(t *testing.T) {
+	a := assert.New(t)
+
+	err := TodoController{}.verify(&model.TodoModel{
+		Author:    "-",
+		Title:     "test",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(-1 * time.Hour),
+	})
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+
+	var fields []string
+	for _, f := range apperror.FieldsOf(err) {
+		fields = append(fields, f.Field)
+	}
+	a.Equal(fields, []string{"author", "title", "endDate", "endDate"})
+}
+
+func TestParseOrderBy(t *testing.T) {
+	a := assert.New(t)
+
+	keys, err := ParseOrderBy(" end_date DESC,title , created_at asc")
+	a.Equal(err, nil)
+	a.Equal(keys, []_interface.SortKey{{Column: "end_date", Desc: true}, {Column: "title"}, {Column: "created_at"}})
+
+	keys, err = ParseOrderBy("")
+	a.Equal(err, nil)
+	a.Equal(len(keys), 0)
+
+	for _, bad := range []string{"author", "title sideways", "title,", "title, title desc", "id", "title desc extra"} {
+		_, err = ParseOrderBy(bad)
+		a.Equal(apperror.KindOf(err), apperror.KindValidation, bad)
+		a.Equal(apperror.FieldsOf(err)[0].Field, "orderBy", bad)
+	}
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"todo_pikpo/apperror"
+	"todo_pikpo/dto"
+	_interface "todo_pikpo/interface"
+)
+
+func orderByError(format string, args ...interface{}) error {
+	return apperror.Validation(apperror.FieldViolation{Field: "orderBy", Description: fmt.Sprintf(format, args...)})
+}
+
+// ParseOrderBy reads a comma separated sort order such as "end_date, title desc",
+// each key is a column optionally followed by asc or desc.
+func ParseOrderBy(orderBy string) ([]_interface.SortKey, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+
+	var keys []_interface.SortKey
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, orderByError("%q is not a column optionally followed by asc or desc", strings.TrimSpace(part))
+		}
+
+		key := _interface.SortKey{Column: strings.ToLower(words[0])}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				key.Desc = true
+			default:
+				return nil, orderByError("direction of %s should be asc or desc", key.Column)
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	if err := verifyOrderBy(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// verifyOrderBy only lets whitelisted columns through, each at most once.
+func verifyOrderBy(keys []_interface.SortKey) error {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		allowed := false
+		for _, column := range dto.SortColumns {
+			if key.Column == column {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return orderByError("cannot order by %q, use one of %s", key.Column, strings.Join(dto.SortColumns, ", "))
+		}
+		if seen[key.Column] {
+			return orderByError("%s is listed more than once", key.Column)
+		}
+		seen[key.Column] = true
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+	"todo_pikpo/apperror"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+)
+
+// defaultPageSize applies when GetTodosPage is called without a limit.
+const defaultPageSize = 10
+
+// SearchPage is one page of SearchTodos, best match first.
+type SearchPage struct {
+	Hits          []_interface.SearchHit[model.TodoModel] `json:"hits"`
+	NextPageToken string                                  `json:"nextPageToken"`
+	TotalSize     int64                                   `json:"totalSize"`
+}
+
+// TodoPage is one page of GetTodosPage.
+type TodoPage struct {
+	Todos         []model.TodoModel `json:"todos"`
+	NextPageToken string            `json:"nextPageToken"`
+	TotalSize     int64             `json:"totalSize"`
+}
+
+// pageToken is the decoded form of an opaque page token. Filter pins the token
+// to the query it was issued for, so it can't silently page through another one.
+type pageToken struct {
+	CreatedAt int64  `json:"c,omitempty"`
+	Id        string `json:"i,omitempty"`
+	Offset    uint   `json:"o,omitempty"`
+	Filter    string `json:"f"`
+
+	// only set for the columns OrderBy sorts on, a start date may well be the zero time
+	UpdatedAt *time.Time `json:"u,omitempty"`
+	StartDate *time.Time `json:"s,omitempty"`
+	EndDate   *time.Time `json:"e,omitempty"`
+	Title     *string    `json:"t,omitempty"`
+}
+
+var errPageToken = apperror.Validation(apperror.FieldViolation{
+	Field:       "pageToken",
+	Description: "pageToken is invalid or was issued for another filter",
+})
+
+func filterHash(filter interface{}) string {
+	jd, _ := json.Marshal(filter)
+	sum := md5.Sum(jd)
+	return hex.EncodeToString(sum[:4])
+}
+
+// cursorOf is the position of data in a sorted list.
+func cursorOf(data model.TodoModel) _interface.Cursor {
+	return _interface.Cursor{
+		CreatedAt: data.CreatedAt,
+		UpdatedAt: data.UpdatedAt,
+		StartDate: data.StartDate,
+		EndDate:   data.EndDate,
+		Title:     data.Title,
+		Id:        data.Id,
+	}
+}
+
+// encodePageToken only keeps the cursor columns filter.OrderBy sorts on, that keeps tokens short.
+func encodePageToken(after _interface.Cursor, filter _interface.TodoQueryFilter) string {
+	pt := pageToken{
+		CreatedAt: after.CreatedAt.UnixNano(),
+		Id:        after.Id,
+		Filter:    filterHash(filter),
+	}
+	for _, key := range filter.OrderBy {
+		switch key.Column {
+		case "updated_at":
+			pt.UpdatedAt = &after.UpdatedAt
+		case "start_date":
+			pt.StartDate = &after.StartDate
+		case "end_date":
+			pt.EndDate = &after.EndDate
+		case "title":
+			pt.Title = &after.Title
+		}
+	}
+	jd, _ := json.Marshal(pt)
+	return base64.RawURLEncoding.EncodeToString(jd)
+}
+
+func decodePageToken(token string, filter _interface.TodoQueryFilter) (_interface.Cursor, error) {
+	if token == "" {
+		return _interface.Cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return _interface.Cursor{}, errPageToken
+	}
+	var pt pageToken
+	if err := json.Unmarshal(raw, &pt); err != nil || pt.Id == "" || pt.Filter != filterHash(filter) {
+		return _interface.Cursor{}, errPageToken
+	}
+	after := _interface.Cursor{CreatedAt: time.Unix(0, pt.CreatedAt), Id: pt.Id}
+	if pt.UpdatedAt != nil {
+		after.UpdatedAt = pt.UpdatedAt.Local()
+	}
+	if pt.StartDate != nil {
+		after.StartDate = pt.StartDate.Local()
+	}
+	if pt.EndDate != nil {
+		after.EndDate = pt.EndDate.Local()
+	}
+	if pt.Title != nil {
+		after.Title = *pt.Title
+	}
+	return after, nil
+}
+
+// Ranked results have no stable key to continue from, search pages are offsets wrapped in the same opaque token.
+func encodeOffsetToken(offset uint, query string) string {
+	jd, _ := json.Marshal(pageToken{Offset: offset, Filter: filterHash(query)})
+	return base64.RawURLEncoding.EncodeToString(jd)
+}
+
+func decodeOffsetToken(token string, query string) (uint, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errPageToken
+	}
+	var pt pageToken
+	if err := json.Unmarshal(raw, &pt); err != nil || pt.Offset == 0 || pt.Filter != filterHash(query) {
+		return 0, errPageToken
+	}
+	return pt.Offset, nil
+}
+
+// Audit events are always newest first, their tokens only need the time and id of the last event.
+func encodeAuditToken(last model.AuditEventModel, filter _interface.AuditFilter) string {
+	jd, _ := json.Marshal(pageToken{CreatedAt: last.CreatedAt.UnixNano(), Id: last.Id, Filter: filterHash(filter)})
+	return base64.RawURLEncoding.EncodeToString(jd)
+}
+
+func decodeAuditToken(token string, filter _interface.AuditFilter) (_interface.Cursor, error) {
+	if token == "" {
+		return _interface.Cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return _interface.Cursor{}, errPageToken
+	}
+	var pt pageToken
+	if err := json.Unmarshal(raw, &pt); err != nil || pt.Id == "" || pt.Filter != filterHash(filter) {
+		return _interface.Cursor{}, errPageToken
+	}
+	return _interface.Cursor{CreatedAt: time.Unix(0, pt.CreatedAt), Id: pt.Id}, nil
+}
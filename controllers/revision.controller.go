@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// TodoRevision is one version of a todo with the fields it changed from the revision before,
+// the first revision lists every field it set.
+type TodoRevision struct {
+	model.TodoRevisionModel
+	Changes []model.FieldChange `json:"changes"`
+}
+
+// GetTodoHistory lists the revisions of a todo, oldest first. Todos in the trash are NotFound
+// like everywhere else, restore them to see their history.
+func (tc TodoController) GetTodoHistory(ctx context.Context, id string) ([]TodoRevision, error) {
+	if _, err := tc.dto.GetSingle(ctx, id); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodoHistory controller ", err)
+
+		return []TodoRevision{}, storeError(err)
+	}
+	revisions, err := tc.revisions.List(ctx, id)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodoHistory controller ", err)
+
+		return []TodoRevision{}, storeError(err)
+	}
+
+	history := make([]TodoRevision, 0, len(revisions))
+	var previous *model.TodoModel
+	for _, revision := range revisions {
+		todo := revision.Todo()
+		history = append(history, TodoRevision{TodoRevisionModel: revision, Changes: model.TodoChanges(previous, &todo)})
+		previous = &todo
+	}
+	return history, nil
+}
+
+// RevertTodo brings the fields of a todo back to how the given version left them. The revert is an
+// edit of its own: it gets a new version, goes through the same validation and author checks, and
+// a non zero expectedVersion makes it conditional like EditTodo.
+func (tc TodoController) RevertTodo(ctx context.Context, id string, version uint64, expectedVersion uint64) (model.TodoModel, error) {
+	current, err := tc.dto.GetSingle(ctx, id)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RevertTodo controller ", err)
+
+		return model.TodoModel{}, storeError(err)
+	}
+	revision, err := tc.revisions.Get(ctx, id, version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = apperror.NotFound("todo %s has no revision %d", id, version)
+	}
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RevertTodo controller ", err)
+
+		return model.TodoModel{}, storeError(err)
+	}
+	if expectedVersion != 0 && expectedVersion != current.Version {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RevertTodo controller ", database.ErrVersionConflict)
+
+		return model.TodoModel{}, database.ErrVersionConflict
+	}
+
+	// only the fields that differ are written, so unchanged ones are not validated again
+	target := revision.Todo()
+	var fields []string
+	for _, change := range model.TodoChanges(&current, &target) {
+		fields = append(fields, change.Field)
+	}
+	if len(fields) == 0 {
+		return current, nil
+	}
+	target.Version = current.Version
+	return tc.EditTodo(ctx, id, target, fields...)
+}
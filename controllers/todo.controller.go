@@ -1,49 +1,169 @@
 package controllers
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/auth"
+	"todo_pikpo/config"
 	"todo_pikpo/database"
 	model "todo_pikpo/database/models"
 	"todo_pikpo/dto"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 type TodoController struct {
-	dto dto.TodoDTO
+	dto       _interface.DtoInterface[model.TodoModel]
+	keys      _interface.ApiKeyInterface
+	audit     _interface.AuditInterface
+	revisions _interface.RevisionInterface
+	webhooks  _interface.WebhookInterface
+	db        *database.Database
+	flight    *singleflight.Group
+	// hookClient sends webhooks, a delivery is dead lettered after hookAttempts failed attempts.
+	// hookPrivate lets webhooks reach private addresses.
+	hookClient   *http.Client
+	hookAttempts int
+	hookPrivate  bool
+	// policy decides whose todos a caller may edit and delete
+	policy config.Policy
+	// quota is how many todos one caller may create per UTC day, 0 is unlimited
+	quota int
 }
 
-func (tc TodoController) verify(data *model.TodoModel) (int, error) {
+// listCacheQuery is everything that shapes a GetTodos or GetTodosPage result, all of it goes into the cache key.
+type listCacheQuery struct {
+	Filter    _interface.TodoQueryFilter `json:"filter"`
+	Page      uint                       `json:"page"`
+	Limit     uint                       `json:"limit"`
+	Keyset    bool                       `json:"keyset,omitempty"`
+	PageToken string                     `json:"pageToken,omitempty"`
+	Search    string                     `json:"search,omitempty"`
+}
+
+// Editable todo fields as named by the API, EditTodo field masks are made of these.
+const (
+	FieldAuthor      = "author"
+	FieldTitle       = "title"
+	FieldDescription = "description"
+	FieldIsDone      = "isDone"
+	FieldStartDate   = "startDate"
+	FieldEndDate     = "endDate"
+)
+
+var editableFields = []string{FieldAuthor, FieldTitle, FieldDescription, FieldIsDone, FieldStartDate, FieldEndDate}
+
+func (tc TodoController) verify(data *model.TodoModel) error {
+	return tc.verifyFields(data, editableFields)
+}
+
+// verifyFields runs only the rules touching the given fields, a date ordering rule runs when either date is given.
+func (tc TodoController) verifyFields(data *model.TodoModel, fields []string) error {
 	now := time.Now()
-	if len(data.Author) < 3 {
-		return 400, errors.New("author column should be filled with minimum 3 characters")
+	has := map[string]bool{}
+	for _, f := range fields {
+		has[f] = true
+	}
+
+	var violations []apperror.FieldViolation
+	if has[FieldAuthor] && len(data.Author) < 3 {
+		violations = append(violations, apperror.FieldViolation{Field: "author", Description: "author column should be filled with minimum 3 characters"})
 	}
-	if len(data.Title) < 5 {
-		return 400, errors.New("title column should be filled with minimum of 5 characters")
+	if has[FieldTitle] && len(data.Title) < 5 {
+		violations = append(violations, apperror.FieldViolation{Field: "title", Description: "title column should be filled with minimum of 5 characters"})
 	}
-	if data.EndDate.Unix() <= data.StartDate.Unix() {
-		return 400, errors.New("EndDate should be greater than StartDate")
+	if (has[FieldStartDate] || has[FieldEndDate]) && data.EndDate.Unix() <= data.StartDate.Unix() {
+		violations = append(violations, apperror.FieldViolation{Field: "endDate", Description: "EndDate should be greater than StartDate"})
 	}
-	if data.EndDate.Unix() <= now.Unix() {
-		return 400, errors.New("EndDate should be greater than now")
+	if has[FieldEndDate] && data.EndDate.Unix() <= now.Unix() {
+		violations = append(violations, apperror.FieldViolation{Field: "endDate", Description: "EndDate should be greater than now"})
 	}
 
-	return 200, nil
+	if len(violations) > 0 {
+		return apperror.Validation(violations...)
+	}
+	return nil
 }
 
-func (tc TodoController) AddTodo(data model.TodoModel) (model.TodoModel, int, error) {
-	if code, err := tc.verify(&data); err != nil {
-		log.Error(time.Now().Format("2006-01-02 15:04:05"), " AddTodo controller ", err)
+// verifyFilter rejects date ranges that can never match and sort orders on unknown columns.
+func (tc TodoController) verifyFilter(filter _interface.TodoQueryFilter) error {
+	if err := verifyOrderBy(filter.OrderBy); err != nil {
+		return err
+	}
+
+	var violations []apperror.FieldViolation
+	if !filter.StartFrom.IsZero() && !filter.StartTo.IsZero() && filter.StartTo.Before(filter.StartFrom) {
+		violations = append(violations, apperror.FieldViolation{Field: "startTo", Description: "startTo should not be before startFrom"})
+	}
+	if !filter.EndFrom.IsZero() && !filter.EndTo.IsZero() && filter.EndTo.Before(filter.EndFrom) {
+		violations = append(violations, apperror.FieldViolation{Field: "endTo", Description: "endTo should not be before endFrom"})
+	}
+
+	if len(violations) > 0 {
+		return apperror.Validation(violations...)
+	}
+	return nil
+}
+
+// applyMask copies the masked fields of data onto current, an empty mask copies every editable field.
+func applyMask(current model.TodoModel, data model.TodoModel, fields []string) (model.TodoModel, []string, error) {
+	if len(fields) == 0 {
+		fields = editableFields
+	}
+
+	for _, f := range fields {
+		switch f {
+		case FieldAuthor:
+			current.Author = data.Author
+		case FieldTitle:
+			current.Title = data.Title
+		case FieldDescription:
+			current.Description = data.Description
+		case FieldIsDone:
+			current.IsDone = data.IsDone
+		case FieldStartDate:
+			current.StartDate = data.StartDate
+		case FieldEndDate:
+			current.EndDate = data.EndDate
+		default:
+			return model.TodoModel{}, nil, apperror.Validation(apperror.FieldViolation{
+				Field:       "updateMask",
+				Description: fmt.Sprintf("%s is not an editable field", f),
+			})
+		}
+	}
+	return current, fields, nil
+}
 
-		return model.TodoModel{}, code, err
+// create validates and stores a new todo through store, which is tc.dto or a batch transaction.
+// An empty Author defaults to the authenticated caller, see checkAuthorName for other ones.
+func (tc TodoController) create(ctx context.Context, store _interface.DtoInterface[model.TodoModel], data model.TodoModel) (model.TodoModel, error) {
+	if claims, ok := auth.ClaimsFrom(ctx); ok && claims.Subject != "" && data.Author == "" {
+		data.Author = claims.Subject
+	}
+	if err := tc.checkAuthorName(ctx, data.Author); err != nil {
+		return model.TodoModel{}, err
+	}
+	if err := tc.verify(&data); err != nil {
+		return model.TodoModel{}, err
+	}
+	// the quota is taken up front so concurrent creates cannot pass it, and given back if the insert fails
+	if err := tc.spendQuota(ctx); err != nil {
+		return model.TodoModel{}, err
 	}
 
-	res, err := tc.dto.Create(model.TodoModel{
+	res, err := store.Create(ctx, model.TodoModel{
 		Id:          uuid.New().String(),
 		Author:      data.Author,
 		Title:       data.Title,
@@ -53,128 +173,470 @@ func (tc TodoController) AddTodo(data model.TodoModel) (model.TodoModel, int, er
 		EndDate:     data.EndDate,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		Version:     1,
 	})
+	if err != nil {
+		tc.refundQuota(ctx, 1)
+		return model.TodoModel{}, storeError(err)
+	}
+	return res, nil
+}
+
+func (tc TodoController) AddTodo(ctx context.Context, data model.TodoModel) (model.TodoModel, error) {
+	res, err := tc.create(ctx, tc.dto, data)
 	if err != nil {
 		log.Error(time.Now().Format("2006-01-02 15:04:05"), " AddTodo controller ", err)
-		return model.TodoModel{}, 500, err
+
+		return model.TodoModel{}, err
 	}
 
 	//Revoke data from redis too
-	_ = tc.dto.Db.RedisRemove("list-")
+	tc.invalidateLists(ctx)
+	tc.publish(database.EventCreated, res)
+
+	return res, nil
+}
+
+// todoNamespace is the cache namespace of one todo, it carries the workspace so a cached todo is never served to another one.
+func todoNamespace(ctx context.Context, id string) string {
+	return fmt.Sprintf("todo-%s-%s", tenant.From(ctx), id)
+}
 
-	return res, 200, nil
+// todoCacheKey builds the cache key of a todo under the current version of its namespace. A load that
+// overlaps a write stores the old row under the version the write left behind, where nobody reads it.
+// ok is false when the version can't be read, the cache is skipped then like it is for lists.
+func (tc TodoController) todoCacheKey(ctx context.Context, id string) (key string, ok bool) {
+	namespace := todoNamespace(ctx, id)
+	version, err := tc.db.Cache.Version(namespace)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s-%d", namespace, version), true
 }
 
-func (tc TodoController) GetTodos(filter map[string]interface{}, page uint, limit uint) ([]model.TodoModel, int, error) {
+// invalidateTodos moves the cached copies of ids to new namespace versions, old entries expire via TTL.
+func (tc TodoController) invalidateTodos(ctx context.Context, ids ...string) {
+	for _, id := range ids {
+		if _, err := tc.db.Cache.Bump(todoNamespace(ctx, id)); err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " invalidateTodos controller ", err)
+		}
+	}
+}
+
+// listNamespace is the cache namespace of the lists of the workspace bound to ctx.
+func listNamespace(ctx context.Context) string {
+	return "list-" + tenant.From(ctx)
+}
+
+// listCacheKey builds the cache key of a list query under the current version of the workspace's list namespace.
+// ok is false when the version can't be read, the cache is skipped then rather than risking stale lists.
+// Overdue lists are never cached, they change as deadlines pass without any write to invalidate them.
+func (tc TodoController) listCacheKey(ctx context.Context, query listCacheQuery) (key string, ok bool) {
+	if query.Filter.Overdue {
+		return "", false
+	}
+	jd, err := json.Marshal(query)
+	if err != nil {
+		return "", false
+	}
+	namespace := listNamespace(ctx)
+	version, err := tc.db.Cache.Version(namespace)
+	if err != nil {
+		return "", false
+	}
+	md5hash := md5.Sum(jd)
+	return fmt.Sprintf("%s-%d-%s", namespace, version, hex.EncodeToString(md5hash[:])), true
+}
+
+// invalidateLists moves every cached list of the workspace to a new namespace version, old entries expire via TTL.
+func (tc TodoController) invalidateLists(ctx context.Context) {
+	if _, err := tc.db.Cache.Bump(listNamespace(ctx)); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " invalidateLists controller ", err)
+	}
+}
+
+// cachedList serves query from the list cache, identical concurrent misses share one load.
+func cachedList[T any](ctx context.Context, tc TodoController, query listCacheQuery, load func() (T, error)) (T, error) {
 	// Get data from redis first
-	var data []model.TodoModel
-	jd, e0 := json.Marshal(filter)
-	if e0 == nil {
-		md5hash := md5.Sum(jd)
-		hashed := hex.EncodeToString(md5hash[:])
-		eRedis := tc.dto.Db.GetRedis("list-"+hashed, &data)
+	var data T
+	key, cacheable := tc.listCacheKey(ctx, query)
+	if cacheable {
+		eRedis := tc.db.Cache.Get(key, &data)
 		if eRedis == nil {
-			return data, 200, nil
+			return data, nil
+		}
+	}
+
+	// Get data from postgres, identical concurrent misses share one query
+	fill := func() (interface{}, error) {
+		res, err := load()
+		if err != nil {
+			return nil, err
+		}
+
+		// Insert data into redis
+		if cacheable {
+			_ = tc.db.Cache.Set(key, res)
 		}
+		return res, nil
 	}
 
-	// Get data from postgres
-	data, err := tc.dto.GetMany(filter, page, limit)
+	var res interface{}
+	var err error
+	if cacheable {
+		res, err, _ = tc.flight.Do(key, fill)
+	} else {
+		res, err = fill()
+	}
+	if err != nil {
+		return data, err
+	}
+	return res.(T), nil
+}
+
+// GetTodos returns one offset page, prefer GetTodosPage which stays stable while todos are added.
+func (tc TodoController) GetTodos(ctx context.Context, filter _interface.TodoQueryFilter, page uint, limit uint) ([]model.TodoModel, error) {
+	if err := tc.verifyFilter(filter); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodos controller ", err)
+
+		return []model.TodoModel{}, err
+	}
+
+	res, err := cachedList(ctx, tc, listCacheQuery{Filter: filter, Page: page, Limit: limit}, func() ([]model.TodoModel, error) {
+		return tc.dto.GetMany(ctx, filter, page, limit)
+	})
 	if err != nil {
 		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodos controller ", err)
 
-		return []model.TodoModel{}, 500, err
+		return []model.TodoModel{}, storeError(err)
+	}
+
+	return res, nil
+}
+
+// GetTodosPage returns the page after pageToken, an empty token starts at the first todo.
+// NextPageToken is empty on the last page.
+func (tc TodoController) GetTodosPage(ctx context.Context, filter _interface.TodoQueryFilter, pageToken string, limit uint) (TodoPage, error) {
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if err := tc.verifyFilter(filter); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodosPage controller ", err)
+
+		return TodoPage{}, err
 	}
+	after, err := decodePageToken(pageToken, filter)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodosPage controller ", err)
 
-	// Insert data into redis
-	if e0 == nil {
-		md5hash := md5.Sum(jd)
-		hashed := hex.EncodeToString(md5hash[:])
-		_ = tc.dto.Db.AddRedis("list-"+hashed, data)
+		return TodoPage{}, err
 	}
 
-	return data, 200, nil
+	query := listCacheQuery{Filter: filter, Limit: limit, Keyset: true, PageToken: pageToken}
+	res, err := cachedList(ctx, tc, query, func() (TodoPage, error) {
+		// one extra row tells whether another page follows
+		rows, err := tc.dto.GetAfter(ctx, filter, after, limit+1)
+		if err != nil {
+			return TodoPage{}, err
+		}
+		total, err := tc.dto.Count(ctx, filter)
+		if err != nil {
+			return TodoPage{}, err
+		}
 
+		page := TodoPage{Todos: rows, TotalSize: total}
+		if len(rows) > int(limit) {
+			page.Todos = rows[:limit]
+			last := page.Todos[limit-1]
+			page.NextPageToken = encodePageToken(cursorOf(last), filter)
+		}
+		return page, nil
+	})
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodosPage controller ", err)
+
+		return TodoPage{}, storeError(err)
+	}
+
+	return res, nil
 }
 
-func (tc TodoController) GetTodo(id string) (model.TodoModel, int, error) {
+// SearchTodos runs a free text search over title and description, best match first.
+func (tc TodoController) SearchTodos(ctx context.Context, query string, pageToken string, limit uint) (SearchPage, error) {
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		err := apperror.Validation(apperror.FieldViolation{Field: "query", Description: "query should not be empty"})
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " SearchTodos controller ", err)
 
-	// Get data from redis first
+		return SearchPage{}, err
+	}
+	offset, err := decodeOffsetToken(pageToken, query)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " SearchTodos controller ", err)
+
+		return SearchPage{}, err
+	}
+
+	cacheQuery := listCacheQuery{Limit: limit, PageToken: pageToken, Search: query}
+	res, err := cachedList(ctx, tc, cacheQuery, func() (SearchPage, error) {
+		hits, total, err := tc.dto.Search(ctx, query, offset, limit)
+		if err != nil {
+			return SearchPage{}, err
+		}
+
+		page := SearchPage{Hits: hits, TotalSize: total}
+		if next := offset + uint(len(hits)); len(hits) == int(limit) && int64(next) < total {
+			page.NextPageToken = encodeOffsetToken(next, query)
+		}
+		return page, nil
+	})
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " SearchTodos controller ", err)
+
+		return SearchPage{}, storeError(err)
+	}
+
+	return res, nil
+}
+
+func (tc TodoController) GetTodo(ctx context.Context, id string) (model.TodoModel, error) {
+
+	// Get data from redis first, the version is read before the row so a write in between moves past it
 	var data model.TodoModel
-	err := tc.dto.Db.GetRedis(id, &data)
-	if err == nil {
-		return data, 200, nil
+	key, cacheable := tc.todoCacheKey(ctx, id)
+	if cacheable {
+		if err := tc.db.Cache.Get(key, &data); err == nil {
+			return data, nil
+		}
+	} else {
+		key = todoNamespace(ctx, id)
 	}
 
-	// Get data from postgres
-	data, err = tc.dto.GetSingle(id)
+	// Get data from postgres, a burst of misses for the same id shares one query
+	res, err, _ := tc.flight.Do(key, func() (interface{}, error) {
+		res, err := tc.dto.GetSingle(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		// Insert data into redis
+		if cacheable {
+			_ = tc.db.Cache.Set(key, res)
+		}
+		return res, nil
+	})
 
 	if err != nil {
 		log.Error(time.Now().Format("2006-01-02 15:04:05"), " GetTodo controller ", err)
 
-		return model.TodoModel{}, 404, err
+		return model.TodoModel{}, storeError(err)
 	}
 
-	// Insert data into redis
-	_ = tc.dto.Db.AddRedis(id, data)
+	return res.(model.TodoModel), nil
+}
 
-	return data, 200, nil
+// checkAuthor lets the caller change current when they wrote it or hold a role the policy
+// lets change any author's todos. Work that did not come through the middleware is trusted.
+func (tc TodoController) checkAuthor(ctx context.Context, current model.TodoModel) error {
+	claims, ok := auth.ClaimsFrom(ctx)
+	if !ok || (claims.Subject != "" && claims.Subject == current.Author) || tc.policy.ChangesAnyAuthor(claims.Roles) {
+		return nil
+	}
+	return apperror.PermissionDenied("todo %s belongs to another author", current.Id)
 }
 
-func (tc TodoController) EditTodo(id string, data model.TodoModel) (model.TodoModel, int, error) {
-	if code, err := tc.verify(&data); err != nil {
-		log.Error(time.Now().Format("2006-01-02 15:04:05"), " EditTodo controller ", err)
+// checkAuthorName keeps callers with a subject from writing todos under another author's name,
+// unless they hold a role the policy lets change any author's todos.
+func (tc TodoController) checkAuthorName(ctx context.Context, author string) error {
+	claims, ok := auth.ClaimsFrom(ctx)
+	if !ok || claims.Subject == "" || claims.Subject == author || tc.policy.ChangesAnyAuthor(claims.Roles) {
+		return nil
+	}
+	return apperror.PermissionDenied("%s may not write todos as %s", claims.Subject, author)
+}
 
-		return model.TodoModel{}, code, err
+// update changes the masked fields of an existing todo through store, only those fields are validated.
+func (tc TodoController) update(ctx context.Context, store _interface.DtoInterface[model.TodoModel], id string, data model.TodoModel, fields []string) (model.TodoModel, error) {
+	current, err := store.GetSingle(ctx, id)
+	if err != nil {
+		return model.TodoModel{}, storeError(err)
+	}
+	if err := tc.checkAuthor(ctx, current); err != nil {
+		return model.TodoModel{}, err
+	}
+	if data.Version != 0 && data.Version != current.Version {
+		return model.TodoModel{}, database.ErrVersionConflict
 	}
 
-	if _, err := tc.dto.GetSingle(id); err != nil {
-		log.Error(time.Now().Format("2006-01-02 15:04:05"), " EditTodo controller ", err)
+	// merged keeps the version just read, so the store rejects the write if someone else got there first
+	merged, fields, err := applyMask(current, data, fields)
+	if err != nil {
+		return model.TodoModel{}, err
+	}
+	if err := tc.verifyFields(&merged, fields); err != nil {
+		return model.TodoModel{}, err
+	}
+	// handing a todo over to another author is left to the roles that may change anyone's todos
+	if merged.Author != current.Author {
+		if err := tc.checkAuthorName(ctx, merged.Author); err != nil {
+			return model.TodoModel{}, err
+		}
+	}
+
+	merged.UpdatedAt = time.Now()
+	merged.Id = id
 
-		return model.TodoModel{}, 404, err
+	result, err := store.Update(ctx, id, merged)
+	if err != nil {
+		return model.TodoModel{}, storeError(err)
 	}
+	return result, nil
+}
 
-	data.UpdatedAt = time.Now()
-	data.Id = id
+// remove trashes a todo through store, current is the record as it was before the delete.
+// A non zero version makes the delete conditional on the todo still being at that version.
+func (tc TodoController) remove(ctx context.Context, store _interface.DtoInterface[model.TodoModel], id string, version uint64) (current model.TodoModel, result model.TodoModel, err error) {
+	current, err = store.GetSingle(ctx, id)
+	if err != nil {
+		return model.TodoModel{}, model.TodoModel{}, storeError(err)
+	}
+	if err := tc.checkAuthor(ctx, current); err != nil {
+		return model.TodoModel{}, model.TodoModel{}, err
+	}
+	if version != 0 && version != current.Version {
+		return model.TodoModel{}, model.TodoModel{}, database.ErrVersionConflict
+	}
 
-	result, err := tc.dto.Update(id, data)
+	result, err = store.Delete(ctx, id, current.Version)
 	if err != nil {
-		log.Error(time.Now(), " EditTodo controller ", err)
+		return model.TodoModel{}, model.TodoModel{}, storeError(err)
+	}
+	return current, result, nil
+}
 
-		return model.TodoModel{}, 500, err
+// EditTodo updates the given fields of a todo, every editable field when none are given.
+// A non zero data.Version is the version the caller expects to overwrite.
+func (tc TodoController) EditTodo(ctx context.Context, id string, data model.TodoModel, fields ...string) (model.TodoModel, error) {
+	result, err := tc.update(ctx, tc.dto, id, data, fields)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " EditTodo controller ", err)
+
+		return model.TodoModel{}, err
 	}
 
 	//Revoke data from redis too
-	_ = tc.dto.Db.RedisRemove(id)
-	_ = tc.dto.Db.RedisRemove("list-")
+	tc.invalidateTodos(ctx, id)
+	tc.invalidateLists(ctx)
+	tc.publish(database.EventUpdated, result)
 
-	return result, 200, nil
+	return result, nil
 }
 
-func (tc TodoController) DeleteTodo(id string) (model.TodoModel, int, error) {
-	if _, err := tc.dto.GetSingle(id); err != nil {
-		log.Error(time.Now(), " DeleteTodo controller ", err)
+// DeleteTodo moves a todo to the trash and returns it, expectedVersion 0 deletes whatever version is stored.
+func (tc TodoController) DeleteTodo(ctx context.Context, id string, expectedVersion uint64) (model.TodoModel, error) {
+	current, result, err := tc.remove(ctx, tc.dto, id, expectedVersion)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " DeleteTodo controller ", err)
 
-		return model.TodoModel{}, 404, err
+		return model.TodoModel{}, err
 	}
 
-	result, err := tc.dto.Delete(id)
-	if err != nil {
-		log.Error(time.Now(), " DeleteTodo controller ", err)
+	//Revoke data from redis too
+	tc.invalidateTodos(ctx, id)
+	tc.invalidateLists(ctx)
+	tc.publish(database.EventDeleted, current)
+
+	return result, nil
+}
 
-		return model.TodoModel{}, 500, err
+// publish notifies watchers, a failed publish never fails the write itself.
+func (tc TodoController) publish(eventType string, data model.TodoModel) {
+	if err := tc.db.Broker.Publish(eventType, data); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " publish controller ", err)
 	}
+}
 
-	//Revoke data from redis too
-	_ = tc.dto.Db.RedisRemove(id)
-	_ = tc.dto.Db.RedisRemove("list-")
+// WatchTodos subscribes to the todo changes of the workspace bound to ctx after the given event sequence,
+// 0 only watches new changes.
+func (tc TodoController) WatchTodos(ctx context.Context, after uint64) (*database.Subscription, error) {
+	sub, err := tc.db.Broker.Subscribe(after)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " WatchTodos controller ", err)
+
+		return nil, storeError(err)
+	}
 
-	return result, 200, nil
+	tenantId := tenant.From(ctx)
+	return sub.Only(func(e database.TodoEvent) bool {
+		return e.Todo.TenantId == tenantId
+	}), nil
 }
 
 func CreateTodoController(db *database.Database) (TodoController, error) {
 	var res TodoController
-	res.dto = dto.TodoDTO{}
-	res.dto.SetDb(db)
+	res.dto = dto.NewTodoDTO(db)
+	res.keys = dto.NewApiKeyDTO(db)
+	res.audit = dto.NewAuditDTO(db)
+	res.revisions = dto.NewRevisionDTO(db)
+	res.webhooks = dto.NewWebhookDTO(db)
+	res.hookClient = newWebhookClient(false)
+	res.hookAttempts = defaultWebhookAttempts
+	res.db = db
+	res.flight = &singleflight.Group{}
+	res.policy = config.DefaultPolicy()
 	return res, nil
 }
+
+// SetPolicy replaces the default access policy, see config.LoadPolicy.
+func (tc *TodoController) SetPolicy(policy config.Policy) {
+	tc.policy = policy
+}
+
+// SetDailyQuota limits how many todos each caller may create per UTC day, 0 turns the quota off.
+func (tc *TodoController) SetDailyQuota(quota int) {
+	tc.quota = quota
+}
+
+// quotaKey is the counter of the caller's quota for the current UTC day and when it resets, ok is
+// false for calls that are not counted: those without claims, such as internal jobs, or without a quota.
+func (tc TodoController) quotaKey(ctx context.Context) (key string, reset time.Time, ok bool) {
+	claims, ok := auth.ClaimsFrom(ctx)
+	if tc.quota <= 0 || !ok || tc.db == nil || tc.db.Limits == nil {
+		return "", time.Time{}, false
+	}
+	now := time.Now().UTC()
+	reset = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return "quota-" + now.Format("2006-01-02") + "-" + claims.Caller(), reset, true
+}
+
+// refundQuota gives n todos that were counted but never stored back to the caller's daily quota.
+func (tc TodoController) refundQuota(ctx context.Context, n int) {
+	key, reset, ok := tc.quotaKey(ctx)
+	if !ok || n <= 0 {
+		return
+	}
+	if _, err := tc.db.Limits.Consume(key, -n, tc.quota, reset); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " todo quota ", err)
+	}
+}
+
+// spendQuota counts one created todo against the caller's daily quota. Calls without claims,
+// such as internal jobs, are not counted, and a failing store lets the todo through.
+func (tc TodoController) spendQuota(ctx context.Context) error {
+	key, reset, ok := tc.quotaKey(ctx)
+	if !ok {
+		return nil
+	}
+	spent, err := tc.db.Limits.Consume(key, 1, tc.quota, reset)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " todo quota ", err)
+		return nil
+	}
+	if !spent {
+		return apperror.ResourceExhausted("daily quota of %d todos used up, it resets at %s", tc.quota, reset.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -1,17 +1,37 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/auth"
 	"todo_pikpo/config"
 	"todo_pikpo/database"
 	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
 
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
+// ctx is bound to no workspace, everything the tests store lands in tenant.Default.
+var ctx = context.Background()
+
 type ControllerTest struct {
 	suite.Suite
+	conf       config.ConfigApp
 	controller TodoController
 	db         *database.Database
 }
@@ -25,13 +45,7 @@ func (s *ControllerTest) TearDownTest() {
 }
 
 func (s *ControllerTest) SetupSuite() {
-	c, err := config.NewAppConfig("../.env.test")
-	if err != nil {
-		s.T().Error("Failed to create config:", err)
-		return
-	}
-
-	db, err := database.NewDatabase(c)
+	db, err := database.NewDatabase(s.conf)
 	if err != nil {
 		s.T().Error("Failed to create database:", err)
 		return
@@ -51,13 +65,28 @@ func (s *ControllerTest) TearDownSuite() {
 }
 
 func TestSuite(t *testing.T) {
-	suite.Run(t, new(ControllerTest))
+	c, err := config.NewAppConfig("../.env.test")
+	if err != nil {
+		t.Error("Failed to create config:", err)
+		return
+	}
+
+	drivers := []string{database.DriverMemory, database.DriverSqlite}
+	if c.DbHost != "" {
+		drivers = append(drivers, database.DriverPostgres)
+	}
+	for _, driver := range drivers {
+		c.DbDriver = driver
+		t.Run(driver, func(t *testing.T) {
+			suite.Run(t, &ControllerTest{conf: c})
+		})
+	}
 }
 
 func (s *ControllerTest) TestAdd() {
 	a := s.Suite.Assert()
 
-	_, code, err := s.controller.AddTodo(model.TodoModel{
+	_, err := s.controller.AddTodo(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test",
@@ -68,10 +97,10 @@ func (s *ControllerTest) TestAdd() {
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	})
-	a.Equal(code, 400)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
 	a.NotEqual(err, nil)
 
-	res, code, err := s.controller.AddTodo(model.TodoModel{
+	res, err := s.controller.AddTodo(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "james",
 		Title:       "test this is title",
@@ -82,7 +111,6 @@ func (s *ControllerTest) TestAdd() {
 		CreatedAt:   time.Now().Add(-300 * time.Hour),
 		UpdatedAt:   time.Now().Add(-400 * time.Hour),
 	})
-	a.Equal(code, 200)
 	a.Equal(err, nil)
 	a.NotEqual(res.Id, "1")
 	a.Equal(res.Author, "james")
@@ -90,10 +118,151 @@ func (s *ControllerTest) TestAdd() {
 	a.Equal(res.CreatedAt.Unix(), res.UpdatedAt.Unix())
 }
 
+func (s *ControllerTest) TestAddAuthor() {
+	a := s.Suite.Assert()
+	caller := auth.WithClaims(ctx, auth.Claims{Subject: "james"})
+	todo := model.TodoModel{
+		Title:     "test this is title",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(72 * time.Hour),
+	}
+
+	res, err := s.controller.AddTodo(caller, todo)
+	a.Equal(err, nil)
+	a.Equal(res.Author, "james")
+
+	// only roles that may change anyone's todos pick another author
+	todo.Author = "robert"
+	_, err = s.controller.AddTodo(caller, todo)
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+	res, err = s.controller.AddTodo(auth.WithClaims(ctx, auth.Claims{Subject: "james", Roles: []string{"admin"}}), todo)
+	a.Equal(err, nil)
+	a.Equal(res.Author, "robert")
+
+	// without an identity there is nothing to default to
+	todo.Author = ""
+	_, err = s.controller.AddTodo(ctx, todo)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "author")
+}
+
+func (s *ControllerTest) TestAuthorOnly() {
+	a := s.Suite.Assert()
+	james := auth.WithClaims(ctx, auth.Claims{Subject: "james", Roles: []string{"editor"}})
+	robert := auth.WithClaims(ctx, auth.Claims{Subject: "robert", Roles: []string{"editor"}})
+	admin := auth.WithClaims(ctx, auth.Claims{Roles: []string{"admin"}})
+
+	res, err := s.controller.AddTodo(james, model.TodoModel{
+		Title:     "test this is title",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(72 * time.Hour),
+	})
+	a.Equal(err, nil)
+
+	_, err = s.controller.EditTodo(robert, res.Id, model.TodoModel{Title: "not robert's todo"}, FieldTitle)
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+	_, err = s.controller.DeleteTodo(robert, res.Id, 0)
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+	results, err := s.controller.BatchDeleteTodo(robert, []BatchDelete{{Id: res.Id}}, false)
+	a.Equal(err, nil)
+	a.Equal(apperror.KindOf(results[0].Err), apperror.KindPermissionDenied)
+
+	_, err = s.controller.EditTodo(james, res.Id, model.TodoModel{Title: "edited by its author"}, FieldTitle)
+	a.Equal(err, nil)
+
+	// nobody but the anyAuthor roles writes todos under another name
+	_, err = s.controller.AddTodo(robert, model.TodoModel{
+		Author:    "james",
+		Title:     "posing as james",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(72 * time.Hour),
+	})
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+	_, err = s.controller.EditTodo(james, res.Id, model.TodoModel{Author: "robert"}, FieldAuthor)
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+	_, err = s.controller.EditTodo(james, res.Id, model.TodoModel{Author: "james"}, FieldAuthor)
+	a.Equal(err, nil)
+	handed, err := s.controller.EditTodo(admin, res.Id, model.TodoModel{Author: "robert"}, FieldAuthor)
+	a.Equal(err, nil)
+	a.Equal(handed.Author, "robert")
+	_, err = s.controller.EditTodo(admin, res.Id, model.TodoModel{Author: "james"}, FieldAuthor)
+	a.Equal(err, nil)
+	_, err = s.controller.EditTodo(admin, res.Id, model.TodoModel{Title: "edited by an admin"}, FieldTitle)
+	a.Equal(err, nil)
+	_, err = s.controller.DeleteTodo(james, res.Id, 0)
+	a.Equal(err, nil)
+
+	// the trash is author-only too
+	trash, err := s.controller.ListDeletedTodos(robert, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 0)
+	trash, err = s.controller.ListDeletedTodos(james, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 1)
+	trash, err = s.controller.ListDeletedTodos(admin, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 1)
+	_, err = s.controller.RestoreTodo(robert, res.Id)
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+	_, err = s.controller.RestoreTodo(james, res.Id)
+	a.Equal(err, nil)
+}
+
+func (s *ControllerTest) TestApiKeys() {
+	a := s.Suite.Assert()
+	acme := tenant.With(ctx, "acme")
+
+	_, _, err := s.controller.CreateApiKey(acme, "ci", []string{"root"}, time.Time{})
+	a.Equal(apperror.FieldsOf(err)[0].Field, "name")
+	_, _, err = s.controller.CreateApiKey(acme, "deploy", []string{"root"}, time.Time{})
+	a.Equal(apperror.FieldsOf(err)[0].Field, "scopes")
+	_, _, err = s.controller.CreateApiKey(acme, "deploy", []string{"read"}, time.Now().Add(-time.Hour))
+	a.Equal(apperror.FieldsOf(err)[0].Field, "expiresAt")
+
+	old, oldKey, err := s.controller.CreateApiKey(acme, "deploy", []string{"write", "Read", "write"}, time.Time{})
+	a.Equal(err, nil)
+	a.Equal(old.ScopeList(), []string{"write", "read"})
+	a.Equal(old.TenantId, "acme")
+	a.Equal(strings.Contains(old.Hash, strings.TrimPrefix(oldKey, "pk_"+old.Id+"_")), false)
+
+	verified, err := s.controller.VerifyApiKey(ctx, oldKey)
+	a.Equal(err, nil)
+	a.Equal(verified.Id, old.Id)
+	a.NotEqual(verified.LastUsedAt, nil)
+	_, err = s.controller.VerifyApiKey(ctx, oldKey[:len(oldKey)-1]+"x")
+	a.NotEqual(err, nil)
+	_, err = s.controller.VerifyApiKey(ctx, "pk_"+uuid.New().String()+"_secret")
+	a.NotEqual(err, nil)
+	_, err = s.controller.VerifyApiKey(ctx, "pk_nosecret")
+	a.NotEqual(err, nil)
+
+	// rotation: the old key keeps working through its grace period next to the new one
+	_, newKey, err := s.controller.CreateApiKey(acme, "deploy v2", []string{"write"}, time.Time{})
+	a.Equal(err, nil)
+	_, err = s.controller.RevokeApiKey(acme, old.Id, time.Hour)
+	a.Equal(err, nil)
+	_, err = s.controller.VerifyApiKey(ctx, oldKey)
+	a.Equal(err, nil)
+	_, err = s.controller.RevokeApiKey(acme, old.Id, 0)
+	a.Equal(err, nil)
+	_, err = s.controller.VerifyApiKey(ctx, oldKey)
+	a.NotEqual(err, nil)
+	_, err = s.controller.VerifyApiKey(ctx, newKey)
+	a.Equal(err, nil)
+
+	_, err = s.controller.RevokeApiKey(ctx, old.Id, 0)
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+	keys, err := s.controller.ListApiKeys(acme)
+	a.Equal(err, nil)
+	a.Equal(len(keys), 2)
+	keys, err = s.controller.ListApiKeys(ctx)
+	a.Equal(err, nil)
+	a.Equal(len(keys), 0)
+}
+
 func (s *ControllerTest) TestDelete() {
 	a := s.Suite.Assert()
 
-	res, code, err := s.controller.AddTodo(model.TodoModel{
+	res, err := s.controller.AddTodo(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "james",
 		Title:       "test this is title",
@@ -104,15 +273,14 @@ func (s *ControllerTest) TestDelete() {
 		CreatedAt:   time.Now().Add(-300 * time.Hour),
 		UpdatedAt:   time.Now().Add(-400 * time.Hour),
 	})
-	a.Equal(code, 200)
 	a.Equal(err, nil)
 	a.NotEqual(res.Id, "1")
 
-	_, code, err = s.controller.DeleteTodo(res.Id)
-	a.Equal(code, 200)
+	_, err = s.controller.DeleteTodo(ctx, res.Id, 0)
+	a.Equal(err, nil)
 
-	_, code, err = s.controller.GetTodo(res.Id)
-	a.Equal(code, 404)
+	_, err = s.controller.GetTodo(ctx, res.Id)
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
 }
 
 func (s *ControllerTest) TestUpdate() {
@@ -128,12 +296,11 @@ func (s *ControllerTest) TestUpdate() {
 		EndDate:     time.Now().Add(72 * time.Hour),
 	}
 
-	res, code, err := s.controller.AddTodo(tempData)
-	a.Equal(code, 200)
+	res, err := s.controller.AddTodo(ctx, tempData)
 	a.Equal(err, nil)
 	a.NotEqual(res.Id, "1")
 
-	_, code, err = s.controller.EditTodo(res.Id, model.TodoModel{
+	_, err = s.controller.EditTodo(ctx, res.Id, model.TodoModel{
 		Author:      "james",
 		Title:       "test this is title",
 		Description: "lorem ipsom dolom amet",
@@ -141,12 +308,12 @@ func (s *ControllerTest) TestUpdate() {
 		StartDate:   time.Now(),
 		EndDate:     time.Now().Add(-1 * time.Hour),
 	})
-	a.Equal(code, 400)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
 	a.NotEqual(err, nil)
 
 	time.Sleep(2 * time.Second)
 
-	res2, code, err := s.controller.EditTodo(res.Id, model.TodoModel{
+	res2, err := s.controller.EditTodo(ctx, res.Id, model.TodoModel{
 		Author:      "james",
 		Title:       "test this is title",
 		Description: "lorem ipsom dolom amet",
@@ -154,7 +321,6 @@ func (s *ControllerTest) TestUpdate() {
 		StartDate:   time.Now(),
 		EndDate:     time.Now().Add(10 * time.Hour),
 	})
-	a.Equal(code, 200)
 	a.Equal(err, nil)
 	a.Greater(res2.UpdatedAt.Unix(), res2.CreatedAt.Unix())
 	a.Greater(res2.UpdatedAt.Unix(), res.UpdatedAt.Unix())
@@ -165,7 +331,7 @@ func (s *ControllerTest) TestUpdate() {
 func (s *ControllerTest) TestList() {
 	a := s.Suite.Assert()
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -177,7 +343,7 @@ func (s *ControllerTest) TestList() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -189,7 +355,7 @@ func (s *ControllerTest) TestList() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "3",
 		Author:      "James",
 		Title:       "test",
@@ -201,8 +367,7 @@ func (s *ControllerTest) TestList() {
 		UpdatedAt:   time.Now(),
 	})
 
-	data, code, err := s.controller.GetTodos(map[string]interface{}{}, 0, 10)
-	a.Equal(code, 200)
+	data, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 3)
 }
@@ -210,7 +375,7 @@ func (s *ControllerTest) TestList() {
 func (s *ControllerTest) TestListPagination() {
 	a := s.Suite.Assert()
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -222,7 +387,7 @@ func (s *ControllerTest) TestListPagination() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -234,7 +399,7 @@ func (s *ControllerTest) TestListPagination() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "3",
 		Author:      "James",
 		Title:       "test",
@@ -246,20 +411,17 @@ func (s *ControllerTest) TestListPagination() {
 		UpdatedAt:   time.Now(),
 	})
 
-	data, code, err := s.controller.GetTodos(map[string]interface{}{}, 1, 1)
-	a.Equal(code, 200)
+	data, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 1, 1)
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 	a.Equal(data[0].Id, "2")
 
-	data, code, err = s.controller.GetTodos(map[string]interface{}{}, 2, 1)
-	a.Equal(code, 200)
+	data, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 2, 1)
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 	a.Equal(data[0].Id, "3")
 
-	data, code, err = s.controller.GetTodos(map[string]interface{}{}, 2, 10)
-	a.Equal(code, 200)
+	data, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 2, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 0)
 }
@@ -267,7 +429,7 @@ func (s *ControllerTest) TestListPagination() {
 func (s *ControllerTest) TestListQuery() {
 	a := s.Suite.Assert()
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -279,7 +441,7 @@ func (s *ControllerTest) TestListQuery() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -291,7 +453,7 @@ func (s *ControllerTest) TestListQuery() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "3",
 		Author:      "James",
 		Title:       "test",
@@ -303,29 +465,70 @@ func (s *ControllerTest) TestListQuery() {
 		UpdatedAt:   time.Now(),
 	})
 
-	data, code, err := s.controller.GetTodos(map[string]interface{}{
-		"author": "James",
+	data, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{
+		Authors: []string{"James"},
 	}, 0, 10)
-	a.Equal(code, 200)
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 
-	data, code, err = s.controller.GetTodos(map[string]interface{}{
-		"title": "James",
+	data, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{
+		Title: "James",
 	}, 0, 10)
-	a.Equal(code, 200)
 	a.Equal(err, nil)
 	a.Equal(len(data), 0)
 }
 
+func (s *ControllerTest) TestListRange() {
+	a := s.Suite.Assert()
+	now := time.Now()
+
+	_, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{StartFrom: now, StartTo: now.Add(-time.Hour)}, 0, 10)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "startTo")
+
+	_, err = s.controller.GetTodosPage(ctx, _interface.TodoQueryFilter{EndFrom: now, EndTo: now.Add(-time.Hour)}, "", 10)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "endTo")
+
+	_, err = s.controller.GetTodosPage(ctx, _interface.TodoQueryFilter{EndFrom: now, EndTo: now}, "", 10)
+	a.Equal(err, nil)
+}
+
+func (s *ControllerTest) TestSearch() {
+	a := s.Suite.Assert()
+	for _, title := range []string{"budget review", "budget plan", "budget numbers"} {
+		_, err := s.controller.AddTodo(ctx, model.TodoModel{
+			Author:    "james",
+			Title:     title,
+			StartDate: time.Now(),
+			EndDate:   time.Now().Add(72 * time.Hour),
+		})
+		a.Equal(err, nil)
+	}
+
+	page, err := s.controller.SearchTodos(ctx, "budget", "", 2)
+	a.Equal(err, nil)
+	a.Equal(page.TotalSize, int64(3))
+	a.Equal(len(page.Hits), 2)
+	a.NotEqual(page.NextPageToken, "")
+
+	next, err := s.controller.SearchTodos(ctx, "budget", page.NextPageToken, 2)
+	a.Equal(err, nil)
+	a.Equal(len(next.Hits), 1)
+	a.Equal(next.NextPageToken, "")
+
+	_, err = s.controller.SearchTodos(ctx, "review", page.NextPageToken, 2)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "pageToken")
+	_, err = s.controller.SearchTodos(ctx, "  ", "", 2)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "query")
+}
+
 func (s *ControllerTest) TestGet() {
 	a := s.Suite.Assert()
 
-	_, code, err := s.controller.GetTodo("test")
-	a.Equal(code, 404)
+	_, err := s.controller.GetTodo(ctx, "test")
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
 	a.NotEqual(err, nil)
 
-	s.controller.dto.Create(model.TodoModel{
+	s.controller.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test",
@@ -333,8 +536,777 @@ func (s *ControllerTest) TestGet() {
 		IsDone:      false,
 	})
 
-	data, code, err := s.controller.GetTodo("1")
-	a.Equal(code, 200)
+	data, err := s.controller.GetTodo(ctx, "1")
 	a.Equal(err, nil)
 	a.Equal(data.Id, "1")
 }
+
+func (s *ControllerTest) TestUpdateMask() {
+	a := s.Suite.Assert()
+
+	// already overdue, a full edit would fail on EndDate
+	overdue, err := s.controller.dto.Create(ctx, model.TodoModel{
+		Id:        "overdue",
+		Author:    "james",
+		Title:     "test this is title",
+		StartDate: time.Now().Add(-72 * time.Hour),
+		EndDate:   time.Now().Add(-24 * time.Hour),
+	})
+	a.Equal(err, nil)
+
+	_, err = s.controller.EditTodo(ctx, overdue.Id, model.TodoModel{IsDone: true})
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+
+	res, err := s.controller.EditTodo(ctx, overdue.Id, model.TodoModel{IsDone: true}, FieldIsDone)
+	a.Equal(err, nil)
+	a.Equal(res.IsDone, true)
+	a.Equal(res.Title, "test this is title")
+	a.Equal(res.EndDate.Unix(), overdue.EndDate.Unix())
+
+	_, err = s.controller.EditTodo(ctx, overdue.Id, model.TodoModel{Title: "abc"}, FieldTitle)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "title")
+
+	_, err = s.controller.EditTodo(ctx, overdue.Id, model.TodoModel{EndDate: time.Now().Add(time.Hour)}, FieldEndDate)
+	a.Equal(err, nil)
+
+	_, err = s.controller.EditTodo(ctx, overdue.Id, model.TodoModel{Id: "other"}, "id")
+	a.Equal(apperror.FieldsOf(err)[0].Field, "updateMask")
+}
+
+func (s *ControllerTest) TestVersion() {
+	a := s.Suite.Assert()
+
+	res, err := s.controller.AddTodo(ctx, model.TodoModel{
+		Author:    "james",
+		Title:     "test this is title",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(72 * time.Hour),
+	})
+	a.Equal(err, nil)
+	a.Equal(res.Version, uint64(1))
+
+	// warm the cache so a stale cached copy can't hide the new version
+	_, _ = s.controller.GetTodo(ctx, res.Id)
+
+	first, err := s.controller.EditTodo(ctx, res.Id, model.TodoModel{IsDone: true, Version: 1}, FieldIsDone)
+	a.Equal(err, nil)
+	a.Equal(first.Version, uint64(2))
+
+	_, err = s.controller.EditTodo(ctx, res.Id, model.TodoModel{Title: "lost update", Version: 1}, FieldTitle)
+	a.Equal(apperror.KindOf(err), apperror.KindConflict)
+
+	got, err := s.controller.GetTodo(ctx, res.Id)
+	a.Equal(err, nil)
+	a.Equal(got.Version, uint64(2))
+	a.Equal(got.Title, "test this is title")
+
+	_, err = s.controller.DeleteTodo(ctx, res.Id, 1)
+	a.Equal(apperror.KindOf(err), apperror.KindConflict)
+	_, err = s.controller.DeleteTodo(ctx, res.Id, 2)
+	a.Equal(err, nil)
+}
+
+func (s *ControllerTest) TestTrash() {
+	a := s.Suite.Assert()
+
+	res, err := s.controller.AddTodo(ctx, model.TodoModel{
+		Author:    "james",
+		Title:     "test this is title",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(72 * time.Hour),
+	})
+	a.Equal(err, nil)
+	_, _ = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+
+	deleted, err := s.controller.DeleteTodo(ctx, res.Id, 0)
+	a.Equal(err, nil)
+	a.Equal(deleted.Title, "test this is title")
+	a.Equal(deleted.DeletedAt.Valid, true)
+
+	list, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+	trash, err := s.controller.ListDeletedTodos(ctx, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 1)
+
+	restored, err := s.controller.RestoreTodo(ctx, res.Id)
+	a.Equal(err, nil)
+	a.Equal(restored.DeletedAt.Valid, false)
+	list, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 1)
+
+	_, err = s.controller.RestoreTodo(ctx, res.Id)
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+
+	_, err = s.controller.DeleteTodo(ctx, res.Id, 0)
+	a.Equal(err, nil)
+	purged, err := s.controller.PurgeDeleted(ctx, time.Hour)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(0))
+	purged, err = s.controller.PurgeDeleted(ctx, -time.Second)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(1))
+}
+
+func (s *ControllerTest) TestListKeyset() {
+	a := s.Suite.Assert()
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		res, err := s.controller.AddTodo(ctx, model.TodoModel{
+			Author:    "james",
+			Title:     fmt.Sprintf("keyset title %d", i),
+			StartDate: time.Now(),
+			EndDate:   time.Now().Add(72 * time.Hour),
+		})
+		a.Equal(err, nil)
+		ids = append(ids, res.Id)
+	}
+
+	filter := _interface.TodoQueryFilter{Authors: []string{"james"}}
+	page, err := s.controller.GetTodosPage(ctx, filter, "", 2)
+	a.Equal(err, nil)
+	a.Equal(page.TotalSize, int64(5))
+	a.Equal(len(page.Todos), 2)
+	a.Equal(page.Todos[0].Id, ids[0])
+	a.NotEqual(page.NextPageToken, "")
+
+	// deleting something already seen does not shift the next page the way offsets would
+	_, err = s.controller.DeleteTodo(ctx, ids[0], 0)
+	a.Equal(err, nil)
+
+	page, err = s.controller.GetTodosPage(ctx, filter, page.NextPageToken, 2)
+	a.Equal(err, nil)
+	a.Equal(page.TotalSize, int64(4))
+	a.Equal(page.Todos[0].Id, ids[2])
+	a.Equal(page.Todos[1].Id, ids[3])
+
+	page, err = s.controller.GetTodosPage(ctx, filter, page.NextPageToken, 2)
+	a.Equal(err, nil)
+	a.Equal(len(page.Todos), 1)
+	a.Equal(page.Todos[0].Id, ids[4])
+	a.Equal(page.NextPageToken, "")
+
+	first, err := s.controller.GetTodosPage(ctx, filter, "", 2)
+	a.Equal(err, nil)
+	_, err = s.controller.GetTodosPage(ctx, _interface.TodoQueryFilter{Authors: []string{"robert"}}, first.NextPageToken, 2)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "pageToken")
+	_, err = s.controller.GetTodosPage(ctx, filter, "not a token", 2)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+}
+
+func (s *ControllerTest) TestListOrder() {
+	a := s.Suite.Assert()
+
+	// created in one order, due in the reverse one
+	var ids []string
+	for i := 0; i < 5; i++ {
+		res, err := s.controller.AddTodo(ctx, model.TodoModel{
+			Author:    "james",
+			Title:     fmt.Sprintf("order title %d", i),
+			StartDate: time.Now(),
+			EndDate:   time.Now().Add(time.Duration(72-i) * time.Hour),
+		})
+		a.Equal(err, nil)
+		ids = append(ids, res.Id)
+	}
+
+	filter := _interface.TodoQueryFilter{OrderBy: []_interface.SortKey{{Column: "end_date"}}}
+	var seen []string
+	token := ""
+	for {
+		page, err := s.controller.GetTodosPage(ctx, filter, token, 2)
+		a.Equal(err, nil)
+		for _, d := range page.Todos {
+			seen = append(seen, d.Id)
+		}
+		if token = page.NextPageToken; token == "" {
+			break
+		}
+	}
+	a.Equal(seen, []string{ids[4], ids[3], ids[2], ids[1], ids[0]})
+
+	// a token is tied to its order, the default order can't continue it
+	page, err := s.controller.GetTodosPage(ctx, filter, "", 2)
+	a.Equal(err, nil)
+	_, err = s.controller.GetTodosPage(ctx, _interface.TodoQueryFilter{}, page.NextPageToken, 2)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "pageToken")
+
+	res, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{OrderBy: []_interface.SortKey{{Column: "title", Desc: true}}}, 0, 1)
+	a.Equal(err, nil)
+	a.Equal(res[0].Id, ids[4])
+
+	_, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{OrderBy: []_interface.SortKey{{Column: "description"}}}, 0, 1)
+	a.Equal(apperror.FieldsOf(err)[0].Field, "orderBy")
+}
+
+func (s *ControllerTest) TestBatch() {
+	a := s.Suite.Assert()
+	valid := func(author string) model.TodoModel {
+		return model.TodoModel{
+			Author:    author,
+			Title:     "test this is title",
+			StartDate: time.Now(),
+			EndDate:   time.Now().Add(72 * time.Hour),
+		}
+	}
+
+	// atomic batch with one bad item stores nothing
+	res, err := s.controller.BatchAddTodo(ctx, []model.TodoModel{valid("james"), valid("-"), valid("robert")}, true)
+	a.Equal(err, nil)
+	a.Equal(len(res), 3)
+	a.Equal(apperror.KindOf(res[0].Err), apperror.KindConflict)
+	a.Equal(apperror.KindOf(res[1].Err), apperror.KindValidation)
+	a.Equal(apperror.KindOf(res[2].Err), apperror.KindConflict)
+	list, err := s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+
+	// best effort keeps the good items
+	res, err = s.controller.BatchAddTodo(ctx, []model.TodoModel{valid("james"), valid("-"), valid("robert")}, false)
+	a.Equal(err, nil)
+	a.Equal(res[0].Err, nil)
+	a.Equal(apperror.KindOf(res[1].Err), apperror.KindValidation)
+	a.Equal(res[2].Err, nil)
+	list, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 2)
+
+	changed := valid("james")
+	changed.Title = "changed in batch"
+	changed.IsDone = true
+	res, err = s.controller.BatchEditTodo(ctx, []BatchEdit{{Id: list[0].Id, Data: changed}, {Id: "not-exist", Data: changed}}, false)
+	a.Equal(err, nil)
+	a.Equal(res[0].Err, nil)
+	a.Equal(res[0].Todo.Title, "changed in batch")
+	a.Equal(apperror.KindOf(res[1].Err), apperror.KindNotFound)
+	got, err := s.controller.GetTodo(ctx, list[0].Id)
+	a.Equal(err, nil)
+	a.Equal(got.IsDone, true)
+
+	res, err = s.controller.BatchDeleteTodo(ctx, []BatchDelete{{Id: list[0].Id}, {Id: "not-exist"}}, true)
+	a.Equal(err, nil)
+	a.Equal(apperror.KindOf(res[1].Err), apperror.KindNotFound)
+	_, err = s.controller.GetTodo(ctx, list[0].Id)
+	a.Equal(err, nil)
+
+	res, err = s.controller.BatchDeleteTodo(ctx, []BatchDelete{{Id: list[0].Id}, {Id: list[1].Id}}, true)
+	a.Equal(err, nil)
+	a.Equal(res[0].Err, nil)
+	a.Equal(res[0].Todo.Id, list[0].Id)
+	list, err = s.controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+
+	_, err = s.controller.BatchDeleteTodo(ctx, nil, false)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+}
+
+func TestControllerCache(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheNone})
+	a.Equal(err, nil)
+	db.Cache = database.NewLruCache(100, time.Minute)
+
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	res, err := controller.AddTodo(ctx, model.TodoModel{
+		Author:      "james",
+		Title:       "test this is title",
+		Description: "lorem ipsom dolom amet",
+		StartDate:   time.Now(),
+		EndDate:     time.Now().Add(72 * time.Hour),
+	})
+	a.Equal(err, nil)
+
+	_, err = controller.GetTodo(ctx, res.Id)
+	a.Equal(err, nil)
+	list, err := controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 1)
+
+	// writes that bypass the controller are hidden by the cache
+	_, _ = controller.dto.Update(ctx, res.Id, model.TodoModel{Author: "robert", Title: "changed behind the cache"})
+	data, _ := controller.GetTodo(ctx, res.Id)
+	a.Equal(data.Title, "test this is title")
+
+	// controller writes invalidate both the item and the lists
+	_, err = controller.EditTodo(ctx, res.Id, model.TodoModel{
+		Author:    "james",
+		Title:     "edited through controller",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(72 * time.Hour),
+	})
+	a.Equal(err, nil)
+	data, _ = controller.GetTodo(ctx, res.Id)
+	a.Equal(data.Title, "edited through controller")
+	list, _ = controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(list[0].Title, "edited through controller")
+
+	// a load that read the row before an edit and stores it afterwards is not served
+	key, ok := controller.todoCacheKey(ctx, res.Id)
+	a.True(ok)
+	stale, err := controller.dto.GetSingle(ctx, res.Id)
+	a.Equal(err, nil)
+	_, err = controller.EditTodo(ctx, res.Id, model.TodoModel{Title: "edited while loading"}, FieldTitle)
+	a.Equal(err, nil)
+	a.Equal(db.Cache.Set(key, stale), nil)
+	data, _ = controller.GetTodo(ctx, res.Id)
+	a.Equal(data.Title, "edited while loading")
+}
+
+func TestControllerCacheOverdue(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheLru})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	_, err = controller.dto.Create(ctx, model.TodoModel{Id: "1", Author: "james", Title: "due soon", EndDate: time.Now().Add(200 * time.Millisecond)})
+	a.Equal(err, nil)
+
+	// the todo turns overdue without a write, a cached list would keep hiding it
+	data, err := controller.GetTodos(ctx, _interface.TodoQueryFilter{Overdue: true}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 0)
+	time.Sleep(300 * time.Millisecond)
+	data, err = controller.GetTodos(ctx, _interface.TodoQueryFilter{Overdue: true}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 1)
+}
+
+func TestControllerTenant(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheLru})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	acme := tenant.With(ctx, "acme")
+	globex := tenant.With(ctx, "globex")
+	sub, err := controller.WatchTodos(globex, 0)
+	a.Equal(err, nil)
+	defer sub.Close()
+
+	created, err := controller.AddTodo(acme, model.TodoModel{
+		Author:    "james",
+		Title:     "acme only",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(time.Hour),
+	})
+	a.Equal(err, nil)
+
+	// both reads go through the cache once warmed by acme
+	for i := 0; i < 2; i++ {
+		_, err = controller.GetTodo(acme, created.Id)
+		a.Equal(err, nil)
+		_, err = controller.GetTodo(globex, created.Id)
+		a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+
+		data, err := controller.GetTodos(acme, _interface.TodoQueryFilter{}, 0, 10)
+		a.Equal(err, nil)
+		a.Equal(len(data), 1)
+		data, err = controller.GetTodos(globex, _interface.TodoQueryFilter{}, 0, 10)
+		a.Equal(err, nil)
+		a.Equal(len(data), 0)
+	}
+
+	_, err = controller.EditTodo(globex, created.Id, model.TodoModel{Title: "taken over"}, FieldTitle)
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+	results, err := controller.BatchDeleteTodo(globex, []BatchDelete{{Id: created.Id}}, false)
+	a.Equal(err, nil)
+	a.Equal(apperror.KindOf(results[0].Err), apperror.KindNotFound)
+
+	// globex did not see acme's event, only its own
+	mine, err := controller.AddTodo(globex, model.TodoModel{
+		Author:    "robert",
+		Title:     "globex only",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(time.Hour),
+	})
+	a.Equal(err, nil)
+	select {
+	case e := <-sub.Events:
+		a.Equal(e.Todo.Id, mine.Id)
+	case <-time.After(time.Second):
+		t.Fatal("globex event was not delivered")
+	}
+}
+
+func TestControllerCachePagination(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheLru})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, _ = controller.dto.Create(ctx, model.TodoModel{Id: id, Author: "james", Title: "test"})
+	}
+
+	// every page is cached under its own key
+	for i := 0; i < 2; i++ {
+		data, err := controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 1)
+		a.Equal(err, nil)
+		a.Equal(data[0].Id, "1")
+
+		data, err = controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 2, 1)
+		a.Equal(err, nil)
+		a.Equal(data[0].Id, "3")
+
+		data, err = controller.GetTodos(ctx, _interface.TodoQueryFilter{}, 0, 10)
+		a.Equal(err, nil)
+		a.Equal(len(data), 3)
+	}
+}
+
+type countingDTO struct {
+	_interface.DtoInterface[model.TodoModel]
+	calls int32
+}
+
+func (c *countingDTO) GetSingle(ctx context.Context, id string) (model.TodoModel, error) {
+	atomic.AddInt32(&c.calls, 1)
+	time.Sleep(50 * time.Millisecond)
+	return c.DtoInterface.GetSingle(ctx, id)
+}
+
+func TestControllerSingleFlight(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheLru})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	counter := &countingDTO{DtoInterface: controller.dto}
+	controller.dto = counter
+	_, _ = counter.Create(ctx, model.TodoModel{Id: "1", Author: "james", Title: "test"})
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			data, err := controller.GetTodo(ctx, "1")
+			a.Equal(err, nil)
+			a.Equal(data.Id, "1")
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	a.Equal(atomic.LoadInt32(&counter.calls), int32(1))
+}
+
+// purgeCountingDTO counts the purge transactions.
+type purgeCountingDTO struct {
+	_interface.DtoInterface[model.TodoModel]
+	calls int
+}
+
+func (c *purgeCountingDTO) Purge(ctx context.Context, before time.Time, limit uint) (int64, error) {
+	c.calls++
+	return c.DtoInterface.Purge(ctx, before, limit)
+}
+
+func TestControllerPurgeBatches(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheNone})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	counter := &purgeCountingDTO{DtoInterface: controller.dto}
+	controller.dto = counter
+	for i := 0; i <= purgeBatch; i++ {
+		id := fmt.Sprint(i)
+		_, err = counter.Create(ctx, model.TodoModel{Id: id, Author: "james", Title: "test"})
+		a.Equal(err, nil)
+		_, err = counter.Delete(ctx, id, 0)
+		a.Equal(err, nil)
+	}
+
+	purged, err := controller.PurgeDeleted(ctx, -time.Second)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(purgeBatch+1))
+	a.Equal(counter.calls, 2)
+}
+
+func TestControllerDailyQuota(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheNone})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+	controller.SetDailyQuota(2)
+
+	todo := model.TodoModel{
+		Title:     "quota",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(time.Hour),
+	}
+	james := auth.WithClaims(ctx, auth.Claims{Subject: "james", Roles: []string{"editor"}})
+	robert := auth.WithClaims(ctx, auth.Claims{Subject: "robert", Roles: []string{"editor"}})
+
+	_, err = controller.AddTodo(james, todo)
+	a.Equal(err, nil)
+	items, err := controller.BatchAddTodo(james, []model.TodoModel{todo, todo}, false)
+	a.Equal(err, nil)
+	a.Equal(items[0].Err, nil)
+	a.Equal(apperror.KindOf(items[1].Err), apperror.KindResourceExhausted)
+	_, err = controller.AddTodo(james, todo)
+	a.Equal(apperror.KindOf(err), apperror.KindResourceExhausted)
+
+	// every caller has a quota of their own, an atomic batch that rolls back leaves it unchanged
+	bad := todo
+	bad.Title = ""
+	items, err = controller.BatchAddTodo(robert, []model.TodoModel{todo, bad}, true)
+	a.Equal(err, nil)
+	a.Equal(apperror.KindOf(items[0].Err), apperror.KindConflict)
+	a.Equal(apperror.KindOf(items[1].Err), apperror.KindValidation)
+	_, err = controller.AddTodo(robert, todo)
+	a.Equal(err, nil)
+	_, err = controller.AddTodo(robert, todo)
+	a.Equal(err, nil)
+	_, err = controller.AddTodo(robert, todo)
+	a.Equal(apperror.KindOf(err), apperror.KindResourceExhausted)
+
+	// calls without claims have none
+	todo.Author = "internal job"
+	_, err = controller.AddTodo(ctx, todo)
+	a.Equal(err, nil)
+}
+
+func TestControllerAuditEvents(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheNone})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	james := auth.WithClaims(ctx, auth.Claims{Subject: "james", Roles: []string{"editor"}})
+	res, err := controller.AddTodo(james, model.TodoModel{
+		Title:     "audited",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(time.Hour),
+	})
+	a.Equal(err, nil)
+	_, err = controller.EditTodo(james, res.Id, model.TodoModel{IsDone: true}, FieldIsDone)
+	a.Equal(err, nil)
+	_, err = controller.DeleteTodo(james, res.Id, 0)
+	a.Equal(err, nil)
+
+	filter := _interface.AuditFilter{TodoId: res.Id, Actor: "james"}
+	page, err := controller.ListAuditEvents(ctx, filter, "", 2)
+	a.Equal(err, nil)
+	a.Equal(len(page.Events), 2)
+	a.Equal(page.Events[0].Action, database.EventDeleted)
+	a.Equal(page.Events[1].Changes, `[{"field":"isDone","before":false,"after":true}]`)
+	a.NotEqual(page.NextPageToken, "")
+
+	page, err = controller.ListAuditEvents(ctx, filter, page.NextPageToken, 2)
+	a.Equal(err, nil)
+	a.Equal(len(page.Events), 1)
+	a.Equal(page.Events[0].Action, database.EventCreated)
+	a.Equal(page.NextPageToken, "")
+
+	// tokens only continue the filter they were issued for
+	page, _ = controller.ListAuditEvents(ctx, filter, "", 1)
+	_, err = controller.ListAuditEvents(ctx, _interface.AuditFilter{}, page.NextPageToken, 1)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+	_, err = controller.ListAuditEvents(ctx, _interface.AuditFilter{From: time.Now(), To: time.Now().Add(-time.Hour)}, "", 1)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+}
+
+func TestControllerHistory(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheNone})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+
+	james := auth.WithClaims(ctx, auth.Claims{Subject: "james", Roles: []string{"editor"}})
+	res, err := controller.AddTodo(james, model.TodoModel{
+		Title:       "first",
+		Description: "kept",
+		StartDate:   time.Now(),
+		EndDate:     time.Now().Add(time.Hour),
+	})
+	a.Equal(err, nil)
+	_, err = controller.EditTodo(james, res.Id, model.TodoModel{Title: "second"}, FieldTitle)
+	a.Equal(err, nil)
+	_, err = controller.EditTodo(james, res.Id, model.TodoModel{IsDone: true}, FieldIsDone)
+	a.Equal(err, nil)
+
+	history, err := controller.GetTodoHistory(james, res.Id)
+	a.Equal(err, nil)
+	a.Equal(len(history), 3)
+	a.Equal(history[0].Version, uint64(1))
+	a.Equal(len(history[0].Changes), 6)
+	a.Equal(history[1].Changes, []model.FieldChange{{Field: FieldTitle, Before: "first", After: "second"}})
+	a.Equal(history[2].Changes, []model.FieldChange{{Field: FieldIsDone, Before: false, After: true}})
+	a.Equal(history[2].Actor, "james")
+
+	// a revert is a new edit on top of the history
+	reverted, err := controller.RevertTodo(james, res.Id, 1, 3)
+	a.Equal(err, nil)
+	a.Equal(reverted.Version, uint64(4))
+	a.Equal(reverted.Title, "first")
+	a.Equal(reverted.IsDone, false)
+	a.Equal(reverted.Description, "kept")
+	history, _ = controller.GetTodoHistory(james, res.Id)
+	a.Equal(len(history), 4)
+	a.Equal(len(history[3].Changes), 2)
+
+	// reverting to what is stored already writes nothing
+	same, err := controller.RevertTodo(james, res.Id, 1, 0)
+	a.Equal(err, nil)
+	a.Equal(same.Version, uint64(4))
+
+	_, err = controller.RevertTodo(james, res.Id, 2, 3)
+	a.Equal(apperror.KindOf(err), apperror.KindConflict)
+	_, err = controller.RevertTodo(james, res.Id, 9, 0)
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+	_, err = controller.GetTodoHistory(james, "missing")
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+
+	// reverting goes through the author check like any edit
+	robert := auth.WithClaims(ctx, auth.Claims{Subject: "robert", Roles: []string{"editor"}})
+	_, err = controller.RevertTodo(robert, res.Id, 2, 0)
+	a.Equal(apperror.KindOf(err), apperror.KindPermissionDenied)
+}
+
+func TestControllerWebhooks(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{DbDriver: database.DriverMemory, CacheDriver: database.CacheNone})
+	a.Equal(err, nil)
+	controller, err := CreateTodoController(&db)
+	a.Equal(err, nil)
+	controller.SetWebhookAttempts(2)
+
+	var (
+		mu       sync.Mutex
+		secret   string
+		fail     bool
+		received []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+
+		timestamp, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhook(secret, timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received = append(received, r.Header.Get(WebhookEventHeader))
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	events := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, received...)
+	}
+
+	_, _, err = controller.RegisterWebhook(ctx, "ftp://localhost/hook", nil)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+	// loopback, private and metadata addresses are refused unless allowed
+	for _, rawUrl := range []string{server.URL, "http://localhost/hook", "http://10.0.0.8/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]:8080/hook", "http://[::ffff:192.168.1.1]/hook", "http://100.64.0.1/hook"} {
+		_, _, err = controller.RegisterWebhook(ctx, rawUrl, nil)
+		a.Equal(apperror.KindOf(err), apperror.KindValidation, rawUrl)
+	}
+	a.True(publicIp(net.ParseIP("93.184.216.34")))
+	a.True(publicIp(net.ParseIP("2606:2800:220:1::1")))
+	controller.SetWebhookPrivateHosts(true)
+	_, _, err = controller.RegisterWebhook(ctx, server.URL, []string{"todo.archived"})
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+	hook, key, err := controller.RegisterWebhook(ctx, server.URL, []string{model.WebhookTodoCompleted, model.WebhookTodoDeleted})
+	a.Equal(err, nil)
+	a.True(strings.HasPrefix(key, "whsec_"))
+	mu.Lock()
+	secret = key
+	mu.Unlock()
+
+	test, err := controller.TestWebhook(ctx, hook.Id)
+	a.Equal(err, nil)
+	a.Equal(test, WebhookTest{Delivered: true, StatusCode: http.StatusOK})
+	a.Equal(events(), []string{model.WebhookPing})
+	// the address is checked again on connect, a host that resolves to a private address later is not reached
+	controller.SetWebhookPrivateHosts(false)
+	test, err = controller.TestWebhook(ctx, hook.Id)
+	a.Equal(err, nil)
+	a.Equal(test.Delivered, false)
+	a.Contains(test.Failure, "is not public")
+	a.Equal(len(events()), 1)
+	controller.SetWebhookPrivateHosts(true)
+	_, err = controller.TestWebhook(ctx, "missing")
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+
+	// only the subscribed events are delivered
+	james := auth.WithClaims(ctx, auth.Claims{Subject: "james", Roles: []string{"editor"}})
+	res, err := controller.AddTodo(james, model.TodoModel{
+		Title:     "hooked",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(time.Hour),
+	})
+	a.Equal(err, nil)
+	_, err = controller.EditTodo(james, res.Id, model.TodoModel{IsDone: true}, FieldIsDone)
+	a.Equal(err, nil)
+	now := time.Now()
+	delivered, err := controller.DispatchWebhooks(ctx, now)
+	a.Equal(err, nil)
+	a.Equal(delivered, 1)
+	a.Equal(events(), []string{model.WebhookPing, model.WebhookTodoCompleted})
+
+	// a failing endpoint is retried after a backoff, then dead lettered
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	_, err = controller.DeleteTodo(james, res.Id, 0)
+	a.Equal(err, nil)
+	now = time.Now()
+	delivered, _ = controller.DispatchWebhooks(ctx, now)
+	a.Equal(delivered, 0)
+	hooks, err := controller.ListWebhooks(ctx)
+	a.Equal(err, nil)
+	a.Equal(hooks[0].Pending, int64(1))
+	_, _ = controller.DispatchWebhooks(ctx, now)
+	a.Equal(len(events()), 3)
+	_, _ = controller.DispatchWebhooks(ctx, now.Add(webhookBackoff(1)))
+	a.Equal(len(events()), 4)
+	hooks, _ = controller.ListWebhooks(ctx)
+	a.Equal(hooks[0].Pending, int64(0))
+	a.Equal(hooks[0].Dead, int64(1))
+	_, _ = controller.DispatchWebhooks(ctx, now.Add(24*time.Hour))
+	a.Equal(len(events()), 4)
+
+	_, err = controller.DeleteWebhook(tenant.With(ctx, "acme"), hook.Id)
+	a.Equal(apperror.KindOf(err), apperror.KindNotFound)
+	_, err = controller.DeleteWebhook(ctx, hook.Id)
+	a.Equal(err, nil)
+	hooks, _ = controller.ListWebhooks(ctx)
+	a.Equal(len(hooks), 0)
+
+	a.Equal(webhookBackoff(1), 10*time.Second)
+	a.Equal(webhookBackoff(3), 40*time.Second)
+	a.Equal(webhookBackoff(20), time.Hour)
+}
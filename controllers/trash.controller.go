@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"time"
+	"todo_pikpo/auth"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ListDeletedTodos pages through the trash, most recently deleted first. Callers that may only change
+// their own todos only see their own trash. The trash is not cached.
+func (tc TodoController) ListDeletedTodos(ctx context.Context, page uint, limit uint) ([]model.TodoModel, error) {
+	var author string
+	if claims, ok := auth.ClaimsFrom(ctx); ok && !tc.policy.ChangesAnyAuthor(claims.Roles) {
+		if claims.Subject == "" {
+			return []model.TodoModel{}, nil
+		}
+		author = claims.Subject
+	}
+
+	res, err := tc.dto.GetDeleted(ctx, author, page, limit)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListDeletedTodos controller ", err)
+
+		return []model.TodoModel{}, storeError(err)
+	}
+	return res, nil
+}
+
+// RestoreTodo takes a todo out of the trash, NotFound when it is not there. Like deleting, only
+// the author or a role the policy lets change any author may restore it.
+func (tc TodoController) RestoreTodo(ctx context.Context, id string) (model.TodoModel, error) {
+	trashed, err := tc.dto.GetSingleDeleted(ctx, id)
+	if err == nil {
+		err = tc.checkAuthor(ctx, trashed)
+	}
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RestoreTodo controller ", err)
+
+		return model.TodoModel{}, storeError(err)
+	}
+
+	res, err := tc.dto.Restore(ctx, id)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RestoreTodo controller ", err)
+
+		return model.TodoModel{}, storeError(err)
+	}
+
+	tc.invalidateTodos(ctx, id)
+	tc.invalidateLists(ctx)
+	tc.publish(database.EventRestored, res)
+
+	return res, nil
+}
+
+// purgeBatch is how many todos one purge transaction removes, a backlog is worked off batch by batch.
+const purgeBatch = 500
+
+// PurgeDeleted permanently removes todos of every workspace that have been in the trash longer than retention.
+func (tc TodoController) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	before := time.Now().Add(-retention)
+	var total int64
+	for {
+		purged, err := tc.dto.Purge(ctx, before, purgeBatch)
+		if err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " PurgeDeleted controller ", err)
+
+			return total, storeError(err)
+		}
+		total += purged
+		if purged < purgeBatch || ctx.Err() != nil {
+			return total, nil
+		}
+	}
+}
+
+// RunPurge calls PurgeDeleted every interval until ctx is done.
+func (tc TodoController) RunPurge(ctx context.Context, interval time.Duration, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := tc.PurgeDeleted(ctx, retention); err == nil && purged > 0 {
+				log.Info(time.Now().Format("2006-01-02 15:04:05"), " purged ", purged, " deleted todos")
+			}
+		}
+	}
+}
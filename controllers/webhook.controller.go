@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"todo_pikpo/apperror"
+	model "todo_pikpo/database/models"
+	"todo_pikpo/tenant"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Headers of every webhook request, the signature covers the timestamp and the body.
+const (
+	WebhookEventHeader     = "X-Pikpo-Event"
+	WebhookDeliveryHeader  = "X-Pikpo-Delivery"
+	WebhookTimestampHeader = "X-Pikpo-Timestamp"
+	WebhookSignatureHeader = "X-Pikpo-Signature"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+	// webhookTimeout bounds one delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// webhookBatch is how many outbox events and due deliveries one dispatcher pass takes on.
+	webhookBatch = 100
+	// webhookWorkers is how many deliveries one dispatcher pass attempts at once.
+	webhookWorkers = 8
+	// webhookLease is how long a dispatcher pass holds the deliveries it took, enough for a whole
+	// batch to time out on webhookWorkers workers.
+	webhookLease = 5 * time.Minute
+	// defaultWebhookAttempts applies until SetWebhookAttempts is called.
+	defaultWebhookAttempts = 8
+)
+
+// Webhook is a webhook with the deliveries it has not received yet.
+type Webhook struct {
+	model.WebhookModel
+	Pending int64 `json:"pending"`
+	Dead    int64 `json:"dead"`
+}
+
+// WebhookTest is the outcome of TestWebhook, StatusCode is 0 when the endpoint could not be reached.
+type WebhookTest struct {
+	Delivered  bool   `json:"delivered"`
+	StatusCode int    `json:"statusCode"`
+	Failure    string `json:"failure,omitempty"`
+}
+
+// SignWebhook is the signature header of a webhook request: the hex HMAC-SHA256, keyed with the
+// webhook secret, of the unix timestamp header, a dot and the raw body.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is how long a delivery waits after its nth failed attempt, doubling from 10 seconds up to an hour.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := 10 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	if backoff > time.Hour {
+		return time.Hour
+	}
+	return backoff
+}
+
+// specialNets are the ranges publicIp rejects on top of what net.IP can tell itself: shared
+// address space, benchmarking, the IETF protocol block, reserved space and NAT64.
+var specialNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIp reports whether webhooks may reach ip: loopback, private, link-local (which holds the
+// 169.254.169.254 metadata endpoint), multicast, unspecified and the specialNets are off limits.
+func publicIp(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range specialNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic refuses connections to addresses publicIp rejects. It runs after name resolution for
+// every connection, redirects included, so a host that resolves elsewhere later is caught too.
+func dialPublic(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIp(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// newWebhookClient is the client deliveries are sent with, it only connects to public addresses
+// unless private is set and never goes through a proxy.
+func newWebhookClient(private bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, KeepAlive: 30 * time.Second}
+	if !private {
+		dialer.Control = dialPublic
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// verifyWebhookUrl checks that raw is an http or https URL and, unless private is set, that every
+// address its host resolves to is public.
+func verifyWebhookUrl(ctx context.Context, raw string, private bool) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return apperror.Validation(apperror.FieldViolation{Field: "url", Description: "url should be an absolute http or https URL"})
+	}
+	if private {
+		return nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return apperror.Validation(apperror.FieldViolation{Field: "url", Description: "host " + u.Hostname() + " does not resolve"})
+			}
+			return apperror.Unavailable(err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !publicIp(ip) {
+			return apperror.Validation(apperror.FieldViolation{Field: "url", Description: "url should not point at a loopback, private or other special purpose address"})
+		}
+	}
+	return nil
+}
+
+// verifyWebhookEvents checks events against model.WebhookEvents and drops duplicates, no events subscribes to all.
+func verifyWebhookEvents(events []string) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	for _, event := range events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		known := false
+		for _, e := range model.WebhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return nil, apperror.Validation(apperror.FieldViolation{Field: "events", Description: "event " + event + " is not one of " + strings.Join(model.WebhookEvents, ", ")})
+		}
+		if !seen[event] {
+			seen[event] = true
+			res = append(res, event)
+		}
+	}
+	return res, nil
+}
+
+// SetWebhookAttempts sets how many times a delivery is attempted before it is dead lettered.
+func (tc *TodoController) SetWebhookAttempts(attempts int) {
+	if attempts > 0 {
+		tc.hookAttempts = attempts
+	}
+}
+
+// SetWebhookPrivateHosts lets webhooks reach loopback, private and other special purpose addresses,
+// which is only meant for endpoints inside a trusted network and for tests.
+func (tc *TodoController) SetWebhookPrivateHosts(private bool) {
+	tc.hookPrivate = private
+	tc.hookClient = newWebhookClient(private)
+}
+
+// RegisterWebhook subscribes an endpoint of the caller's workspace to todo events and returns it
+// together with its signing secret, which ListWebhooks never shows.
+func (tc TodoController) RegisterWebhook(ctx context.Context, rawUrl string, events []string) (model.WebhookModel, string, error) {
+	if err := verifyWebhookUrl(ctx, rawUrl, tc.hookPrivate); err != nil {
+		return model.WebhookModel{}, "", err
+	}
+	events, err := verifyWebhookEvents(events)
+	if err != nil {
+		return model.WebhookModel{}, "", err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return model.WebhookModel{}, "", apperror.Internal(err)
+	}
+	hook := model.WebhookModel{
+		Id:        uuid.New().String(),
+		Url:       strings.TrimSpace(rawUrl),
+		Secret:    webhookSecretPrefix + secret,
+		Events:    strings.Join(events, ","),
+		CreatedAt: time.Now(),
+	}
+
+	res, err := tc.webhooks.Create(ctx, hook)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RegisterWebhook controller ", err)
+
+		return model.WebhookModel{}, "", storeError(err)
+	}
+	return res, res.Secret, nil
+}
+
+// ListWebhooks returns the webhooks of the caller's workspace with their pending and dead lettered deliveries.
+func (tc TodoController) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	hooks, err := tc.webhooks.List(ctx)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListWebhooks controller ", err)
+
+		return []Webhook{}, storeError(err)
+	}
+
+	res := make([]Webhook, 0, len(hooks))
+	for _, hook := range hooks {
+		backlog, err := tc.webhooks.Backlog(ctx, hook.Id)
+		if err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " ListWebhooks controller ", err)
+
+			return []Webhook{}, storeError(err)
+		}
+		res = append(res, Webhook{WebhookModel: hook, Pending: backlog[model.DeliveryPending], Dead: backlog[model.DeliveryDead]})
+	}
+	return res, nil
+}
+
+// DeleteWebhook unsubscribes a webhook, its pending and dead lettered deliveries go with it.
+func (tc TodoController) DeleteWebhook(ctx context.Context, id string) (model.WebhookModel, error) {
+	res, err := tc.webhooks.Delete(ctx, id)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " DeleteWebhook controller ", err)
+
+		return model.WebhookModel{}, storeError(err)
+	}
+	return res, nil
+}
+
+// TestWebhook sends a webhook.ping event to a webhook right away. The ping is not retried, an
+// endpoint that refuses it is reported in the result rather than as an error.
+func (tc TodoController) TestWebhook(ctx context.Context, id string) (WebhookTest, error) {
+	hook, err := tc.webhooks.Get(ctx, id)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " TestWebhook controller ", err)
+
+		return WebhookTest{}, storeError(err)
+	}
+
+	now := time.Now()
+	payload := model.WebhookPayload{Id: uuid.New().String(), Type: model.WebhookPing, TenantId: tenant.From(ctx), CreatedAt: now}
+	jd, _ := json.Marshal(payload)
+	code, err := tc.postWebhook(ctx, hook, payload.Id, payload.Type, string(jd), now)
+	if err != nil {
+		return WebhookTest{StatusCode: code, Failure: err.Error()}, nil
+	}
+	return WebhookTest{Delivered: true, StatusCode: code}, nil
+}
+
+// postWebhook sends one signed webhook request, any 2xx answer counts as delivered.
+func (tc TodoController) postWebhook(ctx context.Context, hook model.WebhookModel, deliveryId string, eventType string, payload string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, strings.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, eventType)
+	req.Header.Set(WebhookDeliveryHeader, deliveryId)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, now.Unix(), []byte(payload)))
+
+	resp, err := tc.hookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// fanout turns the outbox events into deliveries for the webhooks of their workspace that want them.
+func (tc TodoController) fanout(ctx context.Context) {
+	events, err := tc.webhooks.Outbox(ctx, webhookBatch)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " DispatchWebhooks controller ", err)
+		return
+	}
+
+	for _, event := range events {
+		hooks, err := tc.webhooks.List(tenant.With(ctx, event.TenantId))
+		if err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " DispatchWebhooks controller ", err)
+			return
+		}
+		var deliveries []model.WebhookDeliveryModel
+		for _, hook := range hooks {
+			if !hook.Wants(event.Type) {
+				continue
+			}
+			deliveries = append(deliveries, model.WebhookDeliveryModel{
+				Id:            uuid.New().String(),
+				TenantId:      event.TenantId,
+				WebhookId:     hook.Id,
+				EventId:       event.Id,
+				Type:          event.Type,
+				Payload:       event.Payload,
+				Status:        model.DeliveryPending,
+				NextAttemptAt: event.CreatedAt,
+				CreatedAt:     event.CreatedAt,
+			})
+		}
+		if err := tc.webhooks.Fanout(ctx, event, deliveries); err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " DispatchWebhooks controller ", err)
+			return
+		}
+	}
+}
+
+// attempt tries one delivery and stores the outcome, a failed delivery is retried after webhookBackoff
+// until it runs out of attempts and is dead lettered. It reports whether the webhook accepted it.
+func (tc TodoController) attempt(ctx context.Context, delivery model.WebhookDeliveryModel, now time.Time) bool {
+	hook, err := tc.webhooks.Get(tenant.With(ctx, delivery.TenantId), delivery.WebhookId)
+	if err != nil {
+		// a webhook deleted since takes its deliveries with it
+		return false
+	}
+
+	_, err = tc.postWebhook(ctx, hook, delivery.Id, delivery.Type, delivery.Payload, now)
+	delivery.Attempts++
+	if err == nil {
+		if err := tc.webhooks.SaveDelivery(ctx, delivery, true); err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " DispatchWebhooks controller ", err)
+		}
+		return true
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= tc.hookAttempts {
+		delivery.Status = model.DeliveryDead
+		log.Warn(time.Now().Format("2006-01-02 15:04:05"), " webhook ", hook.Id, " gave up on delivery ", delivery.Id, " after ", delivery.Attempts, " attempts: ", err)
+	} else {
+		delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+	}
+	if err := tc.webhooks.SaveDelivery(ctx, delivery, false); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " DispatchWebhooks controller ", err)
+	}
+	return false
+}
+
+// DispatchWebhooks is one pass of the webhook dispatcher at now: it hands new outbox events to the
+// webhooks of their workspace and attempts every delivery that is due, returning how many were
+// accepted. Deliveries are at least once, a receiver tells retries apart by the delivery header.
+func (tc TodoController) DispatchWebhooks(ctx context.Context, now time.Time) (int, error) {
+	tc.fanout(ctx)
+
+	due, err := tc.webhooks.Due(ctx, now, now.Add(webhookLease), webhookBatch)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " DispatchWebhooks controller ", err)
+
+		return 0, storeError(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		delivered int
+	)
+	workers := make(chan struct{}, webhookWorkers)
+	for _, delivery := range due {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(delivery model.WebhookDeliveryModel) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if tc.attempt(ctx, delivery, now) {
+				mu.Lock()
+				delivered++
+				mu.Unlock()
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return delivered, nil
+}
+
+// RunWebhooks calls DispatchWebhooks every interval until ctx is done.
+func (tc TodoController) RunWebhooks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if delivered, err := tc.DispatchWebhooks(ctx, now); err == nil && delivered > 0 {
+				log.Info(time.Now().Format("2006-01-02 15:04:05"), " delivered ", delivered, " webhooks")
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/config"
+	model "todo_pikpo/database/models"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	BrokerMemory = "memory"
+	BrokerRedis  = "redis"
+)
+
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRestored = "restored"
+)
+
+// ErrResumeExpired is returned when the events after a resume token are no longer buffered.
+var ErrResumeExpired = apperror.Validation(apperror.FieldViolation{
+	Field:       "resumeToken",
+	Description: "resume token is too old or unknown, watch again without it",
+})
+
+// TodoEvent is a change of a single todo, Seq orders events and doubles as resume token.
+type TodoEvent struct {
+	Seq  uint64          `json:"seq"`
+	Type string          `json:"type"`
+	Todo model.TodoModel `json:"todo"`
+	At   time.Time       `json:"at"`
+}
+
+// Subscription delivers events until Close is called. Events is closed by the broker
+// when the subscriber falls too far behind, the consumer should then resume from
+// the last Seq it has seen.
+type Subscription struct {
+	Events <-chan TodoEvent
+	close  func()
+}
+
+func (s *Subscription) Close() {
+	s.close()
+}
+
+// Only returns a subscription that drops every event keep rejects, closing it closes s too.
+// When s is closed by the broker the filtered Events is closed as well.
+func (s *Subscription) Only(keep func(TodoEvent) bool) *Subscription {
+	ch := make(chan TodoEvent, subscriberBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(ch)
+		for e := range s.Events {
+			if !keep(e) {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return &Subscription{Events: ch, close: func() {
+		once.Do(func() {
+			close(done)
+			s.close()
+		})
+	}}
+}
+
+type Broker interface {
+	Publish(eventType string, todo model.TodoModel) error
+	// Subscribe streams every event with Seq greater than after, buffered events are replayed first.
+	// after 0 only streams new events.
+	Subscribe(after uint64) (*Subscription, error)
+}
+
+func NewBroker(conf config.ConfigApp, client *redis.Client) (Broker, error) {
+	switch conf.BrokerDriver {
+	case BrokerMemory, "":
+		return NewMemoryBroker(conf.BrokerBuffer), nil
+	case BrokerRedis:
+		if client == nil {
+			return nil, fmt.Errorf("broker driver redis needs REDIS_HOST")
+		}
+		return NewRedisBroker(client, conf.CachePrefix, conf.BrokerBuffer), nil
+	}
+	return nil, fmt.Errorf("unknown broker driver %q", conf.BrokerDriver)
+}
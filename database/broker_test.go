@@ -0,0 +1,141 @@
+package database
+
+import (
+	"testing"
+	"time"
+	"todo_pikpo/apperror"
+	model "todo_pikpo/database/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	a := assert.New(t)
+	mb := NewMemoryBroker(10)
+
+	sub, err := mb.Subscribe(0)
+	a.Equal(err, nil)
+	defer sub.Close()
+
+	a.Equal(mb.Publish(EventCreated, model.TodoModel{Id: "1"}), nil)
+	a.Equal(mb.Publish(EventUpdated, model.TodoModel{Id: "1"}), nil)
+
+	e := <-sub.Events
+	a.Equal(e.Seq, uint64(1))
+	a.Equal(e.Type, EventCreated)
+	e = <-sub.Events
+	a.Equal(e.Seq, uint64(2))
+	a.Equal(e.Type, EventUpdated)
+}
+
+func TestMemoryBrokerResume(t *testing.T) {
+	a := assert.New(t)
+	mb := NewMemoryBroker(3)
+
+	for i := 0; i < 5; i++ {
+		_ = mb.Publish(EventCreated, model.TodoModel{Id: "1"})
+	}
+
+	// seq 3,4,5 are buffered, resuming after 2 replays all of them
+	sub, err := mb.Subscribe(2)
+	a.Equal(err, nil)
+	for _, seq := range []uint64{3, 4, 5} {
+		e := <-sub.Events
+		a.Equal(e.Seq, seq)
+	}
+	sub.Close()
+
+	sub, err = mb.Subscribe(5)
+	a.Equal(err, nil)
+	a.Equal(len(sub.Events), 0)
+	sub.Close()
+
+	_, err = mb.Subscribe(1)
+	a.Equal(apperror.KindOf(err), apperror.KindValidation)
+
+	_, err = mb.Subscribe(6)
+	a.Equal(err, ErrResumeExpired)
+}
+
+func TestSubscriptionOnly(t *testing.T) {
+	a := assert.New(t)
+	mb := NewMemoryBroker(1000)
+
+	sub, _ := mb.Subscribe(0)
+	only := sub.Only(func(e TodoEvent) bool { return e.Todo.TenantId == "acme" })
+	_ = mb.Publish(EventCreated, model.TodoModel{Id: "1", TenantId: "globex"})
+	_ = mb.Publish(EventCreated, model.TodoModel{Id: "2", TenantId: "acme"})
+
+	e := <-only.Events
+	a.Equal(e.Todo.Id, "2")
+
+	// closing the filtered subscription ends the relay and unsubscribes
+	only.Close()
+	only.Close()
+	for range only.Events {
+	}
+	mb.mu.Lock()
+	a.Equal(len(mb.subscribers), 0)
+	mb.mu.Unlock()
+}
+
+func TestMemoryBrokerSlowSubscriber(t *testing.T) {
+	a := assert.New(t)
+	mb := NewMemoryBroker(1000)
+
+	sub, _ := mb.Subscribe(0)
+	for i := 0; i < subscriberBuffer+1; i++ {
+		_ = mb.Publish(EventCreated, model.TodoModel{Id: "1"})
+	}
+
+	var received int
+	for range sub.Events {
+		received++
+	}
+	a.Equal(received, subscriberBuffer)
+
+	// closing an already dropped subscription is a no-op
+	sub.Close()
+}
+
+func TestMemoryBrokerOutOfOrder(t *testing.T) {
+	a := assert.New(t)
+	mb := NewMemoryBroker(10)
+	mb.gapWait = 20 * time.Millisecond
+	event := func(seq uint64) TodoEvent {
+		return TodoEvent{Seq: seq, Type: EventCreated, Todo: model.TodoModel{Id: "1"}}
+	}
+
+	sub, err := mb.Subscribe(0)
+	a.Equal(err, nil)
+	mb.deliver(event(4))
+	mb.deliver(event(6))
+
+	// 6 waits for 5, so nobody can hold 6 as resume token while 5 is still on its way
+	a.Equal((<-sub.Events).Seq, uint64(4))
+	a.Equal(len(sub.Events), 0)
+	_, err = mb.Subscribe(6)
+	a.Equal(err, ErrResumeExpired)
+	mb.deliver(event(5))
+	a.Equal((<-sub.Events).Seq, uint64(5))
+	a.Equal((<-sub.Events).Seq, uint64(6))
+	mb.deliver(event(5))
+	a.Equal(len(sub.Events), 0)
+
+	resumed, err := mb.Subscribe(4)
+	a.Equal(err, nil)
+	a.Equal((<-resumed.Events).Seq, uint64(5))
+	a.Equal((<-resumed.Events).Seq, uint64(6))
+	resumed.Close()
+
+	// a Seq that never shows up is given up on: subscribers are closed and cannot resume across it
+	mb.deliver(event(8))
+	_, open := <-sub.Events
+	a.False(open)
+	_, err = mb.Subscribe(6)
+	a.Equal(err, ErrResumeExpired)
+	resumed, err = mb.Subscribe(7)
+	a.Equal(err, nil)
+	a.Equal((<-resumed.Events).Seq, uint64(8))
+	resumed.Close()
+}
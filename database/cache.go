@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"todo_pikpo/config"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CacheRedis = "redis"
+	CacheLru   = "lru"
+	CacheNone  = "none"
+)
+
+var ErrCacheMiss = errors.New("key not found")
+
+// Cache stores JSON encoded values under a key, every implementation applies its own
+// key prefix and TTL so callers only deal with logical keys.
+//
+// Groups of keys are invalidated through namespace versions instead of key scans:
+// callers embed Version(namespace) into their keys and Bump it on writes, so entries
+// written under an older version are never read again and simply expire via TTL.
+type Cache interface {
+	Set(key string, data interface{}) error
+	Get(key string, res interface{}) error
+	Delete(keys ...string) error
+	Version(namespace string) (int64, error)
+	Bump(namespace string) (int64, error)
+}
+
+func NewCache(conf config.ConfigApp, client *redis.Client) (Cache, error) {
+	ttl := time.Duration(conf.CacheTtl) * time.Second
+
+	switch conf.CacheDriver {
+	case CacheRedis, "":
+		if client == nil {
+			log.Warn(time.Now().Format("2006-01-02 15:04:05"), " NewCache redis host is not configured, caching is disabled")
+			return NoopCache{}, nil
+		}
+		return NewRedisCache(client, conf.CachePrefix, ttl), nil
+	case CacheLru:
+		return NewLruCache(conf.CacheSize, ttl), nil
+	case CacheNone:
+		return NoopCache{}, nil
+	}
+	return nil, fmt.Errorf("unknown cache driver %q", conf.CacheDriver)
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"testing"
+	"time"
+	"todo_pikpo/config"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLruCache(t *testing.T) {
+	a := assert.New(t)
+	now := time.Unix(1000, 0)
+	lc := NewLruCache(2, 10*time.Second)
+	lc.SetClock(func() time.Time { return now })
+
+	var res string
+	a.Equal(lc.Get("a", &res), ErrCacheMiss)
+
+	a.Equal(lc.Set("a", "first"), nil)
+	a.Equal(lc.Set("b", "second"), nil)
+	a.Equal(lc.Get("a", &res), nil)
+	a.Equal(res, "first")
+
+	// "b" is the least recently used entry now
+	a.Equal(lc.Set("c", "third"), nil)
+	a.Equal(lc.Len(), 2)
+	a.Equal(lc.Get("b", &res), ErrCacheMiss)
+	a.Equal(lc.Get("c", &res), nil)
+	a.Equal(res, "third")
+
+	now = now.Add(10 * time.Second)
+	a.Equal(lc.Get("a", &res), ErrCacheMiss)
+	a.Equal(lc.Len(), 1)
+}
+
+func TestLruCacheDelete(t *testing.T) {
+	a := assert.New(t)
+	lc := NewLruCache(10, time.Minute)
+
+	_ = lc.Set("list-1", []int{1})
+	_ = lc.Set("list-2", []int{2})
+	_ = lc.Set("id-1", 1)
+
+	a.Equal(lc.Delete("list-1", "list-2", "missing"), nil)
+	a.Equal(lc.Len(), 1)
+
+	var res int
+	a.Equal(lc.Get("id-1", &res), nil)
+	a.Equal(res, 1)
+}
+
+func TestLruCacheVersion(t *testing.T) {
+	a := assert.New(t)
+	lc := NewLruCache(1, time.Minute)
+
+	v, err := lc.Version("list")
+	a.Equal(err, nil)
+	a.Equal(v, int64(0))
+
+	v, err = lc.Bump("list")
+	a.Equal(err, nil)
+	a.Equal(v, int64(1))
+
+	// versions are not entries, filling the LRU must not evict them
+	_ = lc.Set("a", 1)
+	_ = lc.Set("b", 2)
+	v, _ = lc.Version("list")
+	a.Equal(v, int64(1))
+
+	v, _ = lc.Version("other")
+	a.Equal(v, int64(0))
+}
+
+func TestNoopCache(t *testing.T) {
+	a := assert.New(t)
+	var c Cache = NoopCache{}
+
+	var res string
+	a.Equal(c.Set("a", "value"), nil)
+	a.Equal(c.Get("a", &res), ErrCacheMiss)
+	a.Equal(c.Delete("a"), nil)
+
+	v, err := c.Bump("list")
+	a.Equal(err, nil)
+	a.Equal(v, int64(0))
+}
+
+func TestNewCache(t *testing.T) {
+	a := assert.New(t)
+
+	c, err := NewCache(config.ConfigApp{CacheDriver: CacheLru, CacheSize: 5, CacheTtl: 1}, nil)
+	a.Equal(err, nil)
+	a.IsType(&LruCache{}, c)
+
+	c, err = NewCache(config.ConfigApp{CacheDriver: CacheNone}, nil)
+	a.Equal(err, nil)
+	a.IsType(NoopCache{}, c)
+
+	// redis without a client falls back to no caching
+	c, err = NewCache(config.ConfigApp{CacheDriver: CacheRedis}, nil)
+	a.Equal(err, nil)
+	a.IsType(NoopCache{}, c)
+
+	c, err = NewCache(config.ConfigApp{CacheDriver: CacheRedis}, redis.NewClient(&redis.Options{}))
+	a.Equal(err, nil)
+	a.IsType(&RedisCache{}, c)
+
+	_, err = NewCache(config.ConfigApp{CacheDriver: "memcached"}, nil)
+	a.NotEqual(err, nil)
+}
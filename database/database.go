@@ -1,97 +1,168 @@
 package database
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
-	log "github.com/sirupsen/logrus"
-	"time"
+	"todo_pikpo/apperror"
 	"todo_pikpo/config"
 	model "todo_pikpo/database/models"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+const (
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// ErrVersionConflict is returned by conditional writes when the stored version moved on.
+var ErrVersionConflict = apperror.Conflict("todo was changed by someone else, reload it and retry")
+
+// Database holds the storage connections selected by config.DbDriver.
+// Postgres carries the gorm connection for both the postgres and sqlite drivers,
+// Memory is only set for the memory driver. Redis is only set when a host is configured.
 type Database struct {
+	Driver   string
 	Postgres *gorm.DB
+	Memory   *MemoryStore
 	Redis    *redis.Client
+	Cache    Cache
+	Broker   Broker
+	Limits   RateStore
 }
 
-func (db *Database) Migrate() error {
-	err := db.Postgres.AutoMigrate(&model.TodoModel{})
-	return err
+// searchMigrations give postgres a weighted full text vector over title and description,
+// kept up to date by the database itself, and the GIN index SearchTodos queries it through.
+var searchMigrations = []string{
+	`ALTER TABLE todo_models ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'B')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_todo_models_search_vector ON todo_models USING GIN (search_vector)`,
 }
 
-func (db *Database) Flush() error {
-	err := db.Postgres.Where("id is not null").Delete(&model.TodoModel{}).Error
-	return err
+// auditMigrations make postgres refuse to update or delete audit events, only TRUNCATE gets rid of them.
+var auditMigrations = []string{
+	`CREATE OR REPLACE FUNCTION audit_event_models_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit events are append-only';
+		END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS audit_event_models_append_only ON audit_event_models`,
+	`CREATE TRIGGER audit_event_models_append_only BEFORE UPDATE OR DELETE ON audit_event_models
+		FOR EACH ROW EXECUTE FUNCTION audit_event_models_append_only()`,
 }
 
-func (db *Database) AddRedis(key string, data interface{}) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		log.Error(time.Now().Format("2006-01-02 15:04:05"), " Database AddRedis ", err)
+func (db *Database) Migrate() error {
+	if db.Postgres == nil {
+		return nil
+	}
+	err := db.Postgres.AutoMigrate(&model.TodoModel{}, &model.ApiKeyModel{}, &model.AuditEventModel{}, &model.TodoRevisionModel{},
+		&model.WebhookModel{}, &model.OutboxEventModel{}, &model.WebhookDeliveryModel{})
+	if err != nil || db.Driver != DriverPostgres {
 		return err
 	}
 
-	err = db.Redis.Set("pikpo-"+key, jsonData, time.Duration(1200)*time.Second).Err()
-
-	log.Info(time.Now().Format("2006-01-02 15:04:05"), " Database AddRedis ", data)
-
-	return err
-}
-
-func (db *Database) GetRedis(key string, res interface{}) error {
-	val := db.Redis.Get("pikpo-" + key)
-	if val == nil {
-		log.Error(time.Now().Format("2006-01-02 15:04:05"), "Database GetRedis Key Not Found")
-		return errors.New("key not found")
+	for _, stmt := range append(searchMigrations, auditMigrations...) {
+		if err := db.Postgres.Exec(stmt).Error; err != nil {
+			return err
+		}
 	}
-	err := json.Unmarshal([]byte(val.Val()), res)
-
-	log.Info(time.Now().Format("2006-01-02 15:04:05"), " Database GetRedis ", res)
-	return err
+	return nil
 }
 
-func (db *Database) RedisRemove(addPrefix string) error {
-	keys, err := db.Redis.Keys("pikpo-" + addPrefix + "*").Result()
+func (db *Database) Flush() error {
+	if db.Memory != nil {
+		db.Memory.Clear()
+		return nil
+	}
+	err := db.Postgres.Unscoped().Where("id is not null").Delete(&model.TodoModel{}).Error
 	if err != nil {
-		log.Error(time.Now().Format("2006-01-02 15:04:05"), " Database RedisRemove ", err)
 		return err
 	}
-
-	if len(keys) > 0 {
-		err = db.Redis.Del(keys...).Err()
-		if err != nil {
-			log.Error(time.Now().Format("2006-01-02 15:04:05"), " Database RedisRemove -> Key deletion ", err)
+	err = db.Postgres.Where("todo_id is not null").Delete(&model.TodoRevisionModel{}).Error
+	if err != nil {
+		return err
+	}
+	for _, table := range []interface{}{&model.WebhookModel{}, &model.OutboxEventModel{}, &model.WebhookDeliveryModel{}} {
+		if err = db.Postgres.Where("id is not null").Delete(table).Error; err != nil {
 			return err
 		}
 	}
+	if db.Driver == DriverPostgres {
+		err = db.Postgres.Exec("TRUNCATE audit_event_models").Error
+	} else {
+		err = db.Postgres.Where("id is not null").Delete(&model.AuditEventModel{}).Error
+	}
+	if err != nil {
+		return err
+	}
+	return db.Postgres.Where("id is not null").Delete(&model.ApiKeyModel{}).Error
+}
 
-	log.Info(time.Now().Format("2006-01-02 15:04:05"), " Database RedisRemove ", keys)
-	return nil
+func openGorm(conf config.ConfigApp) (*gorm.DB, error) {
+	switch conf.DbDriver {
+	case DriverSqlite:
+		path := conf.SqlitePath
+		if path == "" {
+			path = "file::memory:?cache=shared"
+		}
+		return gorm.Open(sqlite.Open(path), &gorm.Config{})
+	case DriverPostgres, "":
+		return gorm.Open(postgres.Open(
+			fmt.Sprintf("postgres://%s:%s@%s:%d/%s",
+				conf.DbUsername,
+				conf.DbPassword,
+				conf.DbHost,
+				conf.DbPort,
+				conf.DbName),
+		), &gorm.Config{})
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", conf.DbDriver)
+	}
 }
 
 func NewDatabase(conf config.ConfigApp) (db Database, err error) {
 	var newDatabase Database
-	conn, err := gorm.Open(postgres.Open(
-		fmt.Sprintf("postgres://%s:%s@%s:%d/%s",
-			conf.DbUsername,
-			conf.DbPassword,
-			conf.DbHost,
-			conf.DbPort,
-			conf.DbName),
-	), &gorm.Config{})
+	newDatabase.Driver = conf.DbDriver
+	if newDatabase.Driver == "" {
+		newDatabase.Driver = DriverPostgres
+	}
+
+	if newDatabase.Driver == DriverMemory {
+		newDatabase.Memory = NewMemoryStore()
+	} else {
+		conn, err := openGorm(conf)
+		if err != nil {
+			return newDatabase, err
+		}
+		newDatabase.Postgres = conn
+	}
+
+	if conf.RedisHost != "" {
+		newDatabase.Redis = redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%s", conf.RedisHost, conf.RedisPort),
+			Password: conf.RedisPass,
+		})
+	}
+
+	newDatabase.Cache, err = NewCache(conf, newDatabase.Redis)
 	if err != nil {
 		return newDatabase, err
 	}
 
-	newDatabase.Postgres = conn
+	newDatabase.Broker, err = NewBroker(conf, newDatabase.Redis)
+	if err != nil {
+		return newDatabase, err
+	}
 
-	newDatabase.Redis = redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", conf.RedisHost, conf.RedisPort),
-	})
+	newDatabase.Limits, err = NewRateStore(conf, newDatabase.Redis)
+	if err != nil {
+		return newDatabase, err
+	}
 	return newDatabase, nil
 }
@@ -14,13 +14,7 @@ type DbTestSuite struct {
 }
 
 func (s *DbTestSuite) SetupSuite() {
-	c, err := config.NewAppConfig("../.env.test")
-	if err != nil {
-		s.T().Error("Failed to create config:", err)
-		return
-	}
-	s.config = c
-	db, err := NewDatabase(c)
+	db, err := NewDatabase(s.config)
 	if err != nil {
 		s.T().Error("Failed to create database:", err)
 		return
@@ -40,7 +34,22 @@ func (s *DbTestSuite) TearDownTest() {
 }
 
 func TestSuite(t *testing.T) {
-	suite.Run(t, new(DbTestSuite))
+	c, err := config.NewAppConfig("../.env.test")
+	if err != nil {
+		t.Error("Failed to create config:", err)
+		return
+	}
+
+	drivers := []string{DriverSqlite}
+	if c.DbHost != "" {
+		drivers = append(drivers, DriverPostgres)
+	}
+	for _, driver := range drivers {
+		c.DbDriver = driver
+		t.Run(driver, func(t *testing.T) {
+			suite.Run(t, &DbTestSuite{config: c})
+		})
+	}
 }
 
 func (s *DbTestSuite) TestMigrate() {
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"fmt"
+	"time"
+	"todo_pikpo/config"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	RateMemory = "memory"
+	RateRedis  = "redis"
+)
+
+// RateStore keeps the token buckets of the rate limiter and the counters of quotas.
+// The redis store shares them across replicas, the memory store is per process.
+type RateStore interface {
+	// Take removes a token from the bucket at key, which holds up to burst tokens and refills
+	// at rate tokens per second. An empty bucket reports how long until the next token.
+	Take(key string, rate float64, burst int) (ok bool, retryAfter time.Duration, err error)
+	// Consume adds n to the counter at key unless it would go over max, the counter
+	// starts again from zero at reset. A negative n gives back what was added before,
+	// the counter never drops below zero.
+	Consume(key string, n int, max int, reset time.Time) (ok bool, err error)
+}
+
+func NewRateStore(conf config.ConfigApp, client *redis.Client) (RateStore, error) {
+	switch conf.RateDriver {
+	case RateMemory, "":
+		return NewMemoryRateStore(), nil
+	case RateRedis:
+		if client == nil {
+			return nil, fmt.Errorf("rate driver redis needs REDIS_HOST")
+		}
+		return NewRedisRateStore(client, conf.CachePrefix), nil
+	}
+	return nil, fmt.Errorf("unknown rate driver %q", conf.RateDriver)
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"testing"
+	"time"
+	"todo_pikpo/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateStoreTake(t *testing.T) {
+	a := assert.New(t)
+	now := time.Unix(1000, 0)
+	ms := NewMemoryRateStore()
+	ms.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := ms.Take("a", 2, 3)
+		a.Equal(err, nil)
+		a.True(ok)
+	}
+	ok, wait, err := ms.Take("a", 2, 3)
+	a.Equal(err, nil)
+	a.False(ok)
+	a.Equal(wait, 500*time.Millisecond)
+
+	// other keys have their own bucket
+	ok, _, _ = ms.Take("b", 2, 3)
+	a.True(ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _, _ = ms.Take("a", 2, 3)
+	a.True(ok)
+	ok, _, _ = ms.Take("a", 2, 3)
+	a.False(ok)
+
+	// a bucket never refills past its burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _, _ = ms.Take("a", 2, 3)
+		a.True(ok)
+	}
+	ok, _, _ = ms.Take("a", 2, 3)
+	a.False(ok)
+}
+
+func TestMemoryRateStoreConsume(t *testing.T) {
+	a := assert.New(t)
+	now := time.Unix(1000, 0)
+	ms := NewMemoryRateStore()
+	ms.SetClock(func() time.Time { return now })
+	reset := now.Add(time.Hour)
+
+	ok, err := ms.Consume("q", 2, 3, reset)
+	a.Equal(err, nil)
+	a.True(ok)
+	ok, _ = ms.Consume("q", 2, 3, reset)
+	a.False(ok)
+	ok, _ = ms.Consume("q", 1, 3, reset)
+	a.True(ok)
+	ok, _ = ms.Consume("q", 1, 3, reset)
+	a.False(ok)
+	// giving back makes room again, but not more than was taken
+	ok, _ = ms.Consume("q", -5, 3, reset)
+	a.True(ok)
+	ok, _ = ms.Consume("q", 3, 3, reset)
+	a.True(ok)
+	ok, _ = ms.Consume("q", 1, 3, reset)
+	a.False(ok)
+
+	now = reset
+	ok, _ = ms.Consume("q", 3, 3, reset.Add(time.Hour))
+	a.True(ok)
+}
+
+func TestNewRateStore(t *testing.T) {
+	a := assert.New(t)
+
+	rs, err := NewRateStore(config.ConfigApp{}, nil)
+	a.Equal(err, nil)
+	a.IsType(rs, &MemoryRateStore{})
+
+	_, err = NewRateStore(config.ConfigApp{RateDriver: RateRedis}, nil)
+	a.NotEqual(err, nil)
+	_, err = NewRateStore(config.ConfigApp{RateDriver: "carrier pigeon"}, nil)
+	a.NotEqual(err, nil)
+}
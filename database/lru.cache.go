@@ -0,0 +1,133 @@
+package database
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// LruCache is an in-process cache bounded by entry count and TTL.
+// Values are stored JSON encoded so callers get copies, same as with Redis.
+// Namespace versions live outside the LRU so they are never evicted.
+type LruCache struct {
+	mu       sync.Mutex
+	size     int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List
+	versions map[string]int64
+	now      func() time.Time
+}
+
+func NewLruCache(size int, ttl time.Duration) *LruCache {
+	if size <= 0 {
+		size = 1000
+	}
+	return &LruCache{
+		size:     size,
+		ttl:      ttl,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		versions: map[string]int64{},
+		now:      time.Now,
+	}
+}
+
+// SetClock replaces the time source, used by tests to control expiry.
+func (lc *LruCache) SetClock(now func() time.Time) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.now = now
+}
+
+func (lc *LruCache) Set(key string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	var expires time.Time
+	if lc.ttl > 0 {
+		expires = lc.now().Add(lc.ttl)
+	}
+
+	if el, ok := lc.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value = jsonData
+		entry.expires = expires
+		lc.order.MoveToFront(el)
+		return nil
+	}
+
+	lc.entries[key] = lc.order.PushFront(&lruEntry{key: key, value: jsonData, expires: expires})
+	for lc.order.Len() > lc.size {
+		lc.removeElement(lc.order.Back())
+	}
+	return nil
+}
+
+func (lc *LruCache) Get(key string, res interface{}) error {
+	lc.mu.Lock()
+	el, ok := lc.entries[key]
+	if !ok {
+		lc.mu.Unlock()
+		return ErrCacheMiss
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expires.IsZero() && !lc.now().Before(entry.expires) {
+		lc.removeElement(el)
+		lc.mu.Unlock()
+		return ErrCacheMiss
+	}
+	lc.order.MoveToFront(el)
+	value := entry.value
+	lc.mu.Unlock()
+
+	return json.Unmarshal(value, res)
+}
+
+func (lc *LruCache) Delete(keys ...string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := lc.entries[key]; ok {
+			lc.removeElement(el)
+		}
+	}
+	return nil
+}
+
+func (lc *LruCache) Version(namespace string) (int64, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.versions[namespace], nil
+}
+
+func (lc *LruCache) Bump(namespace string) (int64, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.versions[namespace]++
+	return lc.versions[namespace], nil
+}
+
+func (lc *LruCache) Len() int {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.order.Len()
+}
+
+func (lc *LruCache) removeElement(el *list.Element) {
+	lc.order.Remove(el)
+	delete(lc.entries, el.Value.(*lruEntry).key)
+}
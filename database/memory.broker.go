@@ -0,0 +1,173 @@
+package database
+
+import (
+	"sync"
+	"time"
+	model "todo_pikpo/database/models"
+)
+
+const subscriberBuffer = 64
+
+// defaultGapWait is how long events that arrived ahead of a missing Seq wait for it.
+const defaultGapWait = 2 * time.Second
+
+// MemoryBroker fans todo events out to in-process subscribers and keeps the last
+// events in a ring buffer so reconnecting subscribers can resume. Events are handed
+// out in Seq order without gaps, so the buffer stays sorted and a resume token never
+// skips an event.
+type MemoryBroker struct {
+	mu          sync.Mutex
+	seq         uint64
+	buffer      []TodoEvent
+	size        int
+	subscribers map[int]chan TodoEvent
+	nextId      int
+	// pending holds events delivered ahead of a missing Seq until it shows up or gapWait passes
+	pending  map[uint64]TodoEvent
+	gapWait  time.Duration
+	gapTimer *time.Timer
+	// lost is the highest Seq given up on, resuming from before it is no longer possible
+	lost uint64
+}
+
+func NewMemoryBroker(size int) *MemoryBroker {
+	if size <= 0 {
+		size = 1000
+	}
+	return &MemoryBroker{
+		size:        size,
+		subscribers: map[int]chan TodoEvent{},
+		pending:     map[uint64]TodoEvent{},
+		gapWait:     defaultGapWait,
+	}
+}
+
+func (mb *MemoryBroker) Publish(eventType string, todo model.TodoModel) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.emitLocked(TodoEvent{Seq: mb.seq + 1, Type: eventType, Todo: todo, At: time.Now()})
+	return nil
+}
+
+// deliver records an event whose Seq was assigned elsewhere, e.g. by another replica. Events may
+// arrive in any order, one ahead of a missing Seq is held back until the gap is filled.
+func (mb *MemoryBroker) deliver(event TodoEvent) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.seq == 0 && len(mb.buffer) == 0 && len(mb.pending) == 0 {
+		// the first event a fresh broker sees is where its history starts
+		mb.seq = event.Seq - 1
+	}
+	if event.Seq <= mb.seq {
+		// a duplicate, or an event given up on already
+		return
+	}
+	mb.pending[event.Seq] = event
+	mb.flushLocked()
+}
+
+// flushLocked emits the pending events that follow mb.seq without a gap and keeps the gap timer
+// running while events still wait.
+func (mb *MemoryBroker) flushLocked() {
+	for {
+		event, ok := mb.pending[mb.seq+1]
+		if !ok {
+			break
+		}
+		delete(mb.pending, event.Seq)
+		mb.emitLocked(event)
+	}
+
+	if len(mb.pending) == 0 {
+		if mb.gapTimer != nil {
+			mb.gapTimer.Stop()
+			mb.gapTimer = nil
+		}
+		return
+	}
+	if mb.gapTimer == nil {
+		mb.gapTimer = time.AfterFunc(mb.gapWait, mb.skipGap)
+	}
+}
+
+// skipGap gives up on the missing Seq the pending events wait for. Subscribers are closed, as they
+// would miss it otherwise, and resuming from before the gap is refused from then on.
+func (mb *MemoryBroker) skipGap() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.gapTimer = nil
+	if len(mb.pending) == 0 {
+		return
+	}
+	var next uint64
+	for seq := range mb.pending {
+		if next == 0 || seq < next {
+			next = seq
+		}
+	}
+	mb.lost = next - 1
+	mb.seq = next - 1
+	for id, ch := range mb.subscribers {
+		close(ch)
+		delete(mb.subscribers, id)
+	}
+	mb.flushLocked()
+}
+
+// emitLocked appends the next event in Seq order to the buffer and hands it to the subscribers.
+func (mb *MemoryBroker) emitLocked(event TodoEvent) {
+	mb.seq = event.Seq
+
+	mb.buffer = append(mb.buffer, event)
+	if len(mb.buffer) > mb.size {
+		mb.buffer = mb.buffer[len(mb.buffer)-mb.size:]
+	}
+
+	for id, ch := range mb.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// too slow, close so the consumer resumes instead of silently missing events
+			close(ch)
+			delete(mb.subscribers, id)
+		}
+	}
+}
+
+func (mb *MemoryBroker) Subscribe(after uint64) (*Subscription, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	var replay []TodoEvent
+	if after > 0 {
+		if after > mb.seq || after < mb.lost || (len(mb.buffer) > 0 && after < mb.buffer[0].Seq-1) || (len(mb.buffer) == 0 && after < mb.seq) {
+			return nil, ErrResumeExpired
+		}
+		for _, e := range mb.buffer {
+			if e.Seq > after {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan TodoEvent, len(replay)+subscriberBuffer)
+	for _, e := range replay {
+		ch <- e
+	}
+
+	id := mb.nextId
+	mb.nextId++
+	mb.subscribers[id] = ch
+
+	return &Subscription{Events: ch, close: func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		if _, ok := mb.subscribers[id]; ok {
+			close(ch)
+			delete(mb.subscribers, id)
+		}
+	}}, nil
+}
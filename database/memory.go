@@ -0,0 +1,391 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"time"
+	model "todo_pikpo/database/models"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore is a thread-safe in-process table of todos used by the "memory" driver.
+// Rows keep their insertion order so pagination behaves like an un-ordered SQL scan.
+// API keys and webhooks live in tables of their own that transactions leave alone, audit events,
+// revisions and outbox events appended in a transaction only reach the store when it commits.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	rows  map[string]model.TodoModel
+	order []string
+	keys  map[string]model.ApiKeyModel
+	audit []model.AuditEventModel
+	// revisions holds the revisions of each todo id, oldest first
+	revisions  map[string][]model.TodoRevisionModel
+	webhooks   map[string]model.WebhookModel
+	outbox     []model.OutboxEventModel
+	deliveries map[string]model.WebhookDeliveryModel
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rows:       map[string]model.TodoModel{},
+		keys:       map[string]model.ApiKeyModel{},
+		revisions:  map[string][]model.TodoRevisionModel{},
+		webhooks:   map[string]model.WebhookModel{},
+		deliveries: map[string]model.WebhookDeliveryModel{},
+	}
+}
+
+// All returns a snapshot of every row in insertion order.
+func (ms *MemoryStore) All() []model.TodoModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	res := make([]model.TodoModel, 0, len(ms.order))
+	for _, id := range ms.order {
+		res = append(res, ms.rows[id])
+	}
+	return res
+}
+
+func (ms *MemoryStore) Get(id string) (model.TodoModel, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	data, ok := ms.rows[id]
+	if !ok {
+		return model.TodoModel{}, gorm.ErrRecordNotFound
+	}
+	return data, nil
+}
+
+// Records are what a write of a todo leaves next to the row: its revisions, audit events and outbox events.
+type Records struct {
+	Revisions []model.TodoRevisionModel
+	Audit     []model.AuditEventModel
+	Outbox    []model.OutboxEventModel
+}
+
+// appendRecords stores records, the caller holds the write lock.
+func (ms *MemoryStore) appendRecords(records Records) {
+	for _, revision := range records.Revisions {
+		ms.revisions[revision.TodoId] = append(ms.revisions[revision.TodoId], revision)
+	}
+	ms.audit = append(ms.audit, records.Audit...)
+	ms.outbox = append(ms.outbox, records.Outbox...)
+}
+
+func (ms *MemoryStore) Insert(data model.TodoModel) error {
+	return ms.InsertRecorded(data, Records{})
+}
+
+// InsertRecorded adds a row together with its records under one write lock, so no reader sees
+// the row without them.
+func (ms *MemoryStore) InsertRecorded(data model.TodoModel, records Records) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.rows[data.Id]; ok {
+		return errors.New("duplicate primary key " + data.Id)
+	}
+	ms.rows[data.Id] = data
+	ms.order = append(ms.order, data.Id)
+	ms.appendRecords(records)
+	return nil
+}
+
+// Modify applies fn to the stored row while holding the write lock, so read-modify-write
+// sequences cannot interleave with other writers.
+func (ms *MemoryStore) Modify(id string, fn func(data *model.TodoModel) error) (model.TodoModel, error) {
+	return ms.ModifyRecorded(id, func(data *model.TodoModel) (Records, error) {
+		return Records{}, fn(data)
+	})
+}
+
+// ModifyRecorded is Modify for writes that leave records, fn returns them and they are stored
+// under the same write lock as the changed row.
+func (ms *MemoryStore) ModifyRecorded(id string, fn func(data *model.TodoModel) (Records, error)) (model.TodoModel, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data, ok := ms.rows[id]
+	if !ok {
+		return model.TodoModel{}, gorm.ErrRecordNotFound
+	}
+	records, err := fn(&data)
+	if err != nil {
+		return model.TodoModel{}, err
+	}
+	ms.rows[id] = data
+	ms.appendRecords(records)
+	return data, nil
+}
+
+func (ms *MemoryStore) Remove(id string) {
+	_ = ms.RemoveIf(id, nil)
+}
+
+// RemoveIf deletes the row when check, if given, accepts it. Like Modify, the check
+// and the delete happen under one write lock.
+func (ms *MemoryStore) RemoveIf(id string, check func(data model.TodoModel) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data, ok := ms.rows[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if check != nil {
+		if err := check(data); err != nil {
+			return err
+		}
+	}
+	delete(ms.rows, id)
+	for i, v := range ms.order {
+		if v == id {
+			ms.order = append(ms.order[:i], ms.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Clear() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.rows = map[string]model.TodoModel{}
+	ms.order = nil
+	ms.keys = map[string]model.ApiKeyModel{}
+	ms.audit = nil
+	ms.revisions = map[string][]model.TodoRevisionModel{}
+	ms.webhooks = map[string]model.WebhookModel{}
+	ms.outbox = nil
+	ms.deliveries = map[string]model.WebhookDeliveryModel{}
+}
+
+// AuditEvents returns a snapshot of the audit log in the order events were appended.
+func (ms *MemoryStore) AuditEvents() []model.AuditEventModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append([]model.AuditEventModel{}, ms.audit...)
+}
+
+func (ms *MemoryStore) AppendAudit(events ...model.AuditEventModel) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.audit = append(ms.audit, events...)
+}
+
+// Keys returns a snapshot of every API key.
+func (ms *MemoryStore) Keys() []model.ApiKeyModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	res := make([]model.ApiKeyModel, 0, len(ms.keys))
+	for _, key := range ms.keys {
+		res = append(res, key)
+	}
+	return res
+}
+
+func (ms *MemoryStore) GetKey(id string) (model.ApiKeyModel, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	key, ok := ms.keys[id]
+	if !ok {
+		return model.ApiKeyModel{}, gorm.ErrRecordNotFound
+	}
+	return key, nil
+}
+
+func (ms *MemoryStore) InsertKey(key model.ApiKeyModel) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.keys[key.Id]; ok {
+		return errors.New("duplicate primary key " + key.Id)
+	}
+	ms.keys[key.Id] = key
+	return nil
+}
+
+// ModifyKey applies fn to the stored API key under the write lock, like Modify.
+func (ms *MemoryStore) ModifyKey(id string, fn func(key *model.ApiKeyModel) error) (model.ApiKeyModel, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key, ok := ms.keys[id]
+	if !ok {
+		return model.ApiKeyModel{}, gorm.ErrRecordNotFound
+	}
+	if err := fn(&key); err != nil {
+		return model.ApiKeyModel{}, err
+	}
+	ms.keys[id] = key
+	return key, nil
+}
+
+// Revisions returns a snapshot of the revisions of a todo, oldest first.
+func (ms *MemoryStore) Revisions(todoId string) []model.TodoRevisionModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append([]model.TodoRevisionModel{}, ms.revisions[todoId]...)
+}
+
+// RemoveRevisions forgets every revision of a todo.
+func (ms *MemoryStore) RemoveRevisions(todoId string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.revisions, todoId)
+}
+
+// Webhooks returns a snapshot of every webhook.
+func (ms *MemoryStore) Webhooks() []model.WebhookModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	res := make([]model.WebhookModel, 0, len(ms.webhooks))
+	for _, hook := range ms.webhooks {
+		res = append(res, hook)
+	}
+	return res
+}
+
+func (ms *MemoryStore) InsertWebhook(hook model.WebhookModel) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.webhooks[hook.Id]; ok {
+		return errors.New("duplicate primary key " + hook.Id)
+	}
+	ms.webhooks[hook.Id] = hook
+	return nil
+}
+
+// RemoveWebhook deletes the webhook and its deliveries when check accepts it, under one write lock.
+func (ms *MemoryStore) RemoveWebhook(id string, check func(hook model.WebhookModel) error) (model.WebhookModel, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	hook, ok := ms.webhooks[id]
+	if !ok {
+		return model.WebhookModel{}, gorm.ErrRecordNotFound
+	}
+	if err := check(hook); err != nil {
+		return model.WebhookModel{}, err
+	}
+	delete(ms.webhooks, id)
+	for deliveryId, delivery := range ms.deliveries {
+		if delivery.WebhookId == id {
+			delete(ms.deliveries, deliveryId)
+		}
+	}
+	return hook, nil
+}
+
+// Outbox returns a snapshot of the outbox in the order events were appended.
+func (ms *MemoryStore) Outbox() []model.OutboxEventModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append([]model.OutboxEventModel{}, ms.outbox...)
+}
+
+// Fanout swaps an outbox event for its deliveries, it does nothing when the event is gone already.
+func (ms *MemoryStore) Fanout(eventId string, deliveries []model.WebhookDeliveryModel) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i, event := range ms.outbox {
+		if event.Id == eventId {
+			ms.outbox = append(ms.outbox[:i:i], ms.outbox[i+1:]...)
+			for _, delivery := range deliveries {
+				ms.deliveries[delivery.Id] = delivery
+			}
+			return
+		}
+	}
+}
+
+// Deliveries returns a snapshot of every webhook delivery.
+func (ms *MemoryStore) Deliveries() []model.WebhookDeliveryModel {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	res := make([]model.WebhookDeliveryModel, 0, len(ms.deliveries))
+	for _, delivery := range ms.deliveries {
+		res = append(res, delivery)
+	}
+	return res
+}
+
+// LeaseDeliveries hands those deliveries of ids that are still pending and due at now to lease until
+// until and returns them in the order of ids.
+func (ms *MemoryStore) LeaseDeliveries(ids []string, now time.Time, until time.Time, lease string) []model.WebhookDeliveryModel {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	res := []model.WebhookDeliveryModel{}
+	for _, id := range ids {
+		delivery, ok := ms.deliveries[id]
+		if !ok || delivery.Status != model.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		delivery.LeaseId = lease
+		delivery.NextAttemptAt = until
+		ms.deliveries[id] = delivery
+		res = append(res, delivery)
+	}
+	return res
+}
+
+// SaveDelivery replaces a delivery and ends its lease, or forgets it once delivered. It reports false
+// and changes nothing when the delivery is gone, as those of a removed webhook are, or was leased again.
+func (ms *MemoryStore) SaveDelivery(delivery model.WebhookDeliveryModel, delivered bool) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.deliveries[delivery.Id]
+	if !ok || stored.LeaseId != delivery.LeaseId {
+		return false
+	}
+	if delivered {
+		delete(ms.deliveries, delivery.Id)
+		return true
+	}
+	delivery.LeaseId = ""
+	ms.deliveries[delivery.Id] = delivery
+	return true
+}
+
+// Transaction runs fn against a copy of the store while holding the write lock,
+// the copy replaces the store contents only when fn succeeds.
+func (ms *MemoryStore) Transaction(fn func(tx *MemoryStore) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tx := NewMemoryStore()
+	for id, row := range ms.rows {
+		tx.rows[id] = row
+	}
+	tx.order = append(tx.order, ms.order...)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	ms.rows = tx.rows
+	ms.order = tx.order
+	ms.audit = append(ms.audit, tx.audit...)
+	ms.outbox = append(ms.outbox, tx.outbox...)
+	for todoId, revisions := range tx.revisions {
+		ms.revisions[todoId] = append(ms.revisions[todoId], revisions...)
+	}
+	return nil
+}
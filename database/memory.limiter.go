@@ -0,0 +1,104 @@
+package database
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled, after that it can be forgotten
+	full time.Time
+}
+
+type counter struct {
+	n     int
+	reset time.Time
+}
+
+// MemoryRateStore is the RateStore of a single process.
+type MemoryRateStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	counters map[string]*counter
+	swept    time.Time
+	now      func() time.Time
+}
+
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{buckets: map[string]*bucket{}, counters: map[string]*counter{}, now: time.Now}
+}
+
+// SetClock replaces the time source, used by tests to control refills and resets.
+func (ms *MemoryRateStore) SetClock(now func() time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.now = now
+}
+
+// sweep drops refilled buckets and expired counters once a minute, they behave like missing ones.
+func (ms *MemoryRateStore) sweep(now time.Time) {
+	if now.Sub(ms.swept) < time.Minute {
+		return
+	}
+	ms.swept = now
+	for key, b := range ms.buckets {
+		if now.After(b.full) {
+			delete(ms.buckets, key)
+		}
+	}
+	for key, c := range ms.counters {
+		if !now.Before(c.reset) {
+			delete(ms.counters, key)
+		}
+	}
+}
+
+func (ms *MemoryRateStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+	ms.sweep(now)
+
+	b, ok := ms.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		ms.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+		return false, wait, nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return true, 0, nil
+}
+
+func (ms *MemoryRateStore) Consume(key string, n int, max int, reset time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+	ms.sweep(now)
+
+	c, ok := ms.counters[key]
+	if !ok || !now.Before(c.reset) {
+		c = &counter{reset: reset}
+		ms.counters[key] = c
+	}
+	if c.n+n > max {
+		return false, nil
+	}
+	c.n += n
+	if c.n < 0 {
+		c.n = 0
+	}
+	return true, nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	model "todo_pikpo/database/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestMemoryStore(t *testing.T) {
+	a := assert.New(t)
+	ms := NewMemoryStore()
+
+	a.Equal(ms.Insert(model.TodoModel{Id: "1", Title: "first"}), nil)
+	a.Equal(ms.Insert(model.TodoModel{Id: "2", Title: "second"}), nil)
+	a.NotEqual(ms.Insert(model.TodoModel{Id: "1"}), nil)
+
+	_, err := ms.Get("3")
+	a.Equal(err, gorm.ErrRecordNotFound)
+
+	res, err := ms.Modify("1", func(data *model.TodoModel) error {
+		data.Title = "changed"
+		return nil
+	})
+	a.Equal(err, nil)
+	a.Equal(res.Title, "changed")
+
+	ms.Remove("1")
+	all := ms.All()
+	a.Equal(len(all), 1)
+	a.Equal(all[0].Id, "2")
+
+	ms.Clear()
+	a.Equal(len(ms.All()), 0)
+}
+
+func TestMemoryStoreRecords(t *testing.T) {
+	a := assert.New(t)
+	ms := NewMemoryStore()
+
+	// records are stored with the row, a refused write stores none
+	records := Records{
+		Revisions: []model.TodoRevisionModel{{TodoId: "1", Version: 1}},
+		Audit:     []model.AuditEventModel{{Id: "a1", TodoId: "1"}},
+		Outbox:    []model.OutboxEventModel{{Id: "o1", TodoId: "1"}},
+	}
+	a.Equal(ms.InsertRecorded(model.TodoModel{Id: "1"}, records), nil)
+	a.NotEqual(ms.InsertRecorded(model.TodoModel{Id: "1"}, records), nil)
+	_, err := ms.ModifyRecorded("1", func(data *model.TodoModel) (Records, error) {
+		return records, fmt.Errorf("refused")
+	})
+	a.NotEqual(err, nil)
+	a.Equal(len(ms.Revisions("1")), 1)
+	a.Equal(len(ms.AuditEvents()), 1)
+	a.Equal(len(ms.Outbox()), 1)
+
+	res, err := ms.ModifyRecorded("1", func(data *model.TodoModel) (Records, error) {
+		data.Title = "changed"
+		return Records{Audit: []model.AuditEventModel{{Id: "a2", TodoId: "1"}}}, nil
+	})
+	a.Equal(err, nil)
+	a.Equal(res.Title, "changed")
+	a.Equal(len(ms.AuditEvents()), 2)
+	a.Equal(len(ms.Outbox()), 1)
+}
+
+func TestMemoryStoreTransaction(t *testing.T) {
+	a := assert.New(t)
+	ms := NewMemoryStore()
+	a.Equal(ms.Insert(model.TodoModel{Id: "1"}), nil)
+
+	err := ms.Transaction(func(tx *MemoryStore) error {
+		_ = tx.Insert(model.TodoModel{Id: "2"})
+		tx.Remove("1")
+		return fmt.Errorf("rollback")
+	})
+	a.NotEqual(err, nil)
+	_, err = ms.Get("1")
+	a.Equal(err, nil)
+	a.Equal(len(ms.All()), 1)
+
+	err = ms.Transaction(func(tx *MemoryStore) error {
+		return tx.Insert(model.TodoModel{Id: "2"})
+	})
+	a.Equal(err, nil)
+	a.Equal(len(ms.All()), 2)
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	ms := NewMemoryStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("%d", i)
+			_ = ms.Insert(model.TodoModel{Id: id})
+			_, _ = ms.Modify(id, func(data *model.TodoModel) error {
+				data.IsDone = true
+				return nil
+			})
+			_ = ms.All()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(ms.All()), 50)
+}
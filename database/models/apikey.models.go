@@ -0,0 +1,34 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// ApiKeyModel is a bearer key of a workspace, only a salted hash of its secret is stored.
+type ApiKeyModel struct {
+	Id       string `json:"id" gorm:"primary_key"`
+	TenantId string `json:"tenantId" gorm:"index;not null;default:'default'"`
+	Name     string `json:"name" gorm:"not_null"`
+	// Scopes is the comma separated list of read, write and admin.
+	Scopes     string     `json:"scopes" gorm:"not_null"`
+	Salt       string     `json:"-" gorm:"not_null"`
+	Hash       string     `json:"-" gorm:"not_null"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// ScopeList splits Scopes.
+func (k ApiKeyModel) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// ValidAt reports whether the key is neither expired nor revoked at t.
+func (k ApiKeyModel) ValidAt(t time.Time) bool {
+	return (k.ExpiresAt == nil || t.Before(*k.ExpiresAt)) && (k.RevokedAt == nil || t.Before(*k.RevokedAt))
+}
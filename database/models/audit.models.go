@@ -0,0 +1,72 @@
+package model
+
+import (
+	"reflect"
+	"time"
+)
+
+// AuditEventModel is one change of a todo. Events are written in the transaction of the change
+// and never updated or deleted afterwards.
+type AuditEventModel struct {
+	Id       string `json:"id" gorm:"primary_key"`
+	TenantId string `json:"tenantId" gorm:"index;not null;default:'default'"`
+	TodoId   string `json:"todoId" gorm:"index;not null"`
+	// Action is created, updated, deleted, restored or purged.
+	Action string `json:"action" gorm:"not null"`
+	// Actor is the subject of the caller, "key" for a static workspace key and "system" for background work.
+	Actor     string `json:"actor" gorm:"index;not null"`
+	Method    string `json:"method"`
+	RequestId string `json:"requestId" gorm:"index"`
+	// Before and After hold the todo as JSON, Before is empty for created todos and After for purged ones.
+	Before string `json:"before" gorm:"type:text"`
+	After  string `json:"after" gorm:"type:text"`
+	// Changes holds the FieldChange list between Before and After as JSON.
+	Changes   string    `json:"changes" gorm:"type:text"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+// FieldChange is a todo field that differs between two versions of the todo, times are in UTC
+// and a nil value stands for a todo that did not exist.
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// changeFields are the fields TodoChanges compares in the order it reports them, named as in the API.
+// Bookkeeping columns such as version and updatedAt change with every write and are left out.
+var changeFields = []string{"author", "title", "description", "isDone", "startDate", "endDate", "deletedAt"}
+
+func changeValues(data *TodoModel) map[string]interface{} {
+	if data == nil {
+		return map[string]interface{}{}
+	}
+	var deletedAt interface{}
+	if data.DeletedAt.Valid {
+		deletedAt = data.DeletedAt.Time.UTC()
+	}
+	return map[string]interface{}{
+		"author":      data.Author,
+		"title":       data.Title,
+		"description": data.Description,
+		"isDone":      data.IsDone,
+		"startDate":   data.StartDate.UTC(),
+		"endDate":     data.EndDate.UTC(),
+		"deletedAt":   deletedAt,
+	}
+}
+
+// TodoChanges lists the fields that differ between before and after, either may be nil.
+func TodoChanges(before *TodoModel, after *TodoModel) []FieldChange {
+	old, next := changeValues(before), changeValues(after)
+	changes := []FieldChange{}
+	for _, field := range changeFields {
+		oldTime, isTime := old[field].(time.Time)
+		nextTime, bothTime := next[field].(time.Time)
+		if (isTime && bothTime && oldTime.Equal(nextTime)) || reflect.DeepEqual(old[field], next[field]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, Before: old[field], After: next[field]})
+	}
+	return changes
+}
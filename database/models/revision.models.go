@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+// TodoRevisionModel is the content of a todo as one version left it, a revision is stored with
+// every create and edit so the todo can be looked back on and reverted.
+type TodoRevisionModel struct {
+	TodoId      string    `json:"todoId" gorm:"primary_key"`
+	Version     uint64    `json:"version" gorm:"primary_key;autoIncrement:false"`
+	TenantId    string    `json:"tenantId" gorm:"index;not null;default:'default'"`
+	Author      string    `json:"author"`
+	Title       string    `json:"title"`
+	Description string    `json:"description" gorm:"type:text"`
+	IsDone      bool      `json:"isDone"`
+	StartDate   time.Time `json:"startDate"`
+	EndDate     time.Time `json:"endDate"`
+	// Actor made the change, see AuditEventModel.Actor.
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RevisionOf snapshots the content of data at its current version.
+func RevisionOf(data TodoModel, actor string, at time.Time) TodoRevisionModel {
+	return TodoRevisionModel{
+		TodoId:      data.Id,
+		Version:     data.Version,
+		TenantId:    data.TenantId,
+		Author:      data.Author,
+		Title:       data.Title,
+		Description: data.Description,
+		IsDone:      data.IsDone,
+		StartDate:   data.StartDate,
+		EndDate:     data.EndDate,
+		Actor:       actor,
+		CreatedAt:   at,
+	}
+}
+
+// Todo is the todo as of the revision, without the columns revisions do not keep.
+func (r TodoRevisionModel) Todo() TodoModel {
+	return TodoModel{
+		Id:          r.TodoId,
+		TenantId:    r.TenantId,
+		Author:      r.Author,
+		Title:       r.Title,
+		Description: r.Description,
+		IsDone:      r.IsDone,
+		StartDate:   r.StartDate,
+		EndDate:     r.EndDate,
+		UpdatedAt:   r.CreatedAt,
+		Version:     r.Version,
+	}
+}
@@ -2,16 +2,21 @@ package model
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type TodoModel struct {
-	Id          string    `json:"id" gorm:"primary_key"`
-	Author      string    `json:"author" gorm:"not_null"`
-	Title       string    `json:"title" gorm:"not_null"`
-	Description string    `json:"description" gorm:"type:text"`
-	IsDone      bool      `json:"isDone" gorm:"default:false"`
-	StartDate   time.Time `json:"startDate"`
-	EndDate     time.Time `json:"endDate"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	Id          string         `json:"id" gorm:"primary_key"`
+	TenantId    string         `json:"tenantId" gorm:"index;not null;default:'default'"`
+	Author      string         `json:"author" gorm:"not_null"`
+	Title       string         `json:"title" gorm:"not_null"`
+	Description string         `json:"description" gorm:"type:text"`
+	IsDone      bool           `json:"isDone" gorm:"default:false"`
+	StartDate   time.Time      `json:"startDate"`
+	EndDate     time.Time      `json:"endDate"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	Version     uint64         `json:"version" gorm:"not null;default:1"`
+	DeletedAt   gorm.DeletedAt `json:"deletedAt" gorm:"index"`
 }
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Webhook event types, a webhook subscribed to none of them receives all of them.
+const (
+	WebhookTodoCreated   = "todo.created"
+	WebhookTodoUpdated   = "todo.updated"
+	WebhookTodoCompleted = "todo.completed"
+	WebhookTodoDeleted   = "todo.deleted"
+	WebhookTodoRestored  = "todo.restored"
+	// WebhookPing is only sent by TestWebhook.
+	WebhookPing = "webhook.ping"
+)
+
+var WebhookEvents = []string{WebhookTodoCreated, WebhookTodoUpdated, WebhookTodoCompleted, WebhookTodoDeleted, WebhookTodoRestored}
+
+// Delivery states, delivered deliveries are removed rather than kept in a state of their own.
+const (
+	DeliveryPending = "pending"
+	DeliveryDead    = "dead"
+)
+
+// WebhookModel is an endpoint of a workspace that receives todo events. The secret signs every
+// delivery, so unlike API keys it is stored as is.
+type WebhookModel struct {
+	Id       string `json:"id" gorm:"primary_key"`
+	TenantId string `json:"tenantId" gorm:"index;not null;default:'default'"`
+	Url      string `json:"url" gorm:"not null"`
+	Secret   string `json:"-" gorm:"not null"`
+	// Events is the comma separated list of subscribed event types, empty subscribes to all.
+	Events    string    `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// EventList splits Events.
+func (w WebhookModel) EventList() []string {
+	if w.Events == "" {
+		return nil
+	}
+	return strings.Split(w.Events, ",")
+}
+
+// Wants reports whether the webhook is subscribed to events of the given type.
+func (w WebhookModel) Wants(eventType string) bool {
+	for _, e := range w.EventList() {
+		if e == eventType {
+			return true
+		}
+	}
+	return w.Events == ""
+}
+
+// OutboxEventModel is a todo event waiting to be handed to the webhooks of its workspace. Events are
+// written in the transaction of the change they announce and removed once their deliveries exist.
+type OutboxEventModel struct {
+	Id       string `json:"id" gorm:"primary_key"`
+	TenantId string `json:"tenantId" gorm:"index;not null;default:'default'"`
+	TodoId   string `json:"todoId" gorm:"not null"`
+	Type     string `json:"type" gorm:"not null"`
+	// Payload is the WebhookPayload as JSON, the exact body every webhook receives.
+	Payload   string    `json:"payload" gorm:"type:text"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+// WebhookDeliveryModel is an outbox event on its way to one webhook.
+type WebhookDeliveryModel struct {
+	Id        string `json:"id" gorm:"primary_key"`
+	TenantId  string `json:"tenantId" gorm:"index;not null;default:'default'"`
+	WebhookId string `json:"webhookId" gorm:"index;not null"`
+	EventId   string `json:"eventId" gorm:"not null"`
+	Type      string `json:"type" gorm:"not null"`
+	Payload   string `json:"payload" gorm:"type:text"`
+	// Status is DeliveryPending until the webhook accepts it or DeliveryDead once it ran out of attempts.
+	Status        string    `json:"status" gorm:"index;not null"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" gorm:"index"`
+	LastError     string    `json:"lastError"`
+	// LeaseId names the dispatcher pass that took the delivery on, only that pass may store its outcome.
+	LeaseId   string    `json:"-" gorm:"index"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookPayload is the JSON body of a webhook request, Todo is the todo after the change and
+// Changes lists the fields the change touched.
+type WebhookPayload struct {
+	Id        string        `json:"id"`
+	Type      string        `json:"type"`
+	TenantId  string        `json:"tenantId"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Todo      *TodoModel    `json:"todo,omitempty"`
+	Changes   []FieldChange `json:"changes,omitempty"`
+}
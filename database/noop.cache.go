@@ -0,0 +1,24 @@
+package database
+
+// NoopCache never stores anything, every Get is a miss.
+type NoopCache struct{}
+
+func (NoopCache) Set(key string, data interface{}) error {
+	return nil
+}
+
+func (NoopCache) Get(key string, res interface{}) error {
+	return ErrCacheMiss
+}
+
+func (NoopCache) Delete(keys ...string) error {
+	return nil
+}
+
+func (NoopCache) Version(namespace string) (int64, error) {
+	return 0, nil
+}
+
+func (NoopCache) Bump(namespace string) (int64, error) {
+	return 0, nil
+}
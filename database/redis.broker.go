@@ -0,0 +1,79 @@
+package database
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	model "todo_pikpo/database/models"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// publishScript numbers an event and publishes it in one step, so the channel carries events in
+// Seq order. Messages are the Seq, a space and the event JSON without its Seq.
+var publishScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', ARGV[1], seq .. ' ' .. ARGV[2])
+return seq
+`)
+
+// RedisBroker shares todo events between replicas through Redis pub/sub.
+// Sequence numbers come from a Redis counter so resume tokens are valid on every replica,
+// each replica keeps its own replay buffer fed by the pub/sub channel. The local broker still
+// puts events in order, a message lost on a reconnect only holds the later ones back a moment.
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+	seqKey  string
+	local   *MemoryBroker
+}
+
+func NewRedisBroker(client *redis.Client, prefix string, size int) *RedisBroker {
+	rb := &RedisBroker{
+		client:  client,
+		channel: prefix + "events",
+		seqKey:  prefix + "events-seq",
+		local:   NewMemoryBroker(size),
+	}
+
+	pubsub := client.Subscribe(rb.channel)
+	go rb.receive(pubsub)
+	return rb
+}
+
+func (rb *RedisBroker) receive(pubsub *redis.PubSub) {
+	for msg := range pubsub.Channel() {
+		var event TodoEvent
+		seq, payload, _ := strings.Cut(msg.Payload, " ")
+		n, err := strconv.ParseUint(seq, 10, 64)
+		if err == nil {
+			err = json.Unmarshal([]byte(payload), &event)
+		}
+		if err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisBroker receive ", err)
+			continue
+		}
+		event.Seq = n
+		rb.local.deliver(event)
+	}
+}
+
+func (rb *RedisBroker) Publish(eventType string, todo model.TodoModel) error {
+	payload, err := json.Marshal(TodoEvent{Type: eventType, Todo: todo, At: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	// delivery to this replica happens through the channel too, keeping one order everywhere
+	err = publishScript.Run(rb.client, []string{rb.seqKey}, rb.channel, string(payload)).Err()
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisBroker Publish ", err)
+	}
+	return err
+}
+
+func (rb *RedisBroker) Subscribe(after uint64) (*Subscription, error) {
+	return rb.local.Subscribe(after)
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func NewRedisCache(client *redis.Client, prefix string, ttl time.Duration) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix, ttl: ttl}
+}
+
+func (rc *RedisCache) Set(key string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Set ", err)
+		return err
+	}
+
+	err = rc.client.Set(rc.prefix+key, jsonData, rc.ttl).Err()
+
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Set ", key)
+
+	return err
+}
+
+func (rc *RedisCache) Get(key string, res interface{}) error {
+	val, err := rc.client.Get(rc.prefix + key).Bytes()
+	if err == redis.Nil {
+		return ErrCacheMiss
+	}
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Get ", err)
+		return err
+	}
+	err = json.Unmarshal(val, res)
+
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Get ", key)
+	return err
+}
+
+func (rc *RedisCache) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, 0, len(keys))
+	for _, k := range keys {
+		prefixed = append(prefixed, rc.prefix+k)
+	}
+
+	err := rc.client.Del(prefixed...).Err()
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Delete ", err)
+		return err
+	}
+
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Delete ", keys)
+	return nil
+}
+
+// Version reads the namespace counter, a missing counter is version 0.
+// Counters have no TTL, they must outlive every entry written under them.
+func (rc *RedisCache) Version(namespace string) (int64, error) {
+	v, err := rc.client.Get(rc.prefix + "ns-" + namespace).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Version ", err)
+		return 0, err
+	}
+	return v, nil
+}
+
+func (rc *RedisCache) Bump(namespace string) (int64, error) {
+	v, err := rc.client.Incr(rc.prefix + "ns-" + namespace).Result()
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Bump ", err)
+		return 0, err
+	}
+
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " RedisCache Bump ", namespace, " -> ", v)
+	return v, nil
+}
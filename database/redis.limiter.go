@@ -0,0 +1,83 @@
+package database
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// takeScript refills and takes from a token bucket stored as a hash of tokens and last refill
+// time in milliseconds. It returns 1 and 0 when a token was taken, or 0 and the wait in ms.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+local last = tonumber(redis.call('HGET', KEYS[1], 'last'))
+if tokens == nil or last == nil then
+	tokens = burst
+	last = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local taken = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {taken, wait}
+`)
+
+// consumeScript adds to a counter that expires at ARGV[3] (unix ms) unless it would pass ARGV[2],
+// a negative ARGV[1] gives back but never takes the counter below zero.
+var consumeScript = redis.NewScript(`
+local n = redis.call('INCRBY', KEYS[1], ARGV[1])
+if n == tonumber(ARGV[1]) then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[3])
+end
+if n < 0 then
+	redis.call('INCRBY', KEYS[1], -n)
+	return 1
+end
+if n > tonumber(ARGV[2]) then
+	redis.call('DECRBY', KEYS[1], ARGV[1])
+	return 0
+end
+return 1
+`)
+
+// RedisRateStore keeps buckets and counters in redis so every replica enforces the same limits.
+type RedisRateStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisRateStore(client *redis.Client, prefix string) *RedisRateStore {
+	return &RedisRateStore{client: client, prefix: prefix + "rate-"}
+}
+
+func (rs *RedisRateStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	res, err := takeScript.Run(rs.client, []string{rs.prefix + key}, rate, burst, time.Now().UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values, _ := res.([]interface{})
+	if len(values) != 2 {
+		return false, 0, redis.Nil
+	}
+	taken, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+	return taken == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+func (rs *RedisRateStore) Consume(key string, n int, max int, reset time.Time) (bool, error) {
+	ok, err := consumeScript.Run(rs.client, []string{rs.prefix + key}, n, max, reset.UnixNano()/int64(time.Millisecond)).Int64()
+	if err != nil {
+		return false, err
+	}
+	return ok == 1, nil
+}
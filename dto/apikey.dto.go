@@ -0,0 +1,133 @@
+package dto
+
+import (
+	"context"
+	"sort"
+	"time"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
+
+	"gorm.io/gorm"
+)
+
+type ApiKeyDTO struct {
+	Db *database.Database
+}
+
+// scoped starts every query of the workspace bound to ctx.
+func (kd *ApiKeyDTO) scoped(ctx context.Context) *gorm.DB {
+	return kd.Db.Postgres.WithContext(ctx).Where("tenant_id = ?", tenant.From(ctx))
+}
+
+func (kd *ApiKeyDTO) Create(ctx context.Context, key model.ApiKeyModel) (model.ApiKeyModel, error) {
+	key.TenantId = tenant.From(ctx)
+	if err := kd.Db.Postgres.WithContext(ctx).Create(&key).Error; err != nil {
+		return model.ApiKeyModel{}, err
+	}
+	return key, nil
+}
+
+func (kd *ApiKeyDTO) List(ctx context.Context) ([]model.ApiKeyModel, error) {
+	var keys []model.ApiKeyModel
+	if err := kd.scoped(ctx).Order("created_at, id").Find(&keys).Error; err != nil {
+		return []model.ApiKeyModel{}, err
+	}
+	return keys, nil
+}
+
+func (kd *ApiKeyDTO) Revoke(ctx context.Context, id string, at time.Time) (model.ApiKeyModel, error) {
+	var key model.ApiKeyModel
+	err := kd.Db.Postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ?", tenant.From(ctx)).First(&key, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if key.RevokedAt != nil && !key.RevokedAt.After(at) {
+			return nil
+		}
+		key.RevokedAt = &at
+		return tx.Model(&model.ApiKeyModel{}).Where("id = ?", id).Update("revoked_at", at).Error
+	})
+	if err != nil {
+		return model.ApiKeyModel{}, err
+	}
+	return key, nil
+}
+
+func (kd *ApiKeyDTO) Lookup(ctx context.Context, id string) (model.ApiKeyModel, error) {
+	var key model.ApiKeyModel
+	if err := kd.Db.Postgres.WithContext(ctx).First(&key, "id = ?", id).Error; err != nil {
+		return model.ApiKeyModel{}, err
+	}
+	return key, nil
+}
+
+func (kd *ApiKeyDTO) Touch(ctx context.Context, id string, at time.Time) error {
+	return kd.Db.Postgres.WithContext(ctx).Model(&model.ApiKeyModel{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
+
+// ApiKeyMemoryDTO keeps API keys in the key table of database.MemoryStore.
+type ApiKeyMemoryDTO struct {
+	Db *database.Database
+}
+
+func (kd *ApiKeyMemoryDTO) Create(ctx context.Context, key model.ApiKeyModel) (model.ApiKeyModel, error) {
+	key.TenantId = tenant.From(ctx)
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	if err := kd.Db.Memory.InsertKey(key); err != nil {
+		return model.ApiKeyModel{}, err
+	}
+	return key, nil
+}
+
+func (kd *ApiKeyMemoryDTO) List(ctx context.Context) ([]model.ApiKeyModel, error) {
+	keys := []model.ApiKeyModel{}
+	tenantId := tenant.From(ctx)
+	for _, key := range kd.Db.Memory.Keys() {
+		if key.TenantId == tenantId {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].Id < keys[j].Id
+	})
+	return keys, nil
+}
+
+func (kd *ApiKeyMemoryDTO) Revoke(ctx context.Context, id string, at time.Time) (model.ApiKeyModel, error) {
+	tenantId := tenant.From(ctx)
+	return kd.Db.Memory.ModifyKey(id, func(key *model.ApiKeyModel) error {
+		if key.TenantId != tenantId {
+			return gorm.ErrRecordNotFound
+		}
+		if key.RevokedAt == nil || key.RevokedAt.After(at) {
+			key.RevokedAt = &at
+		}
+		return nil
+	})
+}
+
+func (kd *ApiKeyMemoryDTO) Lookup(ctx context.Context, id string) (model.ApiKeyModel, error) {
+	return kd.Db.Memory.GetKey(id)
+}
+
+func (kd *ApiKeyMemoryDTO) Touch(ctx context.Context, id string, at time.Time) error {
+	_, err := kd.Db.Memory.ModifyKey(id, func(key *model.ApiKeyModel) error {
+		key.LastUsedAt = &at
+		return nil
+	})
+	return err
+}
+
+func NewApiKeyDTO(db *database.Database) _interface.ApiKeyInterface {
+	if db.Memory != nil {
+		return &ApiKeyMemoryDTO{Db: db}
+	}
+	return &ApiKeyDTO{Db: db}
+}
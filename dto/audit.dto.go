@@ -0,0 +1,156 @@
+package dto
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+	"todo_pikpo/auth"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/request"
+	"todo_pikpo/tenant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// auditPurged is the action of todos Purge removes for good, the other actions are the broker's event types.
+const auditPurged = "purged"
+
+// actorOf names who is behind ctx: the subject of the token, API key or client certificate,
+// "key" for holders of a static workspace key and "system" for work outside any request.
+func actorOf(ctx context.Context) string {
+	claims, ok := auth.ClaimsFrom(ctx)
+	switch {
+	case !ok:
+		return "system"
+	case claims.Subject != "":
+		return claims.Subject
+	}
+	return "key"
+}
+
+func auditJson(v interface{}) string {
+	jd, _ := json.Marshal(v)
+	return string(jd)
+}
+
+// newAuditEvent records a change of a todo made by the caller of ctx, before is nil for created
+// todos and after for purged ones.
+func newAuditEvent(ctx context.Context, action string, before *model.TodoModel, after *model.TodoModel) model.AuditEventModel {
+	info := request.From(ctx)
+	event := model.AuditEventModel{
+		Id:        uuid.New().String(),
+		Action:    action,
+		Actor:     actorOf(ctx),
+		Method:    info.Method,
+		RequestId: info.Id,
+		Changes:   auditJson(model.TodoChanges(before, after)),
+		CreatedAt: time.Now(),
+	}
+	// the todo rather than ctx names the workspace, Purge works across all of them
+	if before != nil {
+		event.TodoId, event.TenantId, event.Before = before.Id, before.TenantId, auditJson(before)
+	}
+	if after != nil {
+		event.TodoId, event.TenantId, event.After = after.Id, after.TenantId, auditJson(after)
+	}
+	return event
+}
+
+// audit appends the event of a change to the transaction tx that made it.
+func audit(ctx context.Context, tx *gorm.DB, action string, before *model.TodoModel, after *model.TodoModel) error {
+	event := newAuditEvent(ctx, action, before, after)
+	return tx.Create(&event).Error
+}
+
+// applyAuditFilter translates filter into GORM conditions.
+func applyAuditFilter(db *gorm.DB, filter _interface.AuditFilter) *gorm.DB {
+	if filter.TodoId != "" {
+		db = db.Where("todo_id = ?", filter.TodoId)
+	}
+	if filter.Actor != "" {
+		db = db.Where("actor = ?", filter.Actor)
+	}
+	if !filter.From.IsZero() {
+		db = db.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		db = db.Where("created_at <= ?", filter.To)
+	}
+	return db
+}
+
+// MatchAuditEvent reports whether event passes filter, with the rules applyAuditFilter gives the SQL backends.
+func MatchAuditEvent(filter _interface.AuditFilter, event model.AuditEventModel) bool {
+	return (filter.TodoId == "" || event.TodoId == filter.TodoId) &&
+		(filter.Actor == "" || event.Actor == filter.Actor) &&
+		(filter.From.IsZero() || !event.CreatedAt.Before(filter.From)) &&
+		(filter.To.IsZero() || !event.CreatedAt.After(filter.To))
+}
+
+// newerAudit orders events newest first, the id breaks ties.
+func newerAudit(a model.AuditEventModel, b model.AuditEventModel) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.Id > b.Id
+}
+
+type AuditDTO struct {
+	Db *database.Database
+}
+
+func (ad *AuditDTO) List(ctx context.Context, filter _interface.AuditFilter, after _interface.Cursor, pageSize uint) ([]model.AuditEventModel, error) {
+	var events []model.AuditEventModel
+
+	query := applyAuditFilter(ad.Db.Postgres.WithContext(ctx).Where("tenant_id = ?", tenant.From(ctx)), filter)
+	if after.Id != "" {
+		query = query.Where("(created_at < ? OR (created_at = ? AND id < ?))", after.CreatedAt, after.CreatedAt, after.Id)
+	}
+	err := query.Order("created_at desc, id desc").Limit(int(pageSize)).Find(&events).Error
+	if err != nil {
+		return []model.AuditEventModel{}, err
+	}
+	return events, nil
+}
+
+// AuditMemoryDTO implements AuditInterface on top of database.MemoryStore.
+type AuditMemoryDTO struct {
+	Db *database.Database
+}
+
+func (ad *AuditMemoryDTO) List(ctx context.Context, filter _interface.AuditFilter, after _interface.Cursor, pageSize uint) ([]model.AuditEventModel, error) {
+	tenantId := tenant.From(ctx)
+	last := model.AuditEventModel{CreatedAt: after.CreatedAt, Id: after.Id}
+
+	var matching []model.AuditEventModel
+	for _, event := range ad.Db.Memory.AuditEvents() {
+		if event.TenantId != tenantId || !MatchAuditEvent(filter, event) {
+			continue
+		}
+		if after.Id != "" && !newerAudit(last, event) {
+			continue
+		}
+		matching = append(matching, event)
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return newerAudit(matching[i], matching[j])
+	})
+
+	events := []model.AuditEventModel{}
+	for i := 0; i < len(matching) && len(events) < int(pageSize); i++ {
+		events = append(events, matching[i])
+	}
+	return events, nil
+}
+
+// NewAuditDTO picks the AuditInterface implementation matching the database driver.
+func NewAuditDTO(db *database.Database) _interface.AuditInterface {
+	if db.Memory != nil {
+		return &AuditMemoryDTO{Db: db}
+	}
+	return &AuditDTO{Db: db}
+}
@@ -1,38 +1,48 @@
 package dto
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
+	"todo_pikpo/auth"
 	"todo_pikpo/config"
 	"todo_pikpo/database"
 	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/request"
+	"todo_pikpo/tenant"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
 )
 
+// ctx is bound to no workspace, everything the tests store lands in tenant.Default.
+var ctx = context.Background()
+
 type DtoTestSuite struct {
 	suite.Suite
-	dto TodoDTO
+	conf config.ConfigApp
+	db   *database.Database
+	dto  _interface.DtoInterface[model.TodoModel]
 }
 
 func (s *DtoTestSuite) SetupSuite() {
-	c, err := config.NewAppConfig("../.env.test")
-	if err != nil {
-		s.T().Error("Failed to create config:", err)
-		return
-	}
-
-	db, err := database.NewDatabase(c)
+	db, err := database.NewDatabase(s.conf)
 	if err != nil {
 		s.T().Error("Failed to create database:", err)
 		return
 	}
-	s.dto.Db = &db
+	s.db = &db
+	s.dto = NewTodoDTO(&db)
 }
 
 func (s *DtoTestSuite) SetupTest() {
-	s.dto.Db.Migrate()
+	s.db.Migrate()
 }
 
 func (s *DtoTestSuite) TearDownSuite() {
@@ -40,15 +50,31 @@ func (s *DtoTestSuite) TearDownSuite() {
 }
 
 func (s *DtoTestSuite) TearDownTest() {
-	s.dto.Db.Flush()
+	s.db.Flush()
 }
 
 func TestSuite(t *testing.T) {
-	suite.Run(t, new(DtoTestSuite))
+	c, err := config.NewAppConfig("../.env.test")
+	if err != nil {
+		t.Error("Failed to create config:", err)
+		return
+	}
+
+	// postgres only joins the run when .env.test points to a server
+	drivers := []string{database.DriverMemory, database.DriverSqlite}
+	if c.DbHost != "" {
+		drivers = append(drivers, database.DriverPostgres)
+	}
+	for _, driver := range drivers {
+		c.DbDriver = driver
+		t.Run(driver, func(t *testing.T) {
+			suite.Run(t, &DtoTestSuite{conf: c})
+		})
+	}
 }
 
 func (s *DtoTestSuite) TestAdd() {
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test",
@@ -60,8 +86,7 @@ func (s *DtoTestSuite) TestAdd() {
 		UpdatedAt:   time.Now(),
 	})
 
-	var res model.TodoModel
-	err := s.dto.Db.Postgres.Where("id = ?", "1").First(&res).Error
+	res, err := s.dto.GetSingle(ctx, "1")
 
 	a := s.Suite.Assert()
 
@@ -69,11 +94,11 @@ func (s *DtoTestSuite) TestAdd() {
 	a.Equal(res.Id, "1")
 	a.Equal(res.Author, "-")
 
-	err = s.dto.Db.Postgres.Where("id = ?", "10").First(&res).Error
+	_, err = s.dto.GetSingle(ctx, "10")
 	a.NotEqual(err, nil)
 
 	// Test for duplicate ID
-	_, err = s.dto.Create(model.TodoModel{
+	_, err = s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test",
@@ -88,7 +113,7 @@ func (s *DtoTestSuite) TestAdd() {
 }
 
 func (s *DtoTestSuite) TestGetMany() {
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test",
@@ -100,7 +125,7 @@ func (s *DtoTestSuite) TestGetMany() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -112,13 +137,13 @@ func (s *DtoTestSuite) TestGetMany() {
 		UpdatedAt:   time.Now(),
 	})
 
-	data, err := s.dto.GetMany(map[string]interface{}{}, 0, 10)
+	data, err := s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
 	a := s.Suite.Assert()
 
 	a.Equal(err, nil)
 	a.Equal(len(data), 2)
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "3",
 		Author:      "James",
 		Title:       "test",
@@ -130,14 +155,14 @@ func (s *DtoTestSuite) TestGetMany() {
 		UpdatedAt:   time.Now(),
 	})
 
-	data, err = s.dto.GetMany(map[string]interface{}{}, 0, 10)
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 3)
 }
 
 func (s *DtoTestSuite) TestGetManyPagination() {
 	a := s.Suite.Assert()
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test",
@@ -149,7 +174,7 @@ func (s *DtoTestSuite) TestGetManyPagination() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -161,7 +186,7 @@ func (s *DtoTestSuite) TestGetManyPagination() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "3",
 		Author:      "James",
 		Title:       "test",
@@ -174,25 +199,25 @@ func (s *DtoTestSuite) TestGetManyPagination() {
 	})
 
 	// Test for pagination
-	data, err := s.dto.GetMany(map[string]interface{}{}, 0, 1)
+	data, err := s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 1)
 	fmt.Println(len(data))
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 	a.Equal(data[0].Id, "1")
 
-	data, err = s.dto.GetMany(map[string]interface{}{}, 1, 1)
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 1, 1)
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 	a.Equal(data[0].Id, "2")
 
-	data, err = s.dto.GetMany(map[string]interface{}{}, 3, 2)
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 3, 2)
 	a.Equal(err, nil)
 	a.Equal(len(data), 0)
 }
 
 func (s *DtoTestSuite) TestGetManyQuery() {
 	a := s.Suite.Assert()
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -204,7 +229,7 @@ func (s *DtoTestSuite) TestGetManyQuery() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -216,7 +241,7 @@ func (s *DtoTestSuite) TestGetManyQuery() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "3",
 		Author:      "James",
 		Title:       "test",
@@ -229,22 +254,22 @@ func (s *DtoTestSuite) TestGetManyQuery() {
 	})
 
 	// Test for filter
-	data, err := s.dto.GetMany(map[string]interface{}{
-		"title": "test",
+	data, err := s.dto.GetMany(ctx, _interface.TodoQueryFilter{
+		Title: "test",
 	}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 2)
 	a.Equal(data[0].Id, "2")
 
-	data, err = s.dto.GetMany(map[string]interface{}{
-		"author": "James",
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{
+		Authors: []string{"James"},
 	}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 	a.Equal(data[0].Id, "3")
 
-	data, err = s.dto.GetMany(map[string]interface{}{
-		"title": "will not found there",
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{
+		Title: "will not found there",
 	}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 0)
@@ -252,7 +277,7 @@ func (s *DtoTestSuite) TestGetManyQuery() {
 
 func (s *DtoTestSuite) TestGetOne() {
 	a := s.Suite.Assert()
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -264,18 +289,18 @@ func (s *DtoTestSuite) TestGetOne() {
 		UpdatedAt:   time.Now(),
 	})
 
-	data, err := s.dto.GetSingle("1")
+	data, err := s.dto.GetSingle(ctx, "1")
 	a.Equal(err, nil)
 	a.Equal(data.Id, "1")
 	a.Equal(data.Title, "test for make sure")
 
-	data, err = s.dto.GetSingle("2")
+	data, err = s.dto.GetSingle(ctx, "2")
 	a.NotEqual(err, nil)
 }
 
 func (s *DtoTestSuite) TestUpdate() {
 	a := s.Suite.Assert()
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -285,7 +310,7 @@ func (s *DtoTestSuite) TestUpdate() {
 		EndDate:     time.Now(),
 	})
 
-	data, err := s.dto.Update("1", model.TodoModel{
+	data, err := s.dto.Update(ctx, "1", model.TodoModel{
 		Id:          "1",
 		Author:      "James",
 		Title:       "changed",
@@ -300,13 +325,13 @@ func (s *DtoTestSuite) TestUpdate() {
 	a.Equal(data.Description, "this is changed too")
 	a.Equal(data.Author, "James")
 
-	_, err = s.dto.GetSingle("2")
+	_, err = s.dto.GetSingle(ctx, "2")
 	a.NotEqual(err, nil)
 }
 
 func (s *DtoTestSuite) TestDelete() {
 	a := s.Suite.Assert()
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "1",
 		Author:      "-",
 		Title:       "test for make sure",
@@ -318,7 +343,7 @@ func (s *DtoTestSuite) TestDelete() {
 		UpdatedAt:   time.Now(),
 	})
 
-	s.dto.Create(model.TodoModel{
+	s.dto.Create(ctx, model.TodoModel{
 		Id:          "2",
 		Author:      "-",
 		Title:       "test",
@@ -330,22 +355,663 @@ func (s *DtoTestSuite) TestDelete() {
 		UpdatedAt:   time.Now(),
 	})
 
-	var data []model.TodoModel
-	err := s.dto.Db.Postgres.Find(&data).Error
+	data, err := s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 2)
 
-	_, err = s.dto.Delete("1")
+	_, err = s.dto.Delete(ctx, "1", 0)
 	a.Equal(err, nil)
 
-	err = s.dto.Db.Postgres.Find(&data).Error
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 1)
 
-	_, err = s.dto.Delete("2")
+	_, err = s.dto.Delete(ctx, "2", 0)
 	a.Equal(err, nil)
 
-	err = s.dto.Db.Postgres.Find(&data).Error
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
 	a.Equal(err, nil)
 	a.Equal(len(data), 0)
 }
+
+func (s *DtoTestSuite) TestVersion() {
+	a := s.Suite.Assert()
+	created, err := s.dto.Create(ctx, model.TodoModel{
+		Id:        "1",
+		Author:    "-",
+		Title:     "test",
+		StartDate: time.Now(),
+		EndDate:   time.Now(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	a.Equal(err, nil)
+	a.Equal(created.Version, uint64(1))
+
+	data, err := s.dto.Update(ctx, "1", model.TodoModel{Title: "first writer", Version: 1})
+	a.Equal(err, nil)
+	a.Equal(data.Version, uint64(2))
+
+	// a second writer still holding version 1 loses
+	_, err = s.dto.Update(ctx, "1", model.TodoModel{Title: "second writer", Version: 1})
+	a.Equal(err, database.ErrVersionConflict)
+	res, err := s.dto.GetSingle(ctx, "1")
+	a.Equal(err, nil)
+	a.Equal(res.Title, "first writer")
+	a.Equal(res.Version, uint64(2))
+
+	_, err = s.dto.Delete(ctx, "1", 1)
+	a.Equal(err, database.ErrVersionConflict)
+	_, err = s.dto.Delete(ctx, "1", 2)
+	a.Equal(err, nil)
+	_, err = s.dto.GetSingle(ctx, "1")
+	a.NotEqual(err, nil)
+}
+
+func (s *DtoTestSuite) TestTrash() {
+	a := s.Suite.Assert()
+	for _, id := range []string{"1", "2"} {
+		_, err := s.dto.Create(ctx, model.TodoModel{
+			Id:        id,
+			Author:    "-",
+			Title:     "test for trash",
+			StartDate: time.Now(),
+			EndDate:   time.Now(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		a.Equal(err, nil)
+	}
+
+	deleted, err := s.dto.Delete(ctx, "1", 0)
+	a.Equal(err, nil)
+	a.Equal(deleted.Id, "1")
+	a.Equal(deleted.DeletedAt.Valid, true)
+
+	_, err = s.dto.GetSingle(ctx, "1")
+	a.NotEqual(err, nil)
+	_, err = s.dto.Update(ctx, "1", model.TodoModel{Title: "edit in trash"})
+	a.NotEqual(err, nil)
+	_, err = s.dto.Delete(ctx, "1", 0)
+	a.NotEqual(err, nil)
+	data, err := s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 1)
+
+	trash, err := s.dto.GetDeleted(ctx, "", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 1)
+	a.Equal(trash[0].Id, "1")
+	trash, err = s.dto.GetDeleted(ctx, trash[0].Author, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 1)
+	trash, err = s.dto.GetDeleted(ctx, "someone else", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 0)
+	deleted, err = s.dto.GetSingleDeleted(ctx, "1")
+	a.Equal(err, nil)
+	a.Equal(deleted.DeletedAt.Valid, true)
+	_, err = s.dto.GetSingleDeleted(ctx, "2")
+	a.Equal(err, gorm.ErrRecordNotFound)
+
+	restored, err := s.dto.Restore(ctx, "1")
+	a.Equal(err, nil)
+	a.Equal(restored.DeletedAt.Valid, false)
+	_, err = s.dto.Restore(ctx, "1")
+	a.NotEqual(err, nil)
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 2)
+
+	_, err = s.dto.Delete(ctx, "2", 0)
+	a.Equal(err, nil)
+
+	// still inside the retention period
+	purged, err := s.dto.Purge(ctx, time.Now().Add(-time.Hour), 10)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(0))
+
+	// a batch takes what has been in the trash longest
+	_, err = s.dto.Delete(ctx, "1", 0)
+	a.Equal(err, nil)
+	purged, err = s.dto.Purge(ctx, time.Now().Add(time.Second), 1)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(1))
+	_, err = s.dto.GetSingleDeleted(ctx, "2")
+	a.Equal(err, gorm.ErrRecordNotFound)
+	_, err = s.dto.GetSingleDeleted(ctx, "1")
+	a.Equal(err, nil)
+
+	purged, err = s.dto.Purge(ctx, time.Now().Add(time.Second), 10)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(1))
+	trash, err = s.dto.GetDeleted(ctx, "", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(trash), 0)
+	_, err = s.dto.Restore(ctx, "2")
+	a.NotEqual(err, nil)
+}
+
+func (s *DtoTestSuite) TestGetAfter() {
+	a := s.Suite.Assert()
+	created := time.Now()
+	// ids are out of insertion order on purpose, "b" and "c" share created_at
+	for i, id := range []string{"c", "a", "b"} {
+		at := created
+		if id == "a" {
+			at = created.Add(-time.Minute)
+		}
+		_, err := s.dto.Create(ctx, model.TodoModel{
+			Id:        id,
+			Author:    "-",
+			Title:     fmt.Sprintf("test %d", i),
+			StartDate: time.Now(),
+			EndDate:   time.Now(),
+			CreatedAt: at,
+			UpdatedAt: at,
+		})
+		a.Equal(err, nil)
+	}
+
+	data, err := s.dto.GetAfter(ctx, _interface.TodoQueryFilter{}, _interface.Cursor{}, 2)
+	a.Equal(err, nil)
+	a.Equal(len(data), 2)
+	a.Equal(data[0].Id, "a")
+	a.Equal(data[1].Id, "b")
+
+	data, err = s.dto.GetAfter(ctx, _interface.TodoQueryFilter{}, _interface.Cursor{CreatedAt: data[1].CreatedAt, Id: data[1].Id}, 2)
+	a.Equal(err, nil)
+	a.Equal(len(data), 1)
+	a.Equal(data[0].Id, "c")
+
+	total, err := s.dto.Count(ctx, _interface.TodoQueryFilter{Title: "test 1"})
+	a.Equal(err, nil)
+	a.Equal(total, int64(1))
+}
+
+func (s *DtoTestSuite) TestOrderBy() {
+	a := s.Suite.Assert()
+	now := time.Now()
+	// "b" and "d" share end_date, so title decides between them
+	rows := []model.TodoModel{
+		{Id: "a", Title: "alpha", EndDate: now.Add(3 * time.Hour)},
+		{Id: "b", Title: "delta", EndDate: now.Add(1 * time.Hour)},
+		{Id: "c", Title: "charlie", EndDate: now.Add(2 * time.Hour)},
+		{Id: "d", Title: "bravo", EndDate: now.Add(1 * time.Hour)},
+	}
+	for _, row := range rows {
+		row.Author, row.StartDate = "-", now
+		row.CreatedAt, row.UpdatedAt = now, now
+		_, err := s.dto.Create(ctx, row)
+		a.Equal(err, nil)
+	}
+
+	ids := func(data []model.TodoModel) string {
+		var out string
+		for _, d := range data {
+			out += d.Id
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		orderBy []_interface.SortKey
+		want    string
+	}{
+		{nil, "abcd"},
+		{[]_interface.SortKey{{Column: "title", Desc: true}}, "bcda"},
+		{[]_interface.SortKey{{Column: "end_date"}, {Column: "title"}}, "dbca"},
+		{[]_interface.SortKey{{Column: "end_date", Desc: true}, {Column: "title", Desc: true}}, "acbd"},
+	} {
+		filter := _interface.TodoQueryFilter{OrderBy: tc.orderBy}
+		data, err := s.dto.GetMany(ctx, filter, 0, 10)
+		a.Equal(err, nil)
+		a.Equal(ids(data), tc.want, tc.orderBy)
+
+		// walking the keyset one row at a time visits the same order
+		var walked []model.TodoModel
+		var after _interface.Cursor
+		for {
+			page, err := s.dto.GetAfter(ctx, filter, after, 1)
+			a.Equal(err, nil)
+			if len(page) == 0 {
+				break
+			}
+			walked = append(walked, page[0])
+			after = _interface.Cursor{Id: page[0].Id, Title: page[0].Title, EndDate: page[0].EndDate, CreatedAt: page[0].CreatedAt}
+			if len(walked) > len(rows) {
+				break
+			}
+		}
+		a.Equal(ids(walked), tc.want, tc.orderBy)
+	}
+}
+
+func (s *DtoTestSuite) TestTenant() {
+	a := s.Suite.Assert()
+	acme := tenant.With(ctx, "acme")
+	globex := tenant.With(ctx, "globex")
+
+	created, err := s.dto.Create(acme, model.TodoModel{
+		Id:          "1",
+		Author:      "james",
+		Title:       "acme plan",
+		Description: "quarterly plan",
+		StartDate:   time.Now(),
+		EndDate:     time.Now(),
+	})
+	a.Equal(err, nil)
+	a.Equal(created.TenantId, "acme")
+
+	_, err = s.dto.GetSingle(globex, "1")
+	a.Equal(err, gorm.ErrRecordNotFound)
+	_, err = s.dto.Update(globex, "1", model.TodoModel{Title: "taken over"})
+	a.Equal(err, gorm.ErrRecordNotFound)
+	_, err = s.dto.Delete(globex, "1", 0)
+	a.Equal(err, gorm.ErrRecordNotFound)
+
+	data, err := s.dto.GetMany(globex, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 0)
+	data, err = s.dto.GetAfter(globex, _interface.TodoQueryFilter{}, _interface.Cursor{}, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 0)
+	total, err := s.dto.Count(globex, _interface.TodoQueryFilter{})
+	a.Equal(err, nil)
+	a.Equal(total, int64(0))
+	_, total, err = s.dto.Search(globex, "plan", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(total, int64(0))
+
+	// the trash is per workspace too
+	_, err = s.dto.Delete(acme, "1", 0)
+	a.Equal(err, nil)
+	data, err = s.dto.GetDeleted(globex, "", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 0)
+	_, err = s.dto.Restore(globex, "1")
+	a.Equal(err, gorm.ErrRecordNotFound)
+	_, err = s.dto.Restore(acme, "1")
+	a.Equal(err, nil)
+
+	data, err = s.dto.GetMany(acme, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 1)
+	data, err = s.dto.GetMany(ctx, _interface.TodoQueryFilter{}, 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(data), 0)
+}
+
+func (s *DtoTestSuite) TestApiKeys() {
+	a := s.Suite.Assert()
+	keys := NewApiKeyDTO(s.db)
+	acme := tenant.With(ctx, "acme")
+
+	created, err := keys.Create(acme, model.ApiKeyModel{Id: "k1", Name: "ci", Scopes: "read,write", Salt: "s", Hash: "h", CreatedAt: time.Now()})
+	a.Equal(err, nil)
+	a.Equal(created.TenantId, "acme")
+	_, err = keys.Create(acme, model.ApiKeyModel{Id: "k2", Name: "cd", Scopes: "admin", Salt: "s", Hash: "h", CreatedAt: time.Now().Add(time.Second)})
+	a.Equal(err, nil)
+
+	list, err := keys.List(acme)
+	a.Equal(err, nil)
+	a.Equal(len(list), 2)
+	a.Equal(list[0].Id, "k1")
+	a.Equal(list[0].ScopeList(), []string{"read", "write"})
+	list, err = keys.List(ctx)
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+
+	// lookups span workspaces, revocations do not
+	found, err := keys.Lookup(ctx, "k1")
+	a.Equal(err, nil)
+	a.Equal(found.Hash, "h")
+	_, err = keys.Revoke(ctx, "k1", time.Now())
+	a.Equal(err, gorm.ErrRecordNotFound)
+
+	later := time.Now().Add(time.Hour)
+	revoked, err := keys.Revoke(acme, "k1", later)
+	a.Equal(err, nil)
+	a.Equal(revoked.RevokedAt.Unix(), later.Unix())
+	a.Equal(revoked.ValidAt(time.Now()), true)
+	// revoking again never pushes the time back
+	revoked, err = keys.Revoke(acme, "k1", later.Add(time.Hour))
+	a.Equal(err, nil)
+	a.Equal(revoked.RevokedAt.Unix(), later.Unix())
+	revoked, err = keys.Revoke(acme, "k1", time.Now())
+	a.Equal(err, nil)
+	a.Equal(revoked.ValidAt(time.Now().Add(time.Second)), false)
+
+	used := time.Now()
+	a.Equal(keys.Touch(ctx, "k2", used), nil)
+	found, err = keys.Lookup(ctx, "k2")
+	a.Equal(err, nil)
+	a.Equal(found.LastUsedAt.Unix(), used.Unix())
+}
+
+func (s *DtoTestSuite) TestAudit() {
+	a := s.Suite.Assert()
+	events := NewAuditDTO(s.db)
+	james := request.With(auth.WithClaims(ctx, auth.Claims{Subject: "james"}), request.Info{Id: "req-1", Method: "/todoproto.TodoService/AddTodo"})
+
+	data, err := s.dto.Create(james, model.TodoModel{Id: "a1", Author: "james", Title: "first", StartDate: time.Now(), EndDate: time.Now()})
+	a.Equal(err, nil)
+	_, err = s.dto.Update(ctx, "a1", model.TodoModel{Author: "james", Title: "second", StartDate: data.StartDate, EndDate: data.EndDate})
+	a.Equal(err, nil)
+	_, err = s.dto.Delete(james, "a1", 0)
+	a.Equal(err, nil)
+	_, err = s.dto.Restore(james, "a1")
+	a.Equal(err, nil)
+
+	// a rolled back write leaves no event behind
+	_ = s.dto.Transaction(ctx, func(tx _interface.DtoInterface[model.TodoModel]) error {
+		_, err := tx.Update(ctx, "a1", model.TodoModel{Author: "james", Title: "third", StartDate: data.StartDate, EndDate: data.EndDate})
+		a.Equal(err, nil)
+		return errors.New("roll back")
+	})
+
+	list, err := events.List(ctx, _interface.AuditFilter{TodoId: "a1"}, _interface.Cursor{}, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 4)
+	actions := []string{}
+	for _, event := range list {
+		actions = append(actions, event.Action)
+	}
+	a.Equal(actions, []string{database.EventRestored, database.EventDeleted, database.EventUpdated, database.EventCreated})
+
+	created := list[3]
+	a.Equal(created.Actor, "james")
+	a.Equal(created.RequestId, "req-1")
+	a.Equal(created.Method, "/todoproto.TodoService/AddTodo")
+	a.Equal(created.Before, "")
+	a.Contains(created.After, `"title":"first"`)
+	updated := list[2]
+	a.Equal(updated.Actor, "system")
+	a.Equal(updated.Changes, `[{"field":"title","before":"first","after":"second"}]`)
+	a.Contains(list[1].Changes, `"field":"deletedAt"`)
+
+	// filters and paging newest first
+	list, err = events.List(ctx, _interface.AuditFilter{Actor: "james"}, _interface.Cursor{}, 2)
+	a.Equal(err, nil)
+	a.Equal(len(list), 2)
+	a.Equal(list[0].Action, database.EventRestored)
+	last := list[1]
+	list, err = events.List(ctx, _interface.AuditFilter{Actor: "james"}, _interface.Cursor{CreatedAt: last.CreatedAt, Id: last.Id}, 2)
+	a.Equal(err, nil)
+	a.Equal(len(list), 1)
+	a.Equal(list[0].Action, database.EventCreated)
+	list, err = events.List(ctx, _interface.AuditFilter{From: time.Now().Add(time.Minute)}, _interface.Cursor{}, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+
+	// other workspaces see none of it
+	list, err = events.List(tenant.With(ctx, "acme"), _interface.AuditFilter{}, _interface.Cursor{}, 10)
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+
+	// purging is recorded too
+	_, err = s.dto.Delete(ctx, "a1", 0)
+	a.Equal(err, nil)
+	purged, err := s.dto.Purge(ctx, time.Now().Add(time.Second), 10)
+	a.Equal(err, nil)
+	a.Equal(purged, int64(1))
+	list, err = events.List(ctx, _interface.AuditFilter{TodoId: "a1"}, _interface.Cursor{}, 1)
+	a.Equal(err, nil)
+	a.Equal(list[0].Action, "purged")
+	a.Equal(list[0].After, "")
+}
+
+func (s *DtoTestSuite) TestRevisions() {
+	a := s.Suite.Assert()
+	revisions := NewRevisionDTO(s.db)
+	james := auth.WithClaims(ctx, auth.Claims{Subject: "james"})
+
+	data, err := s.dto.Create(james, model.TodoModel{Id: "r1", Author: "james", Title: "first", StartDate: time.Now(), EndDate: time.Now()})
+	a.Equal(err, nil)
+	_, err = s.dto.Update(ctx, "r1", model.TodoModel{Author: "james", Title: "second", StartDate: data.StartDate, EndDate: data.EndDate})
+	a.Equal(err, nil)
+
+	// a rolled back write leaves no revision behind
+	_ = s.dto.Transaction(ctx, func(tx _interface.DtoInterface[model.TodoModel]) error {
+		_, err := tx.Update(ctx, "r1", model.TodoModel{Author: "james", Title: "third", StartDate: data.StartDate, EndDate: data.EndDate})
+		a.Equal(err, nil)
+		return errors.New("roll back")
+	})
+
+	list, err := revisions.List(ctx, "r1")
+	a.Equal(err, nil)
+	a.Equal(len(list), 2)
+	a.Equal(list[0].Version, uint64(1))
+	a.Equal(list[0].Title, "first")
+	a.Equal(list[0].Actor, "james")
+	a.Equal(list[1].Version, uint64(2))
+	a.Equal(list[1].Title, "second")
+	a.Equal(list[1].Actor, "system")
+
+	revision, err := revisions.Get(ctx, "r1", 1)
+	a.Equal(err, nil)
+	a.Equal(revision.Todo().Title, "first")
+	a.Equal(revision.Todo().Version, uint64(1))
+	_, err = revisions.Get(ctx, "r1", 3)
+	a.True(errors.Is(err, gorm.ErrRecordNotFound))
+
+	// other workspaces see none of it
+	list, err = revisions.List(tenant.With(ctx, "acme"), "r1")
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+
+	// deleting keeps the history, purging drops it
+	_, err = s.dto.Delete(ctx, "r1", 0)
+	a.Equal(err, nil)
+	list, _ = revisions.List(ctx, "r1")
+	a.Equal(len(list), 2)
+	_, err = s.dto.Purge(ctx, time.Now().Add(time.Second), 10)
+	a.Equal(err, nil)
+	list, err = revisions.List(ctx, "r1")
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+}
+
+func (s *DtoTestSuite) TestWebhooks() {
+	a := s.Suite.Assert()
+	hooks := NewWebhookDTO(s.db)
+	now := time.Now()
+
+	_, err := hooks.Create(ctx, model.WebhookModel{Id: "w1", Url: "http://localhost/hook", Secret: "secret", CreatedAt: now})
+	a.Equal(err, nil)
+	data, err := s.dto.Create(ctx, model.TodoModel{Id: "o1", Author: "james", Title: "first", StartDate: now, EndDate: now})
+	a.Equal(err, nil)
+	_, err = s.dto.Update(ctx, "o1", model.TodoModel{Author: "james", Title: "first", IsDone: true, StartDate: data.StartDate, EndDate: data.EndDate})
+	a.Equal(err, nil)
+
+	// a rolled back write leaves nothing in the outbox
+	_ = s.dto.Transaction(ctx, func(tx _interface.DtoInterface[model.TodoModel]) error {
+		_, err := tx.Delete(ctx, "o1", 0)
+		a.Equal(err, nil)
+		return errors.New("roll back")
+	})
+
+	events, err := hooks.Outbox(ctx, 10)
+	a.Equal(err, nil)
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	a.Equal(types, []string{model.WebhookTodoCreated, model.WebhookTodoUpdated, model.WebhookTodoCompleted})
+	var payload model.WebhookPayload
+	a.Equal(json.Unmarshal([]byte(events[2].Payload), &payload), nil)
+	a.Equal(payload.Id, events[2].Id)
+	a.Equal(payload.Todo.IsDone, true)
+	a.Equal(payload.Changes[0].Field, "isDone")
+
+	// an event is handed out once
+	delivery := model.WebhookDeliveryModel{
+		Id:            "d1",
+		TenantId:      events[2].TenantId,
+		WebhookId:     "w1",
+		EventId:       events[2].Id,
+		Type:          events[2].Type,
+		Payload:       events[2].Payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	a.Equal(hooks.Fanout(ctx, events[2], []model.WebhookDeliveryModel{delivery}), nil)
+	twice := delivery
+	twice.Id = "d2"
+	a.Equal(hooks.Fanout(ctx, events[2], []model.WebhookDeliveryModel{twice}), nil)
+	events, _ = hooks.Outbox(ctx, 10)
+	a.Equal(len(events), 2)
+
+	// a due delivery is leased to one dispatcher, only that one stores the outcome
+	due, err := hooks.Due(ctx, now, now.Add(time.Hour), 10)
+	a.Equal(err, nil)
+	if !a.Equal(len(due), 1) {
+		return
+	}
+	a.Equal(due[0].Id, "d1")
+	a.NotEqual(due[0].LeaseId, "")
+	taken, _ := hooks.Due(ctx, now, now.Add(time.Hour), 10)
+	a.Equal(len(taken), 0)
+	delivery = due[0]
+	delivery.Attempts = 1
+	delivery.NextAttemptAt = now.Add(time.Minute)
+	delivery.LastError = "webhook answered 500"
+	stale := delivery
+	stale.LeaseId = "someone else"
+	a.Equal(hooks.SaveDelivery(ctx, stale, false), errLeaseLost)
+	a.Equal(hooks.SaveDelivery(ctx, delivery, false), nil)
+	a.Equal(hooks.SaveDelivery(ctx, delivery, false), errLeaseLost)
+	due, _ = hooks.Due(ctx, now, now.Add(time.Hour), 10)
+	a.Equal(len(due), 0)
+	due, _ = hooks.Due(ctx, now.Add(2*time.Minute), now.Add(time.Hour), 10)
+	if a.Equal(len(due), 1) {
+		a.Equal(due[0].Attempts, 1)
+		a.Equal(due[0].LastError, "webhook answered 500")
+		delivery = due[0]
+	}
+	backlog, err := hooks.Backlog(ctx, "w1")
+	a.Equal(err, nil)
+	a.Equal(backlog, map[string]int64{model.DeliveryPending: 1})
+
+	// other workspaces see none of it
+	list, err := hooks.List(tenant.With(ctx, "acme"))
+	a.Equal(err, nil)
+	a.Equal(len(list), 0)
+	_, err = hooks.Delete(tenant.With(ctx, "acme"), "w1")
+	a.True(errors.Is(err, gorm.ErrRecordNotFound))
+
+	// delivered ones are forgotten, deleting the webhook drops the rest
+	a.Equal(hooks.SaveDelivery(ctx, delivery, true), nil)
+	backlog, _ = hooks.Backlog(ctx, "w1")
+	a.Equal(len(backlog), 0)
+	delivery.Id = "d3"
+	a.Equal(hooks.Fanout(ctx, events[0], []model.WebhookDeliveryModel{delivery}), nil)
+	removed, err := hooks.Delete(ctx, "w1")
+	a.Equal(err, nil)
+	a.Equal(removed.Url, "http://localhost/hook")
+	due, _ = hooks.Due(ctx, now.Add(2*time.Hour), now.Add(3*time.Hour), 10)
+	a.Equal(len(due), 0)
+	_, err = hooks.Get(ctx, "w1")
+	a.True(errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func (s *DtoTestSuite) TestRichFilter() {
+	a := s.Suite.Assert()
+	now := time.Now()
+	rows := []model.TodoModel{
+		{Id: "1", Author: "james", Title: "Buy Groceries", Description: "milk and eggs", StartDate: now.Add(-48 * time.Hour), EndDate: now.Add(-24 * time.Hour)},
+		{Id: "2", Author: "robert", Title: "Write report", Description: "100% done by friday", IsDone: true, StartDate: now.Add(-48 * time.Hour), EndDate: now.Add(-24 * time.Hour)},
+		{Id: "3", Author: "ali", Title: "groceries again", Description: "-", StartDate: now, EndDate: now.Add(48 * time.Hour)},
+	}
+	for _, row := range rows {
+		row.CreatedAt, row.UpdatedAt = now, now
+		_, err := s.dto.Create(ctx, row)
+		a.Equal(err, nil)
+	}
+
+	ids := func(filter _interface.TodoQueryFilter) []string {
+		data, err := s.dto.GetMany(ctx, filter, 0, 10)
+		a.Equal(err, nil)
+		res := []string{}
+		for _, d := range data {
+			res = append(res, d.Id)
+		}
+		return res
+	}
+
+	isDone, isOpen := true, false
+	a.Equal(ids(_interface.TodoQueryFilter{IsDone: &isDone}), []string{"2"})
+	a.Equal(ids(_interface.TodoQueryFilter{IsDone: &isOpen}), []string{"1", "3"})
+	a.Equal(ids(_interface.TodoQueryFilter{Authors: []string{"james", "ali"}}), []string{"1", "3"})
+	a.Equal(ids(_interface.TodoQueryFilter{Overdue: true}), []string{"1"})
+	a.Equal(ids(_interface.TodoQueryFilter{EndFrom: now}), []string{"3"})
+	a.Equal(ids(_interface.TodoQueryFilter{StartTo: now.Add(-time.Hour), EndTo: now}), []string{"1", "2"})
+	a.Equal(ids(_interface.TodoQueryFilter{Search: "GROCERIES"}), []string{"1", "3"})
+	a.Equal(ids(_interface.TodoQueryFilter{Search: "groc", SearchPrefix: true}), []string{"3"})
+	a.Equal(ids(_interface.TodoQueryFilter{Search: "eggs"}), []string{"1"})
+	// LIKE wildcards in the search text match literally
+	a.Equal(ids(_interface.TodoQueryFilter{Search: "100%"}), []string{"2"})
+	a.Equal(ids(_interface.TodoQueryFilter{Search: "%"}), []string{"2"})
+	a.Equal(ids(_interface.TodoQueryFilter{Search: "_"}), []string{})
+
+	total, err := s.dto.Count(ctx, _interface.TodoQueryFilter{Search: "groceries", IsDone: &isOpen})
+	a.Equal(err, nil)
+	a.Equal(total, int64(2))
+}
+
+func (s *DtoTestSuite) TestSearch() {
+	a := s.Suite.Assert()
+	now := time.Now()
+	rows := []model.TodoModel{
+		{Id: "1", Author: "-", Title: "plan release", Description: "the budget review happens before release"},
+		{Id: "2", Author: "-", Title: "budget review", Description: "quarterly budget numbers"},
+		{Id: "3", Author: "-", Title: "unrelated", Description: "nothing to see"},
+	}
+	for i, row := range rows {
+		row.CreatedAt = now.Add(time.Duration(i) * time.Second)
+		row.UpdatedAt = row.CreatedAt
+		row.StartDate, row.EndDate = now, now
+		_, err := s.dto.Create(ctx, row)
+		a.Equal(err, nil)
+	}
+
+	hits, total, err := s.dto.Search(ctx, "budget review", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(total, int64(2))
+	a.Equal(len(hits), 2)
+	// title matches weigh more than description matches
+	a.Equal(hits[0].Item.Id, "2")
+	a.Equal(hits[0].Rank, float64(5))
+	a.Equal(hits[1].Rank, float64(2))
+	a.Contains(hits[0].Snippet, "<b>")
+
+	hits, total, err = s.dto.Search(ctx, "budget review", 1, 1)
+	a.Equal(err, nil)
+	a.Equal(total, int64(2))
+	a.Equal(len(hits), 1)
+	a.Equal(hits[0].Item.Id, "1")
+
+	_, err = s.dto.Delete(ctx, "2", 0)
+	a.Equal(err, nil)
+	hits, _, err = s.dto.Search(ctx, "budget", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(len(hits), 1)
+
+	hits, total, err = s.dto.Search(ctx, "missing", 0, 10)
+	a.Equal(err, nil)
+	a.Equal(total, int64(0))
+	a.Equal(len(hits), 0)
+}
+
+func TestSnippet(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(snippet("Budget review", []string{"budget"}), "<b>Budget</b> review")
+	a.Equal(snippet("nothing here", []string{"budget"}), "")
+
+	long := strings.Repeat("x", 100) + " budget " + strings.Repeat("y", 100)
+	res := snippet(long, []string{"budget"})
+	a.True(strings.HasPrefix(res, "..."))
+	a.True(strings.HasSuffix(res, "..."))
+	a.Contains(res, "<b>budget</b>")
+}
@@ -0,0 +1,76 @@
+package dto
+
+import (
+	"context"
+	"time"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
+
+	"gorm.io/gorm"
+)
+
+// revise stores the revision data was just written at, in the transaction tx that wrote it.
+func revise(ctx context.Context, tx *gorm.DB, data model.TodoModel) error {
+	revision := model.RevisionOf(data, actorOf(ctx), time.Now())
+	return tx.Create(&revision).Error
+}
+
+type RevisionDTO struct {
+	Db *database.Database
+}
+
+// scoped starts every query of the workspace bound to ctx.
+func (rd *RevisionDTO) scoped(ctx context.Context) *gorm.DB {
+	return rd.Db.Postgres.WithContext(ctx).Where("tenant_id = ?", tenant.From(ctx))
+}
+
+func (rd *RevisionDTO) List(ctx context.Context, todoId string) ([]model.TodoRevisionModel, error) {
+	var revisions []model.TodoRevisionModel
+	if err := rd.scoped(ctx).Where("todo_id = ?", todoId).Order("version").Find(&revisions).Error; err != nil {
+		return []model.TodoRevisionModel{}, err
+	}
+	return revisions, nil
+}
+
+func (rd *RevisionDTO) Get(ctx context.Context, todoId string, version uint64) (model.TodoRevisionModel, error) {
+	var revision model.TodoRevisionModel
+	if err := rd.scoped(ctx).First(&revision, "todo_id = ? AND version = ?", todoId, version).Error; err != nil {
+		return model.TodoRevisionModel{}, err
+	}
+	return revision, nil
+}
+
+// RevisionMemoryDTO implements RevisionInterface on top of database.MemoryStore.
+type RevisionMemoryDTO struct {
+	Db *database.Database
+}
+
+func (rd *RevisionMemoryDTO) List(ctx context.Context, todoId string) ([]model.TodoRevisionModel, error) {
+	revisions := []model.TodoRevisionModel{}
+	for _, revision := range rd.Db.Memory.Revisions(todoId) {
+		if revision.TenantId == tenant.From(ctx) {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions, nil
+}
+
+func (rd *RevisionMemoryDTO) Get(ctx context.Context, todoId string, version uint64) (model.TodoRevisionModel, error) {
+	revisions, _ := rd.List(ctx, todoId)
+	for _, revision := range revisions {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+	return model.TodoRevisionModel{}, gorm.ErrRecordNotFound
+}
+
+// NewRevisionDTO picks the RevisionInterface implementation matching the database driver.
+func NewRevisionDTO(db *database.Database) _interface.RevisionInterface {
+	if db.Memory != nil {
+		return &RevisionMemoryDTO{Db: db}
+	}
+	return &RevisionDTO{Db: db}
+}
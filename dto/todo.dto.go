@@ -1,12 +1,15 @@
 package dto
 
 import (
+	"context"
 	"time"
 	"todo_pikpo/database"
 	model "todo_pikpo/database/models"
 	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
 
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type TodoDTO struct {
@@ -18,16 +21,36 @@ func (td *TodoDTO) SetDb(db *database.Database) {
 	td.Db = db
 }
 
-func (td *TodoDTO) GetMany(filter map[string]interface{}, page uint, pageSize uint) ([]model.TodoModel, error) {
+// scoped starts every query of the workspace bound to ctx, rows of other workspaces are never visible.
+func (td *TodoDTO) scoped(ctx context.Context) *gorm.DB {
+	return td.Db.Postgres.WithContext(ctx).Where("tenant_id = ?", tenant.From(ctx))
+}
+
+func (td *TodoDTO) GetMany(ctx context.Context, filter _interface.TodoQueryFilter, page uint, pageSize uint) ([]model.TodoModel, error) {
 	var data []model.TodoModel
 
-	var err error
-	if len(filter) >= 1 {
-		err = td.Db.Postgres.Limit(int(pageSize)).Offset(int(page*pageSize)).Find(&data, filter).Error
-	} else {
-		err = td.Db.Postgres.Limit(int(pageSize)).Offset(int(page * pageSize)).Find(&data).Error
+	err := applyFilter(td.scoped(ctx), filter).
+		Order(orderClause(sortKeys(filter.OrderBy))).
+		Limit(int(pageSize)).Offset(int(page * pageSize)).
+		Find(&data).Error
+	if err != nil {
+		log.Error(err)
+		return []model.TodoModel{}, err
 	}
 
+	return data, nil
+}
+
+func (td *TodoDTO) GetAfter(ctx context.Context, filter _interface.TodoQueryFilter, after _interface.Cursor, pageSize uint) ([]model.TodoModel, error) {
+	var data []model.TodoModel
+
+	keys := sortKeys(filter.OrderBy)
+	query := applyFilter(td.scoped(ctx), filter)
+	if after.Id != "" {
+		cond, args := afterClause(keys, after)
+		query = query.Where(cond, args...)
+	}
+	err := query.Order(orderClause(keys)).Limit(int(pageSize)).Find(&data).Error
 	if err != nil {
 		log.Error(err)
 		return []model.TodoModel{}, err
@@ -36,49 +59,229 @@ func (td *TodoDTO) GetMany(filter map[string]interface{}, page uint, pageSize ui
 	return data, nil
 }
 
-func (td *TodoDTO) GetSingle(id string) (model.TodoModel, error) {
+func (td *TodoDTO) Count(ctx context.Context, filter _interface.TodoQueryFilter) (int64, error) {
+	var total int64
+	err := applyFilter(td.scoped(ctx).Model(&model.TodoModel{}), filter).Count(&total).Error
+	return total, err
+}
+
+func (td *TodoDTO) GetSingle(ctx context.Context, id string) (model.TodoModel, error) {
 	var data model.TodoModel
-	err := td.Db.Postgres.First(&data, "id = ?", id).Error
+	err := td.scoped(ctx).First(&data, "id = ?", id).Error
 	if err != nil {
 		return model.TodoModel{}, err
 	}
 	return data, nil
 }
 
-func (td *TodoDTO) Create(data model.TodoModel) (model.TodoModel, error) {
-	err := td.Db.Postgres.Create(&data).Error
+// inTransaction runs fn with a TodoDTO bound to one transaction, writes commit together with
+// their audit events, revisions and outbox events. Inside Transaction it becomes a savepoint of the outer transaction.
+func (td *TodoDTO) inTransaction(ctx context.Context, fn func(tx *TodoDTO) error) error {
+	return td.Db.Postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		txDb := *td.Db
+		txDb.Postgres = tx
+		return fn(&TodoDTO{Db: &txDb})
+	})
+}
+
+func (td *TodoDTO) Create(ctx context.Context, data model.TodoModel) (model.TodoModel, error) {
+	if data.Version == 0 {
+		data.Version = 1
+	}
+	data.TenantId = tenant.From(ctx)
+	err := td.inTransaction(ctx, func(tx *TodoDTO) error {
+		if err := tx.Db.Postgres.Create(&data).Error; err != nil {
+			return err
+		}
+		if err := revise(ctx, tx.Db.Postgres, data); err != nil {
+			return err
+		}
+		return record(ctx, tx.Db.Postgres, database.EventCreated, nil, &data)
+	})
 	if err != nil {
 		return model.TodoModel{}, err
 	}
 	return data, nil
 }
 
-func (td *TodoDTO) Update(id string, data model.TodoModel) (model.TodoModel, error) {
+func (td *TodoDTO) Update(ctx context.Context, id string, data model.TodoModel) (model.TodoModel, error) {
 	var ret model.TodoModel
-	err := td.Db.Postgres.First(&ret, "id = ?", id).Error
+	err := td.inTransaction(ctx, func(tx *TodoDTO) error {
+		if err := tx.scoped(ctx).First(&ret, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if data.Version != 0 && data.Version != ret.Version {
+			return database.ErrVersionConflict
+		}
+
+		before := ret
+		ret.IsDone = data.IsDone
+		ret.Author = data.Author
+		ret.Description = data.Description
+		ret.Title = data.Title
+		ret.StartDate = data.StartDate
+		ret.EndDate = data.EndDate
+
+		ret.UpdatedAt = time.Now()
+		ret.Version = before.Version + 1
+
+		// the version guard turns the read-then-write into a compare-and-swap
+		res := tx.scoped(ctx).Model(&model.TodoModel{}).
+			Where("id = ? AND version = ?", id, before.Version).
+			Updates(map[string]interface{}{
+				"is_done":     ret.IsDone,
+				"author":      ret.Author,
+				"description": ret.Description,
+				"title":       ret.Title,
+				"start_date":  ret.StartDate,
+				"end_date":    ret.EndDate,
+				"updated_at":  ret.UpdatedAt,
+				"version":     ret.Version,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return database.ErrVersionConflict
+		}
+		if err := revise(ctx, tx.Db.Postgres, ret); err != nil {
+			return err
+		}
+		return record(ctx, tx.Db.Postgres, database.EventUpdated, &before, &ret)
+	})
 	if err != nil {
 		return model.TodoModel{}, err
 	}
+	return ret, nil
+}
 
-	ret.IsDone = data.IsDone
-	ret.Author = data.Author
-	ret.Description = data.Description
-	ret.Title = data.Title
-	ret.StartDate = data.StartDate
-	ret.EndDate = data.EndDate
+func (td *TodoDTO) Delete(ctx context.Context, id string, version uint64) (model.TodoModel, error) {
+	var data model.TodoModel
+	err := td.inTransaction(ctx, func(tx *TodoDTO) error {
+		query := tx.scoped(ctx).Where("id = ?", id)
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		res := query.Delete(&model.TodoModel{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			if version != 0 {
+				return database.ErrVersionConflict
+			}
+			return gorm.ErrRecordNotFound
+		}
 
-	ret.UpdatedAt = time.Now()
-	err = td.Db.Postgres.Save(&ret).Error
+		if err := tx.scoped(ctx).Unscoped().First(&data, "id = ?", id).Error; err != nil {
+			return err
+		}
+		// a soft delete only sets deleted_at, so the row without it is the todo as it was
+		before := data
+		before.DeletedAt = gorm.DeletedAt{}
+		return record(ctx, tx.Db.Postgres, database.EventDeleted, &before, &data)
+	})
+	if err != nil {
+		return model.TodoModel{}, err
+	}
+	return data, nil
+}
 
-	return ret, err
+func (td *TodoDTO) GetDeleted(ctx context.Context, author string, page uint, pageSize uint) ([]model.TodoModel, error) {
+	var data []model.TodoModel
+	query := td.scoped(ctx).Unscoped().Where("deleted_at IS NOT NULL")
+	if author != "" {
+		query = query.Where("author = ?", author)
+	}
+	err := query.Order("deleted_at desc").
+		Limit(int(pageSize)).Offset(int(page * pageSize)).
+		Find(&data).Error
+	if err != nil {
+		log.Error(err)
+		return []model.TodoModel{}, err
+	}
+	return data, nil
 }
 
-func (td *TodoDTO) Delete(id string) (model.TodoModel, error) {
+func (td *TodoDTO) GetSingleDeleted(ctx context.Context, id string) (model.TodoModel, error) {
 	var data model.TodoModel
-	err := td.Db.Postgres.Where("id = ?", id).Delete(&data).Error
+	err := td.scoped(ctx).Unscoped().First(&data, "id = ? AND deleted_at IS NOT NULL", id).Error
 	if err != nil {
 		return model.TodoModel{}, err
 	}
+	return data, nil
+}
+
+func (td *TodoDTO) Restore(ctx context.Context, id string) (model.TodoModel, error) {
+	var data model.TodoModel
+	err := td.inTransaction(ctx, func(tx *TodoDTO) error {
+		var before model.TodoModel
+		if err := tx.scoped(ctx).Unscoped().First(&before, "id = ? AND deleted_at IS NOT NULL", id).Error; err != nil {
+			return err
+		}
+		res := tx.scoped(ctx).Unscoped().Model(&model.TodoModel{}).
+			Where("id = ? AND deleted_at IS NOT NULL", id).
+			Update("deleted_at", nil)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
 
+		var err error
+		if data, err = tx.GetSingle(ctx, id); err != nil {
+			return err
+		}
+		return record(ctx, tx.Db.Postgres, database.EventRestored, &before, &data)
+	})
+	if err != nil {
+		return model.TodoModel{}, err
+	}
 	return data, nil
 }
+
+func (td *TodoDTO) Purge(ctx context.Context, before time.Time, limit uint) (int64, error) {
+	var purged int64
+	err := td.inTransaction(ctx, func(tx *TodoDTO) error {
+		var rows []model.TodoModel
+		err := tx.Db.Postgres.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+			Order("deleted_at, id").
+			Limit(int(limit)).
+			Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+
+		ids := make([]string, len(rows))
+		for i, row := range rows {
+			ids[i] = row.Id
+			if err := audit(ctx, tx.Db.Postgres, auditPurged, &rows[i], nil); err != nil {
+				return err
+			}
+		}
+		// revisions go with the todo, the audit log keeps its last content
+		if err := tx.Db.Postgres.Where("todo_id IN ?", ids).Delete(&model.TodoRevisionModel{}).Error; err != nil {
+			return err
+		}
+		res := tx.Db.Postgres.Unscoped().Where("id IN ?", ids).Delete(&model.TodoModel{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	return purged, err
+}
+
+func (td *TodoDTO) Transaction(ctx context.Context, fn func(tx _interface.DtoInterface[model.TodoModel]) error) error {
+	return td.inTransaction(ctx, func(tx *TodoDTO) error {
+		return fn(tx)
+	})
+}
+
+// NewTodoDTO picks the todo DtoInterface implementation matching the database driver.
+func NewTodoDTO(db *database.Database) _interface.DtoInterface[model.TodoModel] {
+	if db.Memory != nil {
+		return &TodoMemoryDTO{Db: db}
+	}
+	return &TodoDTO{Db: db}
+}
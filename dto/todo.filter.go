@@ -0,0 +1,102 @@
+package dto
+
+import (
+	"strings"
+	"time"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+
+	"gorm.io/gorm"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePattern turns user text into a LIKE pattern, wildcards typed by the user match literally.
+func likePattern(search string, prefix bool) string {
+	pattern := likeEscaper.Replace(strings.ToLower(search)) + "%"
+	if !prefix {
+		pattern = "%" + pattern
+	}
+	return pattern
+}
+
+// applyFilter translates filter into parameterized GORM conditions, it works the same on postgres and sqlite.
+func applyFilter(db *gorm.DB, filter _interface.TodoQueryFilter) *gorm.DB {
+	if len(filter.Authors) > 0 {
+		db = db.Where("author IN ?", filter.Authors)
+	}
+	if filter.Title != "" {
+		db = db.Where("title = ?", filter.Title)
+	}
+	if filter.IsDone != nil {
+		db = db.Where("is_done = ?", *filter.IsDone)
+	}
+	if !filter.StartFrom.IsZero() {
+		db = db.Where("start_date >= ?", filter.StartFrom)
+	}
+	if !filter.StartTo.IsZero() {
+		db = db.Where("start_date <= ?", filter.StartTo)
+	}
+	if !filter.EndFrom.IsZero() {
+		db = db.Where("end_date >= ?", filter.EndFrom)
+	}
+	if !filter.EndTo.IsZero() {
+		db = db.Where("end_date <= ?", filter.EndTo)
+	}
+	if filter.Overdue {
+		db = db.Where("is_done = ? AND end_date < ?", false, time.Now())
+	}
+	if filter.Search != "" {
+		pattern := likePattern(filter.Search, filter.SearchPrefix)
+		db = db.Where(`(LOWER(title) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	return db
+}
+
+// MatchTodo reports whether data passes filter, with the same rules applyFilter gives the SQL backends.
+func MatchTodo(filter _interface.TodoQueryFilter, data model.TodoModel) bool {
+	if len(filter.Authors) > 0 {
+		found := false
+		for _, author := range filter.Authors {
+			if author == data.Author {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.Title != "" && filter.Title != data.Title {
+		return false
+	}
+	if filter.IsDone != nil && *filter.IsDone != data.IsDone {
+		return false
+	}
+	if !filter.StartFrom.IsZero() && data.StartDate.Before(filter.StartFrom) {
+		return false
+	}
+	if !filter.StartTo.IsZero() && data.StartDate.After(filter.StartTo) {
+		return false
+	}
+	if !filter.EndFrom.IsZero() && data.EndDate.Before(filter.EndFrom) {
+		return false
+	}
+	if !filter.EndTo.IsZero() && data.EndDate.After(filter.EndTo) {
+		return false
+	}
+	if filter.Overdue && (data.IsDone || !data.EndDate.Before(time.Now())) {
+		return false
+	}
+	if filter.Search != "" {
+		search := strings.ToLower(filter.Search)
+		match := strings.Contains
+		if filter.SearchPrefix {
+			match = strings.HasPrefix
+		}
+		if !match(strings.ToLower(data.Title), search) && !match(strings.ToLower(data.Description), search) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,254 @@
+package dto
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
+
+	"gorm.io/gorm"
+)
+
+// TodoMemoryDTO implements the todo DtoInterface on top of database.MemoryStore,
+// keeping the same filter, ordering and pagination semantics as TodoDTO.
+type TodoMemoryDTO struct {
+	_interface.DtoInterface[model.TodoModel]
+	Db *database.Database
+}
+
+func (td *TodoMemoryDTO) SetDb(db *database.Database) {
+	td.Db = db
+}
+
+// errKeep tells RemoveIf to leave a row in place during Purge.
+var errKeep = errors.New("keep")
+
+// scoped returns every row, live or trashed, of the workspace bound to ctx.
+func (td *TodoMemoryDTO) scoped(ctx context.Context) []model.TodoModel {
+	var data []model.TodoModel
+	tenantId := tenant.From(ctx)
+	for _, row := range td.Db.Memory.All() {
+		if row.TenantId == tenantId {
+			data = append(data, row)
+		}
+	}
+	return data
+}
+
+// modify changes a row of the workspace bound to ctx, rows of other workspaces are NotFound. The audit
+// event of the change and the webhook events it raises, and with revise its revision, are stored
+// under the same lock as the row, like the postgres DTO writes them in the same transaction.
+func (td *TodoMemoryDTO) modify(ctx context.Context, id string, action string, revise bool, fn func(data *model.TodoModel) error) (model.TodoModel, error) {
+	tenantId := tenant.From(ctx)
+	return td.Db.Memory.ModifyRecorded(id, func(data *model.TodoModel) (database.Records, error) {
+		if data.TenantId != tenantId {
+			return database.Records{}, gorm.ErrRecordNotFound
+		}
+		before := *data
+		if err := fn(data); err != nil {
+			return database.Records{}, err
+		}
+		after := *data
+		records := database.Records{
+			Audit:  []model.AuditEventModel{newAuditEvent(ctx, action, &before, &after)},
+			Outbox: outboxEvents(action, &before, &after),
+		}
+		if revise {
+			records.Revisions = []model.TodoRevisionModel{model.RevisionOf(after, actorOf(ctx), time.Now())}
+		}
+		return records, nil
+	})
+}
+
+// matching returns the live rows matching filter in filter.OrderBy order.
+func (td *TodoMemoryDTO) matching(ctx context.Context, filter _interface.TodoQueryFilter) []model.TodoModel {
+	var data []model.TodoModel
+	for _, row := range td.scoped(ctx) {
+		if !row.DeletedAt.Valid && MatchTodo(filter, row) {
+			data = append(data, row)
+		}
+	}
+
+	keys := sortKeys(filter.OrderBy)
+	sort.SliceStable(data, func(i, j int) bool {
+		return compareTodos(data[i], data[j], keys) < 0
+	})
+	return data
+}
+
+func (td *TodoMemoryDTO) GetMany(ctx context.Context, filter _interface.TodoQueryFilter, page uint, pageSize uint) ([]model.TodoModel, error) {
+	rows := td.matching(ctx, filter)
+
+	data := []model.TodoModel{}
+	for i := int(page * pageSize); i < len(rows) && len(data) < int(pageSize); i++ {
+		data = append(data, rows[i])
+	}
+	return data, nil
+}
+
+func (td *TodoMemoryDTO) GetAfter(ctx context.Context, filter _interface.TodoQueryFilter, after _interface.Cursor, pageSize uint) ([]model.TodoModel, error) {
+	rows := td.matching(ctx, filter)
+	keys := sortKeys(filter.OrderBy)
+	last := cursorRow(after)
+
+	data := []model.TodoModel{}
+	for _, row := range rows {
+		if len(data) >= int(pageSize) {
+			break
+		}
+		if after.Id != "" && compareTodos(last, row, keys) >= 0 {
+			continue
+		}
+		data = append(data, row)
+	}
+	return data, nil
+}
+
+func (td *TodoMemoryDTO) Count(ctx context.Context, filter _interface.TodoQueryFilter) (int64, error) {
+	return int64(len(td.matching(ctx, filter))), nil
+}
+
+func (td *TodoMemoryDTO) GetSingle(ctx context.Context, id string) (model.TodoModel, error) {
+	data, err := td.Db.Memory.Get(id)
+	if err != nil {
+		return model.TodoModel{}, err
+	}
+	if data.TenantId != tenant.From(ctx) || data.DeletedAt.Valid {
+		return model.TodoModel{}, gorm.ErrRecordNotFound
+	}
+	return data, nil
+}
+
+func (td *TodoMemoryDTO) Create(ctx context.Context, data model.TodoModel) (model.TodoModel, error) {
+	data.TenantId = tenant.From(ctx)
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now()
+	}
+	if data.UpdatedAt.IsZero() {
+		data.UpdatedAt = data.CreatedAt
+	}
+	if data.Version == 0 {
+		data.Version = 1
+	}
+	err := td.Db.Memory.InsertRecorded(data, database.Records{
+		Revisions: []model.TodoRevisionModel{model.RevisionOf(data, actorOf(ctx), time.Now())},
+		Audit:     []model.AuditEventModel{newAuditEvent(ctx, database.EventCreated, nil, &data)},
+		Outbox:    outboxEvents(database.EventCreated, nil, &data),
+	})
+	if err != nil {
+		return model.TodoModel{}, err
+	}
+	return data, nil
+}
+
+func (td *TodoMemoryDTO) Update(ctx context.Context, id string, data model.TodoModel) (model.TodoModel, error) {
+	return td.modify(ctx, id, database.EventUpdated, true, func(ret *model.TodoModel) error {
+		if ret.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		if data.Version != 0 && data.Version != ret.Version {
+			return database.ErrVersionConflict
+		}
+		ret.Version++
+		ret.IsDone = data.IsDone
+		ret.Author = data.Author
+		ret.Description = data.Description
+		ret.Title = data.Title
+		ret.StartDate = data.StartDate
+		ret.EndDate = data.EndDate
+
+		ret.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (td *TodoMemoryDTO) Delete(ctx context.Context, id string, version uint64) (model.TodoModel, error) {
+	return td.modify(ctx, id, database.EventDeleted, false, func(data *model.TodoModel) error {
+		if data.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		if version != 0 && version != data.Version {
+			return database.ErrVersionConflict
+		}
+		data.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		return nil
+	})
+}
+
+func (td *TodoMemoryDTO) GetDeleted(ctx context.Context, author string, page uint, pageSize uint) ([]model.TodoModel, error) {
+	var trash []model.TodoModel
+	for _, row := range td.scoped(ctx) {
+		if row.DeletedAt.Valid && (author == "" || row.Author == author) {
+			trash = append(trash, row)
+		}
+	}
+	sort.SliceStable(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.Time.After(trash[j].DeletedAt.Time)
+	})
+
+	data := []model.TodoModel{}
+	for i := int(page * pageSize); i < len(trash) && len(data) < int(pageSize); i++ {
+		data = append(data, trash[i])
+	}
+	return data, nil
+}
+
+func (td *TodoMemoryDTO) GetSingleDeleted(ctx context.Context, id string) (model.TodoModel, error) {
+	data, err := td.Db.Memory.Get(id)
+	if err != nil {
+		return model.TodoModel{}, err
+	}
+	if data.TenantId != tenant.From(ctx) || !data.DeletedAt.Valid {
+		return model.TodoModel{}, gorm.ErrRecordNotFound
+	}
+	return data, nil
+}
+
+func (td *TodoMemoryDTO) Restore(ctx context.Context, id string) (model.TodoModel, error) {
+	return td.modify(ctx, id, database.EventRestored, false, func(data *model.TodoModel) error {
+		if !data.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		data.DeletedAt = gorm.DeletedAt{}
+		return nil
+	})
+}
+
+func (td *TodoMemoryDTO) Purge(ctx context.Context, before time.Time, limit uint) (int64, error) {
+	var purged int64
+	rows := td.Db.Memory.All()
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].DeletedAt.Time.Before(rows[j].DeletedAt.Time)
+	})
+	for _, row := range rows {
+		if purged >= int64(limit) {
+			break
+		}
+		var removed model.TodoModel
+		err := td.Db.Memory.RemoveIf(row.Id, func(data model.TodoModel) error {
+			if !data.DeletedAt.Valid || !data.DeletedAt.Time.Before(before) {
+				return errKeep
+			}
+			removed = data
+			return nil
+		})
+		if err == nil {
+			td.Db.Memory.RemoveRevisions(row.Id)
+			td.Db.Memory.AppendAudit(newAuditEvent(ctx, auditPurged, &removed, nil))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (td *TodoMemoryDTO) Transaction(ctx context.Context, fn func(tx _interface.DtoInterface[model.TodoModel]) error) error {
+	return td.Db.Memory.Transaction(func(tx *database.MemoryStore) error {
+		txDb := *td.Db
+		txDb.Memory = tx
+		return fn(&TodoMemoryDTO{Db: &txDb})
+	})
+}
@@ -0,0 +1,116 @@
+package dto
+
+import (
+	"strings"
+	"time"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+)
+
+// SortColumns are the columns a list can be ordered by.
+var SortColumns = []string{"end_date", "start_date", "created_at", "updated_at", "title"}
+
+// sortKeys is the complete order of a list: orderBy, then created_at and id as tie breakers
+// so every row has exactly one position and keyset pages never skip or repeat rows.
+func sortKeys(orderBy []_interface.SortKey) []_interface.SortKey {
+	keys := make([]_interface.SortKey, 0, len(orderBy)+2)
+	hasCreatedAt := false
+	for _, key := range orderBy {
+		if columnValue(model.TodoModel{}, key.Column) == nil {
+			continue
+		}
+		hasCreatedAt = hasCreatedAt || key.Column == "created_at"
+		keys = append(keys, key)
+	}
+	if !hasCreatedAt {
+		keys = append(keys, _interface.SortKey{Column: "created_at"})
+	}
+	return append(keys, _interface.SortKey{Column: "id"})
+}
+
+// orderClause renders keys as an ORDER BY list, the columns come from the whitelist in columnValue.
+func orderClause(keys []_interface.SortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key.Column
+		if key.Desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// afterClause is the keyset condition for rows that sort after the cursor:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for descending keys.
+func afterClause(keys []_interface.SortKey, after _interface.Cursor) (string, []interface{}) {
+	row := cursorRow(after)
+
+	var ors []string
+	var args []interface{}
+	for i, key := range keys {
+		var ands []string
+		for _, prev := range keys[:i] {
+			ands = append(ands, prev.Column+" = ?")
+			args = append(args, columnValue(row, prev.Column))
+		}
+		op := " > ?"
+		if key.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, key.Column+op)
+		args = append(args, columnValue(row, key.Column))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// cursorRow rebuilds the sortable columns of the row a cursor points at.
+func cursorRow(after _interface.Cursor) model.TodoModel {
+	return model.TodoModel{
+		Id:        after.Id,
+		Title:     after.Title,
+		StartDate: after.StartDate,
+		EndDate:   after.EndDate,
+		CreatedAt: after.CreatedAt,
+		UpdatedAt: after.UpdatedAt,
+	}
+}
+
+// columnValue returns the value of a sortable column, nil for any other column.
+func columnValue(data model.TodoModel, column string) interface{} {
+	switch column {
+	case "id":
+		return data.Id
+	case "title":
+		return data.Title
+	case "start_date":
+		return data.StartDate
+	case "end_date":
+		return data.EndDate
+	case "created_at":
+		return data.CreatedAt
+	case "updated_at":
+		return data.UpdatedAt
+	}
+	return nil
+}
+
+// compareTodos orders a and b by keys the way orderClause does in SQL, it returns -1, 0 or 1.
+func compareTodos(a, b model.TodoModel, keys []_interface.SortKey) int {
+	for _, key := range keys {
+		c := 0
+		switch av := columnValue(a, key.Column).(type) {
+		case string:
+			c = strings.Compare(av, columnValue(b, key.Column).(string))
+		case time.Time:
+			c = av.Compare(columnValue(b, key.Column).(time.Time))
+		}
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
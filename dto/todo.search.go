@@ -0,0 +1,229 @@
+package dto
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
+
+	"gorm.io/gorm"
+)
+
+// snippetRadius is how much text the fallback snippet keeps around the first match.
+const snippetRadius = 40
+
+// pgSearchHit is a row of the search queries, the todo columns plus rank and, on postgres, snippet.
+type pgSearchHit struct {
+	model.TodoModel
+	Rank    float64
+	Snippet string
+}
+
+const pgSearchFrom = `FROM todo_models t, websearch_to_tsquery('english', ?) q
+	WHERE t.tenant_id = ? AND t.deleted_at IS NULL AND t.search_vector @@ q`
+
+func (td *TodoDTO) Search(ctx context.Context, query string, offset uint, pageSize uint) ([]_interface.SearchHit[model.TodoModel], int64, error) {
+	if td.Db.Driver != database.DriverPostgres {
+		return td.searchFallback(ctx, query, offset, pageSize)
+	}
+
+	tenantId := tenant.From(ctx)
+	var total int64
+	if err := td.Db.Postgres.WithContext(ctx).Raw("SELECT count(*) "+pgSearchFrom, query, tenantId).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []pgSearchHit
+	err := td.Db.Postgres.WithContext(ctx).Raw(`SELECT t.*, ts_rank(t.search_vector, q) AS rank,
+		ts_headline('english', coalesce(t.title, '') || ' ' || coalesce(t.description, ''), q,
+			'StartSel=<b>, StopSel=</b>, MaxFragments=2') AS snippet
+		`+pgSearchFrom+`
+		ORDER BY rank DESC, t.created_at, t.id
+		LIMIT ? OFFSET ?`, query, tenantId, pageSize, offset).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]_interface.SearchHit[model.TodoModel], 0, len(rows))
+	for _, r := range rows {
+		hits = append(hits, _interface.SearchHit[model.TodoModel]{Item: r.TodoModel, Rank: r.Rank, Snippet: r.Snippet})
+	}
+	return hits, total, nil
+}
+
+// searchFallback ranks like rankFallback but in SQL, so only the requested page is read.
+func (td *TodoDTO) searchFallback(ctx context.Context, query string, offset uint, pageSize uint) ([]_interface.SearchHit[model.TodoModel], int64, error) {
+	terms := searchTerms(query)
+	matching := func() *gorm.DB {
+		db := td.scoped(ctx).Model(&model.TodoModel{})
+		for _, term := range terms {
+			db = applyFilter(db, _interface.TodoQueryFilter{Search: term})
+		}
+		return db
+	}
+
+	rank, args := "0", []interface{}{}
+	for _, term := range terms {
+		// occurrences of term, counted by how much shorter the text gets without it
+		rank += ` + 2 * (LENGTH(LOWER(title)) - LENGTH(REPLACE(LOWER(title), ?, ''))) / LENGTH(?)
+			+ (LENGTH(LOWER(description)) - LENGTH(REPLACE(LOWER(description), ?, ''))) / LENGTH(?)`
+		args = append(args, term, term, term, term)
+	}
+
+	var total int64
+	if err := matching().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []pgSearchHit
+	err := matching().Select("*, ("+rank+") AS rank", args...).
+		Order("rank DESC, " + orderClause(sortKeys(nil))).
+		Limit(int(pageSize)).
+		Offset(int(offset)).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]_interface.SearchHit[model.TodoModel], 0, len(rows))
+	for _, r := range rows {
+		hits = append(hits, _interface.SearchHit[model.TodoModel]{
+			Item:    r.TodoModel,
+			Rank:    r.Rank,
+			Snippet: snippet(r.Title+" "+r.Description, terms),
+		})
+	}
+	return hits, total, nil
+}
+
+func (td *TodoMemoryDTO) Search(ctx context.Context, query string, offset uint, pageSize uint) ([]_interface.SearchHit[model.TodoModel], int64, error) {
+	rows := td.matching(ctx, _interface.TodoQueryFilter{})
+	var matched []model.TodoModel
+	for _, row := range rows {
+		ok := true
+		for _, term := range searchTerms(query) {
+			if !MatchTodo(_interface.TodoQueryFilter{Search: term}, row) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, row)
+		}
+	}
+	return rankFallback(matched, query, offset, pageSize)
+}
+
+// searchTerms splits a query into lower case words, every one of them has to match.
+func searchTerms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, t := range strings.Fields(strings.ToLower(query)) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// rankFallback orders rows that contain every term by how often the terms occur,
+// a hit in the title counts double like the 'A' weight of the postgres vector.
+func rankFallback(rows []model.TodoModel, query string, offset uint, pageSize uint) ([]_interface.SearchHit[model.TodoModel], int64, error) {
+	terms := searchTerms(query)
+	hits := make([]_interface.SearchHit[model.TodoModel], 0, len(rows))
+	for _, row := range rows {
+		title, description := strings.ToLower(row.Title), strings.ToLower(row.Description)
+		var rank float64
+		for _, t := range terms {
+			rank += 2*float64(strings.Count(title, t)) + float64(strings.Count(description, t))
+		}
+		hits = append(hits, _interface.SearchHit[model.TodoModel]{
+			Item:    row,
+			Rank:    rank,
+			Snippet: snippet(row.Title+" "+row.Description, terms),
+		})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return compareTodos(hits[i].Item, hits[j].Item, sortKeys(nil)) < 0
+	})
+
+	total := int64(len(hits))
+	if int(offset) >= len(hits) {
+		return []_interface.SearchHit[model.TodoModel]{}, total, nil
+	}
+	hits = hits[offset:]
+	if len(hits) > int(pageSize) {
+		hits = hits[:pageSize]
+	}
+	return hits, total, nil
+}
+
+// snippet cuts text around the first matched term and wraps every match in <b></b>.
+func snippet(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// case folding changed byte offsets, match on the text as it is
+		lower = text
+	}
+
+	first := -1
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	start, end := first-snippetRadius, first+snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	// don't cut through a multi byte character
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("...")
+	}
+	for i := start; i < end; {
+		matched := ""
+		for _, t := range terms {
+			if strings.HasPrefix(lower[i:], t) && len(t) > len(matched) {
+				matched = t
+			}
+		}
+		if matched == "" {
+			sb.WriteByte(text[i])
+			i++
+			continue
+		}
+		sb.WriteString("<b>" + text[i:i+len(matched)] + "</b>")
+		i += len(matched)
+	}
+	if end < len(text) {
+		sb.WriteString("...")
+	}
+	return sb.String()
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
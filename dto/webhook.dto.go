@@ -0,0 +1,343 @@
+package dto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+	"todo_pikpo/tenant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// errEventTaken rolls back a Fanout whose event another dispatcher already handed out.
+var errEventTaken = errors.New("outbox event was taken already")
+
+// errLeaseLost is returned by SaveDelivery for a delivery that is gone or was leased again since it was taken.
+var errLeaseLost = errors.New("delivery is gone or its lease ran out")
+
+// outboxEvents are the webhook events a change of a todo raises, an edit that marks the todo
+// as done raises todo.completed next to todo.updated. Purged todos raise none.
+func outboxEvents(action string, before *model.TodoModel, after *model.TodoModel) []model.OutboxEventModel {
+	var types []string
+	switch action {
+	case database.EventCreated:
+		types = []string{model.WebhookTodoCreated}
+	case database.EventUpdated:
+		types = []string{model.WebhookTodoUpdated}
+		if before != nil && !before.IsDone && after.IsDone {
+			types = append(types, model.WebhookTodoCompleted)
+		}
+	case database.EventDeleted:
+		types = []string{model.WebhookTodoDeleted}
+	case database.EventRestored:
+		types = []string{model.WebhookTodoRestored}
+	}
+
+	now := time.Now()
+	changes := model.TodoChanges(before, after)
+	events := make([]model.OutboxEventModel, 0, len(types))
+	for i, eventType := range types {
+		// the events of one change are a microsecond apart, postgres keeps that much, so they keep their order
+		at := now.Add(time.Duration(i) * time.Microsecond)
+		payload := model.WebhookPayload{
+			Id:        uuid.New().String(),
+			Type:      eventType,
+			TenantId:  after.TenantId,
+			CreatedAt: at,
+			Todo:      after,
+			Changes:   changes,
+		}
+		jd, _ := json.Marshal(payload)
+		events = append(events, model.OutboxEventModel{
+			Id:        payload.Id,
+			TenantId:  after.TenantId,
+			TodoId:    after.Id,
+			Type:      eventType,
+			Payload:   string(jd),
+			CreatedAt: at,
+		})
+	}
+	return events
+}
+
+// record appends the audit event of a change and the webhook events it raises to the transaction tx that made it.
+func record(ctx context.Context, tx *gorm.DB, action string, before *model.TodoModel, after *model.TodoModel) error {
+	if err := audit(ctx, tx, action, before, after); err != nil {
+		return err
+	}
+	events := outboxEvents(action, before, after)
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.Create(&events).Error
+}
+
+type WebhookDTO struct {
+	Db *database.Database
+}
+
+// scoped starts every query of the workspace bound to ctx.
+func (wd *WebhookDTO) scoped(ctx context.Context) *gorm.DB {
+	return wd.Db.Postgres.WithContext(ctx).Where("tenant_id = ?", tenant.From(ctx))
+}
+
+func (wd *WebhookDTO) Create(ctx context.Context, hook model.WebhookModel) (model.WebhookModel, error) {
+	hook.TenantId = tenant.From(ctx)
+	if err := wd.Db.Postgres.WithContext(ctx).Create(&hook).Error; err != nil {
+		return model.WebhookModel{}, err
+	}
+	return hook, nil
+}
+
+func (wd *WebhookDTO) List(ctx context.Context) ([]model.WebhookModel, error) {
+	var hooks []model.WebhookModel
+	if err := wd.scoped(ctx).Order("created_at, id").Find(&hooks).Error; err != nil {
+		return []model.WebhookModel{}, err
+	}
+	return hooks, nil
+}
+
+func (wd *WebhookDTO) Get(ctx context.Context, id string) (model.WebhookModel, error) {
+	var hook model.WebhookModel
+	if err := wd.scoped(ctx).First(&hook, "id = ?", id).Error; err != nil {
+		return model.WebhookModel{}, err
+	}
+	return hook, nil
+}
+
+func (wd *WebhookDTO) Delete(ctx context.Context, id string) (model.WebhookModel, error) {
+	var hook model.WebhookModel
+	err := wd.Db.Postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ?", tenant.From(ctx)).First(&hook, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("webhook_id = ?", id).Delete(&model.WebhookDeliveryModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&model.WebhookModel{}).Error
+	})
+	if err != nil {
+		return model.WebhookModel{}, err
+	}
+	return hook, nil
+}
+
+func (wd *WebhookDTO) Backlog(ctx context.Context, id string) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Total  int64
+	}
+	err := wd.scoped(ctx).Model(&model.WebhookDeliveryModel{}).
+		Select("status, count(*) as total").
+		Where("webhook_id = ?", id).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return map[string]int64{}, err
+	}
+	backlog := map[string]int64{}
+	for _, row := range rows {
+		backlog[row.Status] = row.Total
+	}
+	return backlog, nil
+}
+
+func (wd *WebhookDTO) Outbox(ctx context.Context, limit uint) ([]model.OutboxEventModel, error) {
+	var events []model.OutboxEventModel
+	if err := wd.Db.Postgres.WithContext(ctx).Order("created_at, id").Limit(int(limit)).Find(&events).Error; err != nil {
+		return []model.OutboxEventModel{}, err
+	}
+	return events, nil
+}
+
+func (wd *WebhookDTO) Fanout(ctx context.Context, event model.OutboxEventModel, deliveries []model.WebhookDeliveryModel) error {
+	err := wd.Db.Postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ?", event.Id).Delete(&model.OutboxEventModel{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errEventTaken
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		return tx.Create(&deliveries).Error
+	})
+	if errors.Is(err, errEventTaken) {
+		return nil
+	}
+	return err
+}
+
+func (wd *WebhookDTO) Due(ctx context.Context, now time.Time, until time.Time, limit uint) ([]model.WebhookDeliveryModel, error) {
+	db := wd.Db.Postgres.WithContext(ctx)
+	var ids []string
+	err := db.Model(&model.WebhookDeliveryModel{}).
+		Where("status = ? AND next_attempt_at <= ?", model.DeliveryPending, now).
+		Order("created_at, id").
+		Limit(int(limit)).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return []model.WebhookDeliveryModel{}, err
+	}
+
+	// the update checks the rows are still due, of the dispatchers racing for a row only the first leases it
+	lease := uuid.New().String()
+	err = db.Model(&model.WebhookDeliveryModel{}).
+		Where("id IN ? AND status = ? AND next_attempt_at <= ?", ids, model.DeliveryPending, now).
+		Updates(map[string]interface{}{"lease_id": lease, "next_attempt_at": until}).Error
+	if err != nil {
+		return []model.WebhookDeliveryModel{}, err
+	}
+	var deliveries []model.WebhookDeliveryModel
+	if err := db.Where("lease_id = ?", lease).Order("created_at, id").Find(&deliveries).Error; err != nil {
+		return []model.WebhookDeliveryModel{}, err
+	}
+	return deliveries, nil
+}
+
+func (wd *WebhookDTO) SaveDelivery(ctx context.Context, delivery model.WebhookDeliveryModel, delivered bool) error {
+	query := wd.Db.Postgres.WithContext(ctx).Where("id = ? AND lease_id = ?", delivery.Id, delivery.LeaseId)
+	var res *gorm.DB
+	if delivered {
+		res = query.Delete(&model.WebhookDeliveryModel{})
+	} else {
+		res = query.Model(&model.WebhookDeliveryModel{}).Updates(map[string]interface{}{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"last_error":      delivery.LastError,
+			"lease_id":        "",
+		})
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// WebhookMemoryDTO keeps webhooks, the outbox and deliveries in database.MemoryStore.
+type WebhookMemoryDTO struct {
+	Db *database.Database
+}
+
+func (wd *WebhookMemoryDTO) Create(ctx context.Context, hook model.WebhookModel) (model.WebhookModel, error) {
+	hook.TenantId = tenant.From(ctx)
+	if hook.CreatedAt.IsZero() {
+		hook.CreatedAt = time.Now()
+	}
+	if err := wd.Db.Memory.InsertWebhook(hook); err != nil {
+		return model.WebhookModel{}, err
+	}
+	return hook, nil
+}
+
+func (wd *WebhookMemoryDTO) List(ctx context.Context) ([]model.WebhookModel, error) {
+	hooks := []model.WebhookModel{}
+	tenantId := tenant.From(ctx)
+	for _, hook := range wd.Db.Memory.Webhooks() {
+		if hook.TenantId == tenantId {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if !hooks[i].CreatedAt.Equal(hooks[j].CreatedAt) {
+			return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+		}
+		return hooks[i].Id < hooks[j].Id
+	})
+	return hooks, nil
+}
+
+func (wd *WebhookMemoryDTO) Get(ctx context.Context, id string) (model.WebhookModel, error) {
+	hooks, _ := wd.List(ctx)
+	for _, hook := range hooks {
+		if hook.Id == id {
+			return hook, nil
+		}
+	}
+	return model.WebhookModel{}, gorm.ErrRecordNotFound
+}
+
+func (wd *WebhookMemoryDTO) Delete(ctx context.Context, id string) (model.WebhookModel, error) {
+	tenantId := tenant.From(ctx)
+	return wd.Db.Memory.RemoveWebhook(id, func(hook model.WebhookModel) error {
+		if hook.TenantId != tenantId {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (wd *WebhookMemoryDTO) Backlog(ctx context.Context, id string) (map[string]int64, error) {
+	backlog := map[string]int64{}
+	tenantId := tenant.From(ctx)
+	for _, delivery := range wd.Db.Memory.Deliveries() {
+		if delivery.TenantId == tenantId && delivery.WebhookId == id {
+			backlog[delivery.Status]++
+		}
+	}
+	return backlog, nil
+}
+
+func (wd *WebhookMemoryDTO) Outbox(ctx context.Context, limit uint) ([]model.OutboxEventModel, error) {
+	events := []model.OutboxEventModel{}
+	for _, event := range wd.Db.Memory.Outbox() {
+		if len(events) == int(limit) {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (wd *WebhookMemoryDTO) Fanout(ctx context.Context, event model.OutboxEventModel, deliveries []model.WebhookDeliveryModel) error {
+	wd.Db.Memory.Fanout(event.Id, deliveries)
+	return nil
+}
+
+func (wd *WebhookMemoryDTO) Due(ctx context.Context, now time.Time, until time.Time, limit uint) ([]model.WebhookDeliveryModel, error) {
+	var due []model.WebhookDeliveryModel
+	for _, delivery := range wd.Db.Memory.Deliveries() {
+		if delivery.Status == model.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].CreatedAt.Equal(due[j].CreatedAt) {
+			return due[i].CreatedAt.Before(due[j].CreatedAt)
+		}
+		return due[i].Id < due[j].Id
+	})
+
+	var ids []string
+	for i := 0; i < len(due) && len(ids) < int(limit); i++ {
+		ids = append(ids, due[i].Id)
+	}
+	return wd.Db.Memory.LeaseDeliveries(ids, now, until, uuid.New().String()), nil
+}
+
+func (wd *WebhookMemoryDTO) SaveDelivery(ctx context.Context, delivery model.WebhookDeliveryModel, delivered bool) error {
+	if !wd.Db.Memory.SaveDelivery(delivery, delivered) {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// NewWebhookDTO picks the WebhookInterface implementation matching the database driver.
+func NewWebhookDTO(db *database.Database) _interface.WebhookInterface {
+	if db.Memory != nil {
+		return &WebhookMemoryDTO{Db: db}
+	}
+	return &WebhookDTO{Db: db}
+}
@@ -0,0 +1,88 @@
+package grpc
+
+import (
+	"context"
+	"time"
+	model "todo_pikpo/database/models"
+	pb "todo_pikpo/grpc/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func unixOf(t *time.Time) uint64 {
+	if t == nil {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
+func toApiKey(k model.ApiKeyModel) *pb.ApiKey {
+	return &pb.ApiKey{
+		Id:         k.Id,
+		Name:       k.Name,
+		Scopes:     k.ScopeList(),
+		CreatedAt:  uint64(k.CreatedAt.Unix()),
+		ExpiresAt:  unixOf(k.ExpiresAt),
+		RevokedAt:  unixOf(k.RevokedAt),
+		LastUsedAt: unixOf(k.LastUsedAt),
+	}
+}
+
+func (gs *GrpcServer) apiKeyResponse(ctx context.Context, res model.ApiKeyModel, key string, err error) (*pb.ApiKeyResponse, error) {
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	return &pb.ApiKeyResponse{
+		IsOk:  err == nil,
+		Value: toApiKey(res),
+		Error: &eResp,
+		Key:   key,
+	}, nil
+}
+
+func (gs *GrpcServer) CreateApiKey(ctx context.Context, data *pb.CreateApiKeyRequest) (*pb.ApiKeyResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - CreateApiKey ", data.GetName())
+
+	var expiresAt time.Time
+	if data.GetExpiresAt() > 0 {
+		expiresAt = time.Unix(int64(data.GetExpiresAt()), 0)
+	}
+	res, key, err := gs.controller.CreateApiKey(ctx, data.GetName(), data.GetScopes(), expiresAt)
+	return gs.apiKeyResponse(ctx, res, key, err)
+}
+
+func (gs *GrpcServer) ListApiKeys(ctx context.Context, _ *pb.ListApiKeysRequest) (*pb.ApiKeysResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - ListApiKeys ")
+
+	res, err := gs.controller.ListApiKeys(ctx)
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var keys []*pb.ApiKey
+	for _, k := range res {
+		keys = append(keys, toApiKey(k))
+	}
+	return &pb.ApiKeysResponse{
+		IsOk:  err == nil,
+		Value: keys,
+		Error: &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) RevokeApiKey(ctx context.Context, data *pb.RevokeApiKeyRequest) (*pb.ApiKeyResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - RevokeApiKey ", data.GetId())
+
+	res, err := gs.controller.RevokeApiKey(ctx, data.GetId(), time.Duration(data.GetGraceSeconds())*time.Second)
+	return gs.apiKeyResponse(ctx, res, "", err)
+}
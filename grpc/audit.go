@@ -0,0 +1,94 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+	model "todo_pikpo/database/models"
+	pb "todo_pikpo/grpc/proto"
+	_interface "todo_pikpo/interface"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// jsonStruct turns a stored JSON object into a Struct, nil when there is none.
+func jsonStruct(raw string) *structpb.Struct {
+	var fields map[string]interface{}
+	if raw == "" || json.Unmarshal([]byte(raw), &fields) != nil {
+		return nil
+	}
+	res, _ := structpb.NewStruct(fields)
+	return res
+}
+
+// fieldValue converts a FieldChange value, times are written like their JSON.
+func fieldValue(v interface{}) *structpb.Value {
+	if t, ok := v.(time.Time); ok {
+		v = t.Format(time.RFC3339Nano)
+	}
+	res, _ := structpb.NewValue(v)
+	return res
+}
+
+func toFieldChanges(changes []model.FieldChange) []*pb.FieldChange {
+	var res []*pb.FieldChange
+	for _, c := range changes {
+		res = append(res, &pb.FieldChange{Field: c.Field, Before: fieldValue(c.Before), After: fieldValue(c.After)})
+	}
+	return res
+}
+
+func toAuditEvent(e model.AuditEventModel) *pb.AuditEvent {
+	// stored changes went through JSON, so their times are strings already
+	var changes []model.FieldChange
+	_ = json.Unmarshal([]byte(e.Changes), &changes)
+
+	return &pb.AuditEvent{
+		Id:         e.Id,
+		TodoId:     e.TodoId,
+		Action:     e.Action,
+		Actor:      e.Actor,
+		Method:     e.Method,
+		RequestId:  e.RequestId,
+		Before:     jsonStruct(e.Before),
+		After:      jsonStruct(e.After),
+		Changes:    toFieldChanges(changes),
+		OccurredAt: uint64(e.CreatedAt.Unix()),
+	}
+}
+
+func (gs *GrpcServer) ListAuditEvents(ctx context.Context, data *pb.ListAuditEventsRequest) (*pb.AuditEventsResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - ListAuditEvents ", data.GetTodoId())
+
+	filter := _interface.AuditFilter{
+		TodoId: data.GetTodoId(),
+		Actor:  data.GetActor(),
+		From:   unixOrZero(data.GetFrom()),
+		To:     unixOrZero(data.GetTo()),
+	}
+	if !filter.To.IsZero() {
+		// to is inclusive of its whole second
+		filter.To = filter.To.Add(time.Second - time.Nanosecond)
+	}
+	res, err := gs.controller.ListAuditEvents(ctx, filter, data.GetPageToken(), uint(data.GetLimit()))
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var events []*pb.AuditEvent
+	for _, e := range res.Events {
+		events = append(events, toAuditEvent(e))
+	}
+	return &pb.AuditEventsResponse{
+		IsOk:          err == nil,
+		Value:         events,
+		Error:         &eResp,
+		NextPageToken: res.NextPageToken,
+	}, nil
+}
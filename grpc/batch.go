@@ -0,0 +1,118 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"time"
+	"todo_pikpo/controllers"
+	model "todo_pikpo/database/models"
+	pb "todo_pikpo/grpc/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// batchResponse reports every item on its own, a failed item never turns into an RPC error.
+func (gs *GrpcServer) batchResponse(ctx context.Context, results []controllers.BatchItem, err error) (*pb.BatchResponse, error) {
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var items []*pb.BatchItemResponse
+	isOk := err == nil
+	for i, r := range results {
+		item := &pb.BatchItemResponse{
+			Index: uint32(i),
+			IsOk:  r.Err == nil,
+			Value: toDataResponse(r.Todo),
+			Error: &pb.ErrorResponse{},
+		}
+		if r.Err != nil {
+			isOk = false
+			e := errorEnvelope(ctx, r.Err)
+			item.Error = &e
+		}
+		items = append(items, item)
+	}
+
+	return &pb.BatchResponse{
+		IsOk:  isOk,
+		Value: items,
+		Error: &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) BatchAddTodo(ctx context.Context, data *pb.BatchAddRequest) (*pb.BatchResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - BatchAddTodo ", len(data.GetData()), " items")
+
+	var list []model.TodoModel
+	for _, d := range data.GetData() {
+		list = append(list, fromAddRequest(d))
+	}
+
+	results, err := gs.controller.BatchAddTodo(ctx, list, data.GetAtomic())
+	return gs.batchResponse(ctx, results, err)
+}
+
+func (gs *GrpcServer) BatchEditTodo(ctx context.Context, data *pb.BatchEditRequest) (*pb.BatchResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - BatchEditTodo ", len(data.GetData()), " items")
+
+	var list []controllers.BatchEdit
+	for _, d := range data.GetData() {
+		edit := fromAddRequest(d.GetData())
+		edit.Version = d.GetExpectedVersion()
+		list = append(list, controllers.BatchEdit{
+			Id:     d.GetId().GetId(),
+			Data:   edit,
+			Fields: d.GetUpdateMask().GetPaths(),
+		})
+	}
+
+	results, err := gs.controller.BatchEditTodo(ctx, list, data.GetAtomic())
+	return gs.batchResponse(ctx, results, err)
+}
+
+func (gs *GrpcServer) BatchDeleteTodo(ctx context.Context, data *pb.BatchDeleteRequest) (*pb.BatchResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - BatchDeleteTodo ", len(data.GetData()), " items")
+
+	var list []controllers.BatchDelete
+	for _, d := range data.GetData() {
+		list = append(list, controllers.BatchDelete{Id: d.GetId(), ExpectedVersion: d.GetExpectedVersion()})
+	}
+
+	results, err := gs.controller.BatchDeleteTodo(ctx, list, data.GetAtomic())
+	return gs.batchResponse(ctx, results, err)
+}
+
+// StreamAddTodo collects every chunk the client sends and stores them as one batch once the client closes its side.
+func (gs *GrpcServer) StreamAddTodo(stream pb.StreamService_StreamAddTodoServer) error {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - StreamAddTodo ")
+
+	var list []model.TodoModel
+	var atomic, first = false, true
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			atomic, first = chunk.GetAtomic(), false
+		}
+		for _, d := range chunk.GetData() {
+			list = append(list, fromAddRequest(d))
+		}
+	}
+
+	results, err := gs.controller.BatchAddTodo(stream.Context(), list, atomic)
+	resp, err := gs.batchResponse(stream.Context(), results, err)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
@@ -0,0 +1,89 @@
+package grpc
+
+import (
+	"context"
+	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/request"
+
+	pb "todo_pikpo/grpc/proto"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcCode maps domain error kinds to gRPC codes.
+func grpcCode(kind apperror.Kind) codes.Code {
+	switch kind {
+	case apperror.KindValidation:
+		return codes.InvalidArgument
+	case apperror.KindNotFound:
+		return codes.NotFound
+	case apperror.KindConflict:
+		return codes.Aborted
+	case apperror.KindUnavailable:
+		return codes.Unavailable
+	case apperror.KindPermissionDenied:
+		return codes.PermissionDenied
+	case apperror.KindResourceExhausted:
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
+
+// clientMessage is the message of err clients get to see. Internal errors may quote the database or
+// its driver, so they are logged here and clients only get the request id to report.
+func clientMessage(ctx context.Context, err error) string {
+	if apperror.KindOf(err) != apperror.KindInternal {
+		return err.Error()
+	}
+	id := request.From(ctx).Id
+	log.Error(time.Now().Format("2006-01-02 15:04:05"), " grpc - request ", id, " internal error ", err)
+	return "internal error, request id " + id
+}
+
+// statusError builds the gRPC status returned to clients, validation failures
+// carry a BadRequest detail naming the offending fields.
+func statusError(ctx context.Context, err error) error {
+	st := status.New(grpcCode(apperror.KindOf(err)), clientMessage(ctx, err))
+
+	if fields := apperror.FieldsOf(err); len(fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, f := range fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Description,
+			})
+		}
+		if detailed, dErr := st.WithDetails(badRequest); dErr == nil {
+			st = detailed
+		}
+	}
+
+	return st.Err()
+}
+
+// errorEnvelope is the legacy error representation, kept for callers that
+// read pb.ErrorResponse instead of the gRPC status.
+func errorEnvelope(ctx context.Context, err error) pb.ErrorResponse {
+	var details *structpb.Struct
+	if fields := apperror.FieldsOf(err); len(fields) > 0 {
+		violations := make([]interface{}, 0, len(fields))
+		for _, f := range fields {
+			violations = append(violations, map[string]interface{}{
+				"field":       f.Field,
+				"description": f.Description,
+			})
+		}
+		details, _ = structpb.NewStruct(map[string]interface{}{"fieldViolations": violations})
+	}
+
+	return pb.ErrorResponse{
+		Code:    uint32(apperror.HttpStatus(err)),
+		Message: clientMessage(ctx, err),
+		Details: details,
+	}
+}
@@ -0,0 +1,30 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"todo_pikpo/apperror"
+	"todo_pikpo/request"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInternalErrorsStayOnTheServer(t *testing.T) {
+	a := assert.New(t)
+	ctx := request.With(context.Background(), request.Info{Id: "req-1"})
+
+	cause := apperror.Internal(errors.New(`pq: duplicate key value violates unique constraint "todo_models_pkey"`))
+	st := status.Convert(statusError(ctx, cause))
+	a.Equal(st.Code(), codes.Internal)
+	a.Equal(st.Message(), "internal error, request id req-1")
+	a.Equal(errorEnvelope(ctx, cause).Message, "internal error, request id req-1")
+	a.Equal(errorEnvelope(ctx, errors.New("not wrapped at all")).Message, "internal error, request id req-1")
+
+	// the other kinds are meant for the caller
+	notFound := apperror.NotFound("todo %s not found", "t1")
+	a.Equal(status.Convert(statusError(ctx, notFound)).Message(), "todo t1 not found")
+	a.Equal(errorEnvelope(ctx, notFound).Message, "todo t1 not found")
+}
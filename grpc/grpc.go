@@ -2,33 +2,115 @@ package grpc
 
 import (
 	"context"
+	"strconv"
 	"time"
 	"todo_pikpo/controllers"
+	"todo_pikpo/database"
 	model "todo_pikpo/database/models"
+	"todo_pikpo/dto"
 	pb "todo_pikpo/grpc/proto"
+	_interface "todo_pikpo/interface"
 
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type GrpcServer struct {
 	pb.TodoServiceServer
 	pb.StreamServiceServer
-	controller *controllers.TodoController
+	pb.KeyServiceServer
+	pb.AuditServiceServer
+	pb.WebhookServiceServer
+	controller    *controllers.TodoController
+	errorEnvelope bool
 }
 
-func (gs *GrpcServer) todoGetter(filter *pb.FilterRequest) ([]*pb.DataResponse, error) {
-	var query = map[string]interface{}{}
-	pg := 0
-	limit := 10
-	if len(filter.GetAuthor()) > 0 {
-		query["author"] = filter.GetAuthor()
+// SetErrorEnvelope switches failed RPCs back to the legacy behaviour: a nil error
+// with the failure described in pb.ErrorResponse instead of a gRPC status.
+func (gs *GrpcServer) SetErrorEnvelope(enabled bool) {
+	gs.errorEnvelope = enabled
+}
+
+func toDataResponse(d model.TodoModel) *pb.DataResponse {
+	var deletedAt uint64
+	if d.DeletedAt.Valid {
+		deletedAt = uint64(d.DeletedAt.Time.Unix())
 	}
-	if len(filter.GetTitle()) > 0 {
-		query["title"] = filter.GetTitle()
+	return &pb.DataResponse{
+		Author:      d.Author,
+		Title:       d.Title,
+		Description: d.Description,
+		IsDone:      d.IsDone,
+		StartDate:   uint64(d.StartDate.Unix()),
+		EndDate:     uint64(d.EndDate.Unix()),
+		CreatedAt:   uint64(d.CreatedAt.Unix()),
+		UpdatedAt:   uint64(d.UpdatedAt.Unix()),
+		Id:          d.Id,
+		Version:     d.Version,
+		DeletedAt:   deletedAt,
 	}
-	if filter.GetIsDone() == true && filter.GetIsDone() == false {
-		query["is_done"] = filter.GetIsDone()
+}
+
+func fromAddRequest(data *pb.AddRequest) model.TodoModel {
+	return model.TodoModel{
+		Author:      data.GetAuthor(),
+		Title:       data.GetTitle(),
+		Description: data.GetDescription(),
+		IsDone:      data.GetIsDone(),
+		StartDate:   time.Unix(int64(data.GetStartDate()), 0),
+		EndDate:     time.Unix(int64(data.GetEndDate()), 0),
+	}
+}
+
+// todoPage is what todoGetter found, NextPageToken and TotalSize stay empty for offset paging.
+type todoPage struct {
+	Value         []*pb.DataResponse
+	NextPageToken string
+	TotalSize     int64
+}
+
+func unixOrZero(ts uint64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(ts), 0)
+}
+
+// toQueryFilter maps the filter fields of a FilterRequest, paging fields are left to the caller.
+func toQueryFilter(filter *pb.FilterRequest) (_interface.TodoQueryFilter, error) {
+	orderBy, err := controllers.ParseOrderBy(filter.GetOrderBy())
+	if err != nil {
+		return _interface.TodoQueryFilter{}, err
+	}
+
+	query := _interface.TodoQueryFilter{
+		Authors:      filter.GetAuthors(),
+		Title:        filter.GetTitle(),
+		IsDone:       filter.IsDone,
+		StartFrom:    unixOrZero(filter.GetStartFrom()),
+		StartTo:      unixOrZero(filter.GetStartTo()),
+		EndFrom:      unixOrZero(filter.GetEndFrom()),
+		EndTo:        unixOrZero(filter.GetEndTo()),
+		Overdue:      filter.GetOverdue(),
+		Search:       filter.GetSearch(),
+		SearchPrefix: filter.GetSearchPrefix(),
+		OrderBy:      orderBy,
+	}
+	if len(filter.GetAuthor()) > 0 {
+		query.Authors = append([]string{filter.GetAuthor()}, query.Authors...)
+	}
+	return query, nil
+}
+
+func (gs *GrpcServer) todoGetter(ctx context.Context, filter *pb.FilterRequest) (todoPage, error) {
+	query, err := toQueryFilter(filter)
+	if err != nil {
+		return todoPage{}, err
 	}
+	pg := 0
+	limit := 10
 	if filter.GetPage() > 0 {
 		pg = int(filter.GetPage())
 	}
@@ -36,77 +118,66 @@ func (gs *GrpcServer) todoGetter(filter *pb.FilterRequest) ([]*pb.DataResponse,
 		limit = int(filter.GetLimit())
 	}
 
-	var listOfData []*pb.DataResponse
-
-	res, _, err := gs.controller.GetTodos(query, uint(pg), uint(limit))
-	if err != nil {
-		return nil, err
+	var page todoPage
+	var res []model.TodoModel
+	if pg > 0 {
+		if res, err = gs.controller.GetTodos(ctx, query, uint(pg), uint(limit)); err != nil {
+			return todoPage{}, err
+		}
+	} else {
+		tp, err := gs.controller.GetTodosPage(ctx, query, filter.GetPageToken(), uint(limit))
+		if err != nil {
+			return todoPage{}, err
+		}
+		res = tp.Todos
+		page.NextPageToken = tp.NextPageToken
+		page.TotalSize = tp.TotalSize
 	}
 
 	for _, d := range res {
-		listOfData = append(listOfData, &pb.DataResponse{
-			Author:      d.Author,
-			Title:       d.Title,
-			Description: d.Description,
-			IsDone:      d.IsDone,
-			StartDate:   uint64(d.StartDate.Unix()),
-			EndDate:     uint64(d.EndDate.Unix()),
-			CreatedAt:   uint64(d.CreatedAt.Unix()),
-			UpdatedAt:   uint64(d.UpdatedAt.Unix()),
-			Id:          d.Id,
-		})
+		page.Value = append(page.Value, toDataResponse(d))
 	}
 
-	return listOfData, nil
+	return page, nil
 }
 
 func (gs *GrpcServer) GetTodo(ctx context.Context, filter *pb.FilterRequest) (*pb.ArrResponse, error) {
 	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - GetTodo ", filter)
 
-	lData, err := gs.todoGetter(filter)
+	page, err := gs.todoGetter(ctx, filter)
 	var eResp = pb.ErrorResponse{}
 	if err != nil {
-		eResp = pb.ErrorResponse{
-			Code:    500,
-			Message: err.Error(),
-			Details: nil,
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
 		}
+		eResp = errorEnvelope(ctx, err)
 	}
 
 	return &pb.ArrResponse{
-		IsOk:  err == nil,
-		Value: lData,
-		Error: &eResp,
+		IsOk:          err == nil,
+		Value:         page.Value,
+		Error:         &eResp,
+		NextPageToken: page.NextPageToken,
+		TotalSize:     page.TotalSize,
 	}, nil
 }
 
 func (gs *GrpcServer) GetOneTodo(ctx context.Context, id *pb.IdQuery) (*pb.Response, error) {
 	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - GetOneTodo ", id)
 
-	resp, status, err := gs.controller.GetTodo(id.GetId())
+	resp, err := gs.controller.GetTodo(ctx, id.GetId())
 
 	var eResp = pb.ErrorResponse{}
 	if err != nil {
-		eResp = pb.ErrorResponse{
-			Code:    uint32(status),
-			Message: err.Error(),
-			Details: nil,
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
 		}
+		eResp = errorEnvelope(ctx, err)
 	}
 
 	return &pb.Response{
-		IsOk: err == nil,
-		Value: &pb.DataResponse{
-			Author:      resp.Author,
-			Title:       resp.Title,
-			Description: resp.Description,
-			IsDone:      resp.IsDone,
-			StartDate:   uint64(resp.StartDate.Unix()),
-			EndDate:     uint64(resp.EndDate.Unix()),
-			CreatedAt:   uint64(resp.CreatedAt.Unix()),
-			UpdatedAt:   uint64(resp.UpdatedAt.Unix()),
-			Id:          resp.Id,
-		},
+		IsOk:  err == nil,
+		Value: toDataResponse(resp),
 		Error: &eResp,
 	}, nil
 }
@@ -116,16 +187,25 @@ func (gs *GrpcServer) GetStreamingTodo(
 	stream pb.StreamService_GetStreamingTodoServer,
 ) error {
 	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - GetStreamingTodo ", filter)
-	lData, err := gs.todoGetter(filter)
+	page, err := gs.todoGetter(stream.Context(), filter)
 	if err != nil {
+		if !gs.errorEnvelope {
+			return statusError(stream.Context(), err)
+		}
 		stream.Send(&pb.DataResponse{
-			Title: err.Error(),
+			Title: clientMessage(stream.Context(), err),
 			Id:    "Error",
 		})
-		return err
+		return nil
 	}
 
-	for _, d := range lData {
+	// the stream carries a single page, the trailer tells the client where the next one starts
+	stream.SetTrailer(metadata.Pairs(
+		"next-page-token", page.NextPageToken,
+		"total-size", strconv.FormatInt(page.TotalSize, 10),
+	))
+
+	for _, d := range page.Value {
 		if e := stream.Send(d); e != nil {
 			return e
 		}
@@ -134,39 +214,82 @@ func (gs *GrpcServer) GetStreamingTodo(
 	return nil
 }
 
+var eventTypes = map[string]pb.EventType{
+	database.EventCreated:  pb.EventType_CREATED,
+	database.EventUpdated:  pb.EventType_UPDATED,
+	database.EventDeleted:  pb.EventType_DELETED,
+	database.EventRestored: pb.EventType_RESTORED,
+}
+
+func (gs *GrpcServer) WatchTodos(
+	filter *pb.FilterRequest,
+	stream pb.StreamService_WatchTodosServer,
+) error {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - WatchTodos ", filter)
+
+	var after uint64
+	if len(filter.GetResumeToken()) > 0 {
+		var err error
+		after, err = strconv.ParseUint(filter.GetResumeToken(), 10, 64)
+		if err != nil {
+			return statusError(stream.Context(), database.ErrResumeExpired)
+		}
+	}
+
+	query, err := toQueryFilter(filter)
+	if err != nil {
+		return statusError(stream.Context(), err)
+	}
+	sub, err := gs.controller.WatchTodos(stream.Context(), after)
+	if err != nil {
+		return statusError(stream.Context(), err)
+	}
+	defer sub.Close()
+
+	// headers tell the client the subscription is live, later changes will be delivered
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-sub.Events:
+			if !ok {
+				return status.Error(codes.Unavailable, "watch fell behind, resume with the last resumeToken")
+			}
+			if !dto.MatchTodo(query, e.Todo) {
+				continue
+			}
+			if err := stream.Send(&pb.TodoEvent{
+				Type:        eventTypes[e.Type],
+				Value:       toDataResponse(e.Todo),
+				ResumeToken: strconv.FormatUint(e.Seq, 10),
+				OccurredAt:  uint64(e.At.Unix()),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func (gs *GrpcServer) AddTodo(ctx context.Context, data *pb.AddRequest) (*pb.Response, error) {
 	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - AddTodo ", data)
 
-	res, code, err := gs.controller.AddTodo(model.TodoModel{
-		Author:      data.GetAuthor(),
-		Title:       data.GetTitle(),
-		Description: data.GetDescription(),
-		StartDate:   time.Unix(int64(data.GetStartDate()), 0),
-		EndDate:     time.Unix(int64(data.GetEndDate()), 0),
-	})
+	res, err := gs.controller.AddTodo(ctx, fromAddRequest(data))
 
 	var eResp = pb.ErrorResponse{}
 	if err != nil {
-		eResp = pb.ErrorResponse{
-			Code:    uint32(code),
-			Message: err.Error(),
-			Details: nil,
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
 		}
+		eResp = errorEnvelope(ctx, err)
 	}
 
 	return &pb.Response{
-		IsOk: err == nil,
-		Value: &pb.DataResponse{
-			Author:      res.Author,
-			Title:       res.Title,
-			Description: res.Description,
-			IsDone:      res.IsDone,
-			StartDate:   uint64(res.StartDate.Unix()),
-			EndDate:     uint64(res.EndDate.Unix()),
-			CreatedAt:   uint64(res.CreatedAt.Unix()),
-			UpdatedAt:   uint64(res.UpdatedAt.Unix()),
-			Id:          res.Id,
-		},
+		IsOk:  err == nil,
+		Value: toDataResponse(res),
 		Error: &eResp,
 	}, nil
 }
@@ -174,37 +297,21 @@ func (gs *GrpcServer) AddTodo(ctx context.Context, data *pb.AddRequest) (*pb.Res
 func (gs *GrpcServer) EditTodo(ctx context.Context, data *pb.EditRequest) (*pb.Response, error) {
 	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - EditTodo ", data)
 
-	res, code, err := gs.controller.EditTodo(data.GetId().GetId(), model.TodoModel{
-		Author:      data.GetData().GetAuthor(),
-		Title:       data.GetData().GetTitle(),
-		Description: data.GetData().GetDescription(),
-		IsDone:      data.GetData().GetIsDone(),
-		StartDate:   time.Unix(int64(data.GetData().GetStartDate()), 0),
-		EndDate:     time.Unix(int64(data.GetData().GetEndDate()), 0),
-	})
+	edit := fromAddRequest(data.GetData())
+	edit.Version = data.GetExpectedVersion()
+	res, err := gs.controller.EditTodo(ctx, data.GetId().GetId(), edit, data.GetUpdateMask().GetPaths()...)
 
 	var eResp = pb.ErrorResponse{}
 	if err != nil {
-		eResp = pb.ErrorResponse{
-			Code:    uint32(code),
-			Message: err.Error(),
-			Details: nil,
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
 		}
+		eResp = errorEnvelope(ctx, err)
 	}
 
 	return &pb.Response{
-		IsOk: err == nil,
-		Value: &pb.DataResponse{
-			Author:      res.Author,
-			Title:       res.Title,
-			Description: res.Description,
-			IsDone:      res.IsDone,
-			StartDate:   uint64(res.StartDate.Unix()),
-			EndDate:     uint64(res.EndDate.Unix()),
-			CreatedAt:   uint64(res.CreatedAt.Unix()),
-			UpdatedAt:   uint64(res.UpdatedAt.Unix()),
-			Id:          res.Id,
-		},
+		IsOk:  err == nil,
+		Value: toDataResponse(res),
 		Error: &eResp,
 	}, nil
 }
@@ -212,30 +319,98 @@ func (gs *GrpcServer) EditTodo(ctx context.Context, data *pb.EditRequest) (*pb.R
 func (gs *GrpcServer) DeleteTodo(ctx context.Context, id *pb.IdQuery) (*pb.Response, error) {
 	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - DeleteTodo ", id.GetId())
 
-	res, code, err := gs.controller.DeleteTodo(id.GetId())
+	res, err := gs.controller.DeleteTodo(ctx, id.GetId(), id.GetExpectedVersion())
 
 	var eResp = pb.ErrorResponse{}
 	if err != nil {
-		eResp = pb.ErrorResponse{
-			Code:    uint32(code),
-			Message: err.Error(),
-			Details: nil,
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
 		}
+		eResp = errorEnvelope(ctx, err)
 	}
 
 	return &pb.Response{
-		IsOk: err == nil,
-		Value: &pb.DataResponse{
-			Author:      res.Author,
-			Title:       res.Title,
-			Description: res.Description,
-			IsDone:      res.IsDone,
-			StartDate:   uint64(res.StartDate.Unix()),
-			EndDate:     uint64(res.EndDate.Unix()),
-			CreatedAt:   uint64(res.CreatedAt.Unix()),
-			UpdatedAt:   uint64(res.UpdatedAt.Unix()),
-			Id:          res.Id,
-		},
+		IsOk:  err == nil,
+		Value: toDataResponse(res),
+		Error: &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) SearchTodos(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - SearchTodos ", req)
+
+	page, err := gs.controller.SearchTodos(ctx, req.GetQuery(), req.GetPageToken(), uint(req.GetLimit()))
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var hits []*pb.SearchHit
+	for _, h := range page.Hits {
+		hits = append(hits, &pb.SearchHit{
+			Value:   toDataResponse(h.Item),
+			Rank:    h.Rank,
+			Snippet: h.Snippet,
+		})
+	}
+
+	return &pb.SearchResponse{
+		IsOk:          err == nil,
+		Value:         hits,
+		Error:         &eResp,
+		NextPageToken: page.NextPageToken,
+		TotalSize:     page.TotalSize,
+	}, nil
+}
+
+func (gs *GrpcServer) ListDeletedTodos(ctx context.Context, filter *pb.FilterRequest) (*pb.ArrResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - ListDeletedTodos ", filter)
+
+	limit := uint32(10)
+	if filter.GetLimit() > 0 {
+		limit = filter.GetLimit()
+	}
+
+	res, err := gs.controller.ListDeletedTodos(ctx, uint(filter.GetPage()), uint(limit))
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var lData []*pb.DataResponse
+	for _, d := range res {
+		lData = append(lData, toDataResponse(d))
+	}
+
+	return &pb.ArrResponse{
+		IsOk:  err == nil,
+		Value: lData,
+		Error: &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) RestoreTodo(ctx context.Context, id *pb.IdQuery) (*pb.Response, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - RestoreTodo ", id.GetId())
+
+	res, err := gs.controller.RestoreTodo(ctx, id.GetId())
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	return &pb.Response{
+		IsOk:  err == nil,
+		Value: toDataResponse(res),
 		Error: &eResp,
 	}, nil
 }
@@ -0,0 +1,55 @@
+package grpc
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"todo_pikpo/config"
+	"todo_pikpo/controllers"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	pb "todo_pikpo/grpc/proto"
+	"todo_pikpo/request"
+
+	"github.com/stretchr/testify/assert"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeTodoStream struct {
+	ggrpc.ServerStream
+	ctx  context.Context
+	sent []*pb.DataResponse
+}
+
+func (f *fakeTodoStream) Context() context.Context      { return f.ctx }
+func (f *fakeTodoStream) SetTrailer(metadata.MD)        {}
+func (f *fakeTodoStream) Send(d *pb.DataResponse) error { f.sent = append(f.sent, d); return nil }
+
+func TestStreamingErrorEnvelope(t *testing.T) {
+	a := assert.New(t)
+
+	db, err := database.NewDatabase(config.ConfigApp{
+		DbDriver:   database.DriverSqlite,
+		SqlitePath: filepath.Join(t.TempDir(), "todo.db"),
+	})
+	if !a.NoError(err) {
+		return
+	}
+	cnt, err := controllers.CreateTodoController(&db)
+	if !a.NoError(err) {
+		return
+	}
+	gs := StartGrpc(&cnt)
+	gs.SetErrorEnvelope(true)
+
+	// a missing table is the kind of driver failure that must not reach the client
+	a.NoError(db.Postgres.Migrator().DropTable(&model.TodoModel{}))
+
+	stream := &fakeTodoStream{ctx: request.With(context.Background(), request.Info{Id: "req-1"})}
+	a.NoError(gs.GetStreamingTodo(&pb.FilterRequest{}, stream))
+	if a.Len(stream.sent, 1) {
+		a.Equal(stream.sent[0].GetId(), "Error")
+		a.Equal(stream.sent[0].GetTitle(), "internal error, request id req-1")
+	}
+}
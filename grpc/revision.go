@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"context"
+	"time"
+	pb "todo_pikpo/grpc/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (gs *GrpcServer) GetTodoHistory(ctx context.Context, id *pb.IdQuery) (*pb.HistoryResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - GetTodoHistory ", id.GetId())
+
+	res, err := gs.controller.GetTodoHistory(ctx, id.GetId())
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var revisions []*pb.Revision
+	for _, r := range res {
+		revisions = append(revisions, &pb.Revision{
+			Version: r.Version,
+			Value:   toDataResponse(r.Todo()),
+			Actor:   r.Actor,
+			Changes: toFieldChanges(r.Changes),
+		})
+	}
+	return &pb.HistoryResponse{
+		IsOk:  err == nil,
+		Value: revisions,
+		Error: &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) RevertTodo(ctx context.Context, data *pb.RevertRequest) (*pb.Response, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - RevertTodo ", data.GetId(), " to version ", data.GetVersion())
+
+	res, err := gs.controller.RevertTodo(ctx, data.GetId(), data.GetVersion(), data.GetExpectedVersion())
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	return &pb.Response{
+		IsOk:  err == nil,
+		Value: toDataResponse(res),
+		Error: &eResp,
+	}, nil
+}
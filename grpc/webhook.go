@@ -0,0 +1,99 @@
+package grpc
+
+import (
+	"context"
+	"time"
+	"todo_pikpo/controllers"
+	model "todo_pikpo/database/models"
+	pb "todo_pikpo/grpc/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func toWebhook(w controllers.Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:        w.Id,
+		Url:       w.Url,
+		Events:    w.EventList(),
+		CreatedAt: uint64(w.CreatedAt.Unix()),
+		Pending:   w.Pending,
+		Dead:      w.Dead,
+	}
+}
+
+func (gs *GrpcServer) webhookResponse(ctx context.Context, res model.WebhookModel, secret string, err error) (*pb.WebhookResponse, error) {
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	return &pb.WebhookResponse{
+		IsOk:   err == nil,
+		Value:  toWebhook(controllers.Webhook{WebhookModel: res}),
+		Error:  &eResp,
+		Secret: secret,
+	}, nil
+}
+
+func (gs *GrpcServer) RegisterWebhook(ctx context.Context, data *pb.RegisterWebhookRequest) (*pb.WebhookResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - RegisterWebhook ", data.GetUrl())
+
+	res, secret, err := gs.controller.RegisterWebhook(ctx, data.GetUrl(), data.GetEvents())
+	return gs.webhookResponse(ctx, res, secret, err)
+}
+
+func (gs *GrpcServer) ListWebhooks(ctx context.Context, _ *pb.ListWebhooksRequest) (*pb.WebhooksResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - ListWebhooks ")
+
+	res, err := gs.controller.ListWebhooks(ctx)
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	var hooks []*pb.Webhook
+	for _, w := range res {
+		hooks = append(hooks, toWebhook(w))
+	}
+	return &pb.WebhooksResponse{
+		IsOk:  err == nil,
+		Value: hooks,
+		Error: &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) TestWebhook(ctx context.Context, id *pb.IdQuery) (*pb.TestWebhookResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - TestWebhook ", id.GetId())
+
+	res, err := gs.controller.TestWebhook(ctx, id.GetId())
+
+	var eResp = pb.ErrorResponse{}
+	if err != nil {
+		if !gs.errorEnvelope {
+			return nil, statusError(ctx, err)
+		}
+		eResp = errorEnvelope(ctx, err)
+	}
+
+	return &pb.TestWebhookResponse{
+		IsOk:       err == nil,
+		Delivered:  res.Delivered,
+		StatusCode: uint32(res.StatusCode),
+		Failure:    res.Failure,
+		Error:      &eResp,
+	}, nil
+}
+
+func (gs *GrpcServer) DeleteWebhook(ctx context.Context, id *pb.IdQuery) (*pb.WebhookResponse, error) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " grpc - DeleteWebhook ", id.GetId())
+
+	res, err := gs.controller.DeleteWebhook(ctx, id.GetId())
+	return gs.webhookResponse(ctx, res, "", err)
+}
@@ -0,0 +1,20 @@
+package _interface
+
+import (
+	"context"
+	"time"
+	model "todo_pikpo/database/models"
+)
+
+// ApiKeyInterface stores API keys. Create, List and Revoke act on the workspace bound to ctx,
+// Lookup and Touch authenticate callers before any workspace is known and span every workspace.
+type ApiKeyInterface interface {
+	Create(ctx context.Context, key model.ApiKeyModel) (model.ApiKeyModel, error)
+	// List returns the keys of the workspace, revoked ones included, oldest first.
+	List(ctx context.Context) ([]model.ApiKeyModel, error)
+	// Revoke makes the key stop working at the given time, a key revoked earlier keeps its time.
+	Revoke(ctx context.Context, id string, at time.Time) (model.ApiKeyModel, error)
+	Lookup(ctx context.Context, id string) (model.ApiKeyModel, error)
+	// Touch records that the key was used at the given time.
+	Touch(ctx context.Context, id string, at time.Time) error
+}
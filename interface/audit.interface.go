@@ -0,0 +1,24 @@
+package _interface
+
+import (
+	"context"
+	"time"
+	model "todo_pikpo/database/models"
+)
+
+// AuditFilter narrows the audit log, zero fields match every event.
+type AuditFilter struct {
+	TodoId string `json:"todoId,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+	// From and To are inclusive bounds of the time of the event.
+	From time.Time `json:"from,omitempty"`
+	To   time.Time `json:"to,omitempty"`
+}
+
+// AuditInterface reads the audit log of the workspace bound to ctx. Events are only written by
+// the todo DtoInterface, in the transaction of the change they record.
+type AuditInterface interface {
+	// List returns up to pageSize events matching filter, newest first, that come after the cursor.
+	// Only the CreatedAt and Id of the cursor are used.
+	List(ctx context.Context, filter AuditFilter, after Cursor, pageSize uint) ([]model.AuditEventModel, error)
+}
@@ -1,9 +1,54 @@
 package _interface
 
+import (
+	"context"
+	"time"
+)
+
+// Cursor is the position of a row in a sorted list, it holds every sortable column of that row
+// so the list can continue whatever OrderBy it uses. The zero value is the start.
+type Cursor struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	StartDate time.Time
+	EndDate   time.Time
+	Title     string
+	Id        string
+}
+
+// SearchHit is one full text search result, Snippet marks the matched words with <b></b>.
+type SearchHit[T any] struct {
+	Item    T       `json:"item"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// DtoInterface only reads and writes the records of the workspace bound to ctx, see package tenant.
 type DtoInterface[T any] interface {
-	GetMany(filter map[string]interface{}, page uint, pageSize uint) ([]T, error)
-	GetSingle(id string) (T, error)
-	Create(data T) (T, error)
-	Update(id string, data T) (T, error)
-	Delete(id string) (T, error)
+	GetMany(ctx context.Context, filter TodoQueryFilter, page uint, pageSize uint) ([]T, error)
+	// GetAfter returns up to pageSize records in filter.OrderBy order that come after the cursor.
+	GetAfter(ctx context.Context, filter TodoQueryFilter, after Cursor, pageSize uint) ([]T, error)
+	Count(ctx context.Context, filter TodoQueryFilter) (int64, error)
+	// Search ranks records against a free text query, best match first, and reports how many match in total.
+	Search(ctx context.Context, query string, offset uint, pageSize uint) ([]SearchHit[T], int64, error)
+	GetSingle(ctx context.Context, id string) (T, error)
+	Create(ctx context.Context, data T) (T, error)
+	// Update and Delete only apply while the stored version still equals the given one,
+	// version 0 skips the check.
+	Update(ctx context.Context, id string, data T) (T, error)
+	// Delete moves the record to the trash, GetMany and GetSingle no longer see it.
+	Delete(ctx context.Context, id string, version uint64) (T, error)
+	// GetDeleted lists the trash, most recently deleted first. A non empty author only lists
+	// the records of that author.
+	GetDeleted(ctx context.Context, author string, page uint, pageSize uint) ([]T, error)
+	// GetSingleDeleted returns one record of the trash.
+	GetSingleDeleted(ctx context.Context, id string) (T, error)
+	// Restore takes a record out of the trash.
+	Restore(ctx context.Context, id string) (T, error)
+	// Purge permanently removes up to limit records trashed before the given time, oldest first,
+	// in one transaction and reports how many. It is maintenance work and spans every workspace.
+	Purge(ctx context.Context, before time.Time, limit uint) (int64, error)
+	// Transaction runs fn against a DtoInterface bound to one transaction,
+	// an error returned by fn rolls back every write made through it.
+	Transaction(ctx context.Context, fn func(tx DtoInterface[T]) error) error
 }
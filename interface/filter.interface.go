@@ -1,7 +1,36 @@
 package _interface
 
+import "time"
+
+// SortKey orders a list by one column: end_date, start_date, created_at, updated_at or title.
+type SortKey struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+// TodoQueryFilter narrows a todo list, every zero valued field is left out of the query
+// and the fields that are set all have to match.
 type TodoQueryFilter struct {
-	Title  string
-	Author string
-	IsDone bool
+	// Authors matches any of the given authors exactly.
+	Authors []string `json:"authors,omitempty"`
+	// Title matches the whole title exactly.
+	Title string `json:"title,omitempty"`
+	// IsDone is tri-state, nil lists done and open todos alike.
+	IsDone *bool `json:"isDone,omitempty"`
+
+	// StartFrom, StartTo, EndFrom and EndTo are inclusive bounds on StartDate and EndDate.
+	StartFrom time.Time `json:"startFrom,omitempty"`
+	StartTo   time.Time `json:"startTo,omitempty"`
+	EndFrom   time.Time `json:"endFrom,omitempty"`
+	EndTo     time.Time `json:"endTo,omitempty"`
+	// Overdue only keeps open todos whose EndDate has passed.
+	Overdue bool `json:"overdue,omitempty"`
+
+	// Search matches title or description case-insensitively, anywhere in the text
+	// or, with SearchPrefix, only at its start.
+	Search       string `json:"search,omitempty"`
+	SearchPrefix bool   `json:"searchPrefix,omitempty"`
+
+	// OrderBy sorts the list key by key, ties and an empty OrderBy fall back to created_at then id.
+	OrderBy []SortKey `json:"orderBy,omitempty"`
 }
@@ -0,0 +1,15 @@
+package _interface
+
+import (
+	"context"
+	model "todo_pikpo/database/models"
+)
+
+// RevisionInterface reads the revisions of the todos in the workspace bound to ctx, the todo
+// DtoInterface stores one with every create and update, in the same transaction.
+type RevisionInterface interface {
+	// List returns every revision of the todo, oldest first.
+	List(ctx context.Context, todoId string) ([]model.TodoRevisionModel, error)
+	// Get returns the revision of one version, gorm.ErrRecordNotFound when there is none.
+	Get(ctx context.Context, todoId string, version uint64) (model.TodoRevisionModel, error)
+}
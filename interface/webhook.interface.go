@@ -0,0 +1,33 @@
+package _interface
+
+import (
+	"context"
+	"time"
+	model "todo_pikpo/database/models"
+)
+
+// WebhookInterface stores webhooks and their deliveries. Create, List, Get, Delete and Backlog act on
+// the workspace bound to ctx, the rest serves the dispatcher and spans every workspace. Outbox events
+// are only written by the todo DtoInterface, in the transaction of the change they announce.
+type WebhookInterface interface {
+	Create(ctx context.Context, hook model.WebhookModel) (model.WebhookModel, error)
+	// List returns the webhooks of the workspace, oldest first.
+	List(ctx context.Context) ([]model.WebhookModel, error)
+	Get(ctx context.Context, id string) (model.WebhookModel, error)
+	// Delete removes a webhook together with the deliveries it still has.
+	Delete(ctx context.Context, id string) (model.WebhookModel, error)
+	// Backlog counts the deliveries of a webhook per status.
+	Backlog(ctx context.Context, id string) (map[string]int64, error)
+
+	// Outbox returns up to limit events that were not handed to any webhook yet, oldest first.
+	Outbox(ctx context.Context, limit uint) ([]model.OutboxEventModel, error)
+	// Fanout stores the deliveries of an event and takes the event out of the outbox in one
+	// transaction, an event another dispatcher took already is left alone.
+	Fanout(ctx context.Context, event model.OutboxEventModel, deliveries []model.WebhookDeliveryModel) error
+	// Due leases up to limit pending deliveries whose next attempt is not after now, oldest first. They
+	// get a new LeaseId and are not due again before until, so no two dispatchers take the same delivery.
+	Due(ctx context.Context, now time.Time, until time.Time, limit uint) ([]model.WebhookDeliveryModel, error)
+	// SaveDelivery stores the outcome of an attempt and ends its lease, delivered ones are removed. It
+	// fails when the delivery no longer holds the lease it was taken with.
+	SaveDelivery(ctx context.Context, delivery model.WebhookDeliveryModel, delivered bool) error
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"time"
+	"todo_pikpo/certs"
 	"todo_pikpo/config"
 	"todo_pikpo/controllers"
 	"todo_pikpo/database"
 	myGrpc "todo_pikpo/grpc"
+	"todo_pikpo/rest"
 
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	pb "todo_pikpo/grpc/proto"
 	midw "todo_pikpo/middleware"
@@ -40,20 +46,92 @@ func main() {
 		panic(err)
 	}
 
+	policy, err := config.LoadPolicy(conf.PolicyFile)
+	if err != nil {
+		log.Error("something wrong while loading app access policy -> ", err)
+		panic(err)
+	}
+	ctrl.SetPolicy(policy)
+	ctrl.SetDailyQuota(conf.DailyQuota)
+	ctrl.SetWebhookAttempts(conf.WebhookRetries)
+	ctrl.SetWebhookPrivateHosts(conf.WebhookPrivate)
+
+	if conf.PurgeInterval > 0 {
+		go ctrl.RunPurge(
+			context.Background(),
+			time.Duration(conf.PurgeInterval)*time.Second,
+			time.Duration(conf.TrashRetention)*time.Hour,
+		)
+	}
+
+	if conf.WebhookEvery > 0 {
+		go ctrl.RunWebhooks(context.Background(), time.Duration(conf.WebhookEvery)*time.Second)
+	}
+
 	gService := myGrpc.StartGrpc(&ctrl)
+	gService.SetErrorEnvelope(conf.ErrorEnvelope)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.Port))
 	if err != nil {
 		panic(err)
 	}
 
-	mdl := midw.NewMiddleware(conf)
-	s := grpc.NewServer(
-		grpc.UnaryInterceptor(mdl.UnaryAuth),
-		grpc.StreamInterceptor(mdl.StreamAuth),
-	)
+	mdl, err := midw.NewMiddleware(conf)
+	if err != nil {
+		log.Error("something wrong while loading app authentication -> ", err)
+		panic(err)
+	}
+	// the controller and the middleware share the policy read once above
+	mdl.SetPolicy(policy)
+	mdl.SetApiKeys(&ctrl)
+
+	limiter, err := midw.NewRateLimiter(conf, db.Limits)
+	if err != nil {
+		log.Error("something wrong while loading app rate limits -> ", err)
+		panic(err)
+	}
+
+	reloader, err := certs.NewReloader(conf)
+	if err != nil {
+		log.Error("something wrong while loading app TLS certificates -> ", err)
+		panic(err)
+	}
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(mdl.UnaryAuth, limiter.UnaryLimit),
+		grpc.ChainStreamInterceptor(mdl.StreamAuth, limiter.StreamLimit),
+	}
+	if reloader != nil {
+		go func() {
+			if err := reloader.Watch(context.Background()); err != nil {
+				log.Error("TLS certificates will not be reloaded -> ", err)
+			}
+		}()
+		options = append(options, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+	}
+	s := grpc.NewServer(options...)
 	pb.RegisterTodoServiceServer(s, &gService)
 	pb.RegisterStreamServiceServer(s, &gService)
+	pb.RegisterKeyServiceServer(s, &gService)
+	pb.RegisterAuditServiceServer(s, &gService)
+	pb.RegisterWebhookServiceServer(s, &gService)
+
+	if conf.HttpPort > 0 {
+		rService := rest.StartRest(&ctrl)
+		go func() {
+			log.Printf("ToDo Service started with HTTP on port %d\n", conf.HttpPort)
+			server := &http.Server{Addr: fmt.Sprintf(":%d", conf.HttpPort), Handler: mdl.HttpAuth(limiter.HttpLimit(rService))}
+			var err error
+			if reloader != nil {
+				server.TLSConfig = reloader.TLSConfig()
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
 
 	log.Printf("ToDo Service started with gRPC on port %d\n", conf.Port)
 	if err = s.Serve(lis); err != nil {
@@ -2,19 +2,201 @@ package middleware
 
 import (
 	"context"
-	"errors"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
+	"todo_pikpo/auth"
 	"todo_pikpo/config"
+	"todo_pikpo/controllers"
+	model "todo_pikpo/database/models"
+	"todo_pikpo/request"
+	"todo_pikpo/tenant"
 
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// requestIdHeader carries the request id in gRPC metadata and HTTP headers alike.
+const requestIdHeader = "x-request-id"
+
+// errUnauthenticated is returned for every rejected caller, why it was rejected is only logged.
+var errUnauthenticated = status.Error(codes.Unauthenticated, "authorization was wrong")
+
+// ApiKeyVerifier resolves the stored API key of a bearer key, see controllers.TodoController.VerifyApiKey.
+type ApiKeyVerifier interface {
+	VerifyApiKey(ctx context.Context, token string) (model.ApiKeyModel, error)
+}
+
+// staticKey is a KEY or TENANT_KEYS entry, only its digest is kept so every comparison takes as long.
+type staticKey struct {
+	digest   [sha256.Size]byte
+	tenantId string
+}
+
 type Middleware struct {
 	conf config.ConfigApp
+	// static holds every accepted static bearer key with the workspace it acts on
+	static []staticKey
+	// keys is nil until SetApiKeys, API keys are refused without it
+	keys ApiKeyVerifier
+	// jwt is nil when no JWT_SECRET or JWT_JWKS_FILE is configured
+	jwt *jwtVerifier
+	// policy maps roles to the methods they may call
+	policy config.Policy
+}
+
+// SetApiKeys turns on API key authentication.
+func (m *Middleware) SetApiKeys(keys ApiKeyVerifier) {
+	m.keys = keys
+}
+
+// SetPolicy replaces the default access policy, see config.LoadPolicy.
+func (m *Middleware) SetPolicy(policy config.Policy) {
+	m.policy = policy
+}
+
+// staticTenant returns the workspace of a static key, comparing token against every key in constant time.
+func (m Middleware) staticTenant(token string) (string, bool) {
+	digest := sha256.Sum256([]byte(token))
+	tenantId, found := "", false
+	for _, key := range m.static {
+		if subtle.ConstantTimeCompare(digest[:], key.digest[:]) == 1 {
+			tenantId, found = key.tenantId, true
+		}
+	}
+	return tenantId, found
+}
+
+// clientCert returns the verified client certificate of the connection behind ctx, nil without mTLS.
+func clientCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// authorize checks the caller and returns ctx bound to the caller's workspace and claims.
+// A verified client certificate is recorded in the claims and, without an authorization
+// header, identifies the caller by itself when the policy gives certificates a role.
+func (m Middleware) authorize(ctx context.Context, authVal []string) (context.Context, error) {
+	cert := clientCert(ctx)
+
+	var claims auth.Claims
+	var err error
+	if len(authVal) == 0 && cert != nil && m.policy.CertRole != "" {
+		claims = auth.Claims{Subject: cert.Subject.CommonName, Roles: []string{m.policy.CertRole}}
+		if len(cert.Subject.Organization) > 0 {
+			claims.Tenant = cert.Subject.Organization[0]
+		}
+	} else if claims, err = m.bearer(ctx, authVal); err != nil {
+		return nil, err
+	}
+
+	if cert != nil {
+		claims.ClientCert = cert.Subject.CommonName
+	}
+	return tenant.With(auth.WithClaims(ctx, claims), claims.Tenant), nil
+}
+
+// bearer checks the bearer token of the authorization header. Static keys get the policy's key
+// role and no subject, API keys the roles of their scopes and auth.KeySubject of their id as subject.
+// Bearer tokens are never logged.
+func (m Middleware) bearer(ctx context.Context, authVal []string) (auth.Claims, error) {
+	if len(authVal) == 0 {
+		log.Errorf("%s please provide authorization bearer key\n", time.Now().Format("2006-01-02 15:04:05"))
+		return auth.Claims{}, errUnauthenticated
+	}
+
+	token, ok := strings.CutPrefix(authVal[0], "Bearer ")
+	if !ok {
+		log.Errorf("%s authorization is not a bearer token\n", time.Now().Format("2006-01-02 15:04:05"))
+		return auth.Claims{}, errUnauthenticated
+	}
+	if tenantId, known := m.staticTenant(token); known {
+		return auth.Claims{Tenant: tenantId, Roles: []string{m.policy.KeyRole}}, nil
+	}
+	if controllers.IsApiKey(token) && m.keys != nil {
+		key, err := m.keys.VerifyApiKey(ctx, token)
+		if err != nil {
+			log.Errorf("%s api key rejected -> %s\n", time.Now().Format("2006-01-02 15:04:05"), err)
+			return auth.Claims{}, errUnauthenticated
+		}
+		return auth.Claims{Subject: auth.KeySubject(key.Id), Tenant: key.TenantId, Roles: m.policy.ScopeRoles(key.ScopeList()), KeyId: key.Id}, nil
+	}
+	if m.jwt == nil {
+		log.Errorf("%s authorization was wrong, bearer token is not a known key\n", time.Now().Format("2006-01-02 15:04:05"))
+		return auth.Claims{}, errUnauthenticated
+	}
+
+	claims, err := m.jwt.verify(token)
+	if err != nil {
+		log.Errorf("%s bearer token rejected -> %s\n", time.Now().Format("2006-01-02 15:04:05"), err)
+		return auth.Claims{}, errUnauthenticated
+	}
+	if len(claims.Roles) == 0 {
+		claims.Roles = []string{m.policy.TokenRole}
+	}
+	return claims, nil
+}
+
+// permit checks that the caller authorized in ctx holds a role the policy requires for fullMethod.
+func (m Middleware) permit(ctx context.Context, fullMethod string) error {
+	claims, _ := auth.ClaimsFrom(ctx)
+	if !m.policy.Allows(claims.Roles, fullMethod) {
+		log.Errorf("%s %s denied to roles %v\n", time.Now().Format("2006-01-02 15:04:05"), fullMethod, claims.Roles)
+		return status.Errorf(codes.PermissionDenied, "%s is not allowed for this caller", fullMethod)
+	}
+	return nil
+}
+
+// validRequestId accepts ids of up to 128 letters, digits and -_.: so they are safe to log and store.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequest binds method and the caller's request id to ctx, a new id replaces a missing or unusable one.
+func withRequest(ctx context.Context, ids []string, method string) context.Context {
+	var id string
+	if len(ids) > 0 && validRequestId(ids[0]) {
+		id = ids[0]
+	} else {
+		id = uuid.New().String()
+	}
+	return request.With(ctx, request.Info{Id: id, Method: method})
+}
+
+// authStream is a server stream whose context carries the caller's workspace and claims.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authStream) Context() context.Context {
+	return s.ctx
 }
 
 func (m Middleware) UnaryAuth(
@@ -25,19 +207,18 @@ func (m Middleware) UnaryAuth(
 ) (interface{}, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return nil, errors.New("metadata is not provided")
+		return nil, status.Error(codes.Unauthenticated, "metadata is not provided")
 	}
 
-	authVal := md["authorization"]
+	ctx = withRequest(ctx, md[requestIdHeader], info.FullMethod)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIdHeader, request.From(ctx).Id))
 
-	if len(authVal) == 0 {
-		log.Errorf("%s please provide authorization bearer key\n", time.Now().Format("2006-01-02 15:04:05"))
-		return nil, errors.New("authorization was wrong")
+	ctx, err := m.authorize(ctx, md["authorization"])
+	if err != nil {
+		return nil, err
 	}
-
-	if len(authVal) > 0 && authVal[0] != fmt.Sprintf("Bearer %s", m.conf.EncryptKey) {
-		log.Errorf("%s authorization was wrong -> %s\n", time.Now().Format("2006-01-02 15:04:05"), authVal[0])
-		return nil, errors.New("authorization was wrong")
+	if err := m.permit(ctx, info.FullMethod); err != nil {
+		return nil, err
 	}
 	return handler(ctx, req)
 }
@@ -51,19 +232,95 @@ func (m Middleware) StreamAuth(
 
 	md, ok := metadata.FromIncomingContext(stream.Context())
 	if !ok {
-		return errors.New("metadata is not provided")
+		return status.Error(codes.Unauthenticated, "metadata is not provided")
 	}
-	authVal := md["authorization"]
-	if len(authVal) == 0 || (len(authVal) > 0 && authVal[0] != fmt.Sprintf("Bearer %s", m.conf.EncryptKey)) {
-		log.Errorf("%s authorization was wrong -> %s\n", time.Now().Format("2006-01-02 15:04:05"), authVal[0])
-		return errors.New("authorization was wrong")
+	ctx := withRequest(stream.Context(), md[requestIdHeader], info.FullMethod)
+	_ = stream.SetHeader(metadata.Pairs(requestIdHeader, request.From(ctx).Id))
+
+	ctx, err := m.authorize(ctx, md["authorization"])
+	if err != nil {
+		return err
+	}
+	if err := m.permit(ctx, info.FullMethod); err != nil {
+		return err
 	}
 
-	return handler(srv, stream)
+	return handler(srv, authStream{ServerStream: stream, ctx: ctx})
 }
 
-func NewMiddleware(conf config.ConfigApp) Middleware {
-	return Middleware{
-		conf: conf,
+// rpcRouter is implemented by HTTP handlers whose routes mirror gRPC methods.
+type rpcRouter interface {
+	// FullMethod returns the gRPC method r stands for, empty when r matches no route.
+	FullMethod(r *http.Request) string
+}
+
+// HttpAuth applies the same bearer key check to the HTTP/JSON gateway, and the policy of
+// the mirrored RPC when next is an rpcRouter.
+func (m Middleware) HttpAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := ""
+		if router, ok := next.(rpcRouter); ok {
+			method = router.FullMethod(r)
+		}
+		ctx := withRequest(r.Context(), r.Header.Values(requestIdHeader), method)
+		w.Header().Set(requestIdHeader, request.From(ctx).Id)
+
+		if r.TLS != nil {
+			// the TLS state is handed over like a gRPC peer, so client certificates count the same
+			ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *r.TLS}})
+		}
+		ctx, err := m.authorize(ctx, r.Header.Values("Authorization"))
+		if err != nil {
+			writeHttpError(w, http.StatusUnauthorized, status.Convert(err).Message())
+			return
+		}
+		if method != "" {
+			if err := m.permit(ctx, method); err != nil {
+				writeHttpError(w, http.StatusForbidden, status.Convert(err).Message())
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func writeHttpError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(w, "{\"code\":%d,\"message\":%q}\n", code, message)
+}
+
+// parseTenantKeys reads TENANT_KEYS, a comma separated list of tenant:key pairs.
+// The shared KEY, when set, keeps working and belongs to tenant.Default.
+func parseTenantKeys(conf config.ConfigApp) []staticKey {
+	var keys []staticKey
+	if conf.EncryptKey != "" {
+		keys = append(keys, staticKey{digest: sha256.Sum256([]byte(conf.EncryptKey)), tenantId: tenant.Default})
+	}
+	for i, pair := range strings.Split(conf.TenantKeys, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		tenantId, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || tenantId == "" || key == "" {
+			// the entry may hold a key, only its position is logged
+			log.Warnf("%s TENANT_KEYS entry %d is not tenant:key, skipped\n", time.Now().Format("2006-01-02 15:04:05"), i+1)
+			continue
+		}
+		keys = append(keys, staticKey{digest: sha256.Sum256([]byte(key)), tenantId: tenantId})
 	}
+	return keys
+}
+
+func NewMiddleware(conf config.ConfigApp) (Middleware, error) {
+	verifier, err := newJwtVerifier(conf)
+	if err != nil {
+		return Middleware{}, err
+	}
+	return Middleware{
+		conf:   conf,
+		static: parseTenantKeys(conf),
+		jwt:    verifier,
+		policy: config.DefaultPolicy(),
+	}, nil
 }
@@ -0,0 +1,367 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"todo_pikpo/auth"
+	"todo_pikpo/config"
+	model "todo_pikpo/database/models"
+	"todo_pikpo/request"
+	"todo_pikpo/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func writeJwks(t *testing.T, kid string, key *rsa.PublicKey) string {
+	doc := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	raw, _ := json.Marshal(doc)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims tokenClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthorize(t *testing.T) {
+	a := assert.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Equal(err, nil)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Equal(err, nil)
+
+	m, err := NewMiddleware(config.ConfigApp{
+		EncryptKey:  "sharedkey",
+		TenantKeys:  "acme:acmekey",
+		JwtSecret:   "jwtsecret",
+		JwtJwksFile: writeJwks(t, "k1", &rsaKey.PublicKey),
+		JwtIssuer:   "pikpo",
+	})
+	a.Equal(err, nil)
+
+	valid := func(sub string, tenantId string) tokenClaims {
+		return tokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   sub,
+				Issuer:    "pikpo",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Tenant: tenantId,
+		}
+	}
+	expired := valid("james", "")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	noExpiry := valid("james", "")
+	noExpiry.ExpiresAt = nil
+	wrongIssuer := valid("james", "")
+	wrongIssuer.Issuer = "someone else"
+	viewer := valid("james", "")
+	viewer.Roles = []string{"viewer"}
+
+	for _, tc := range []struct {
+		name    string
+		header  string
+		ok      bool
+		subject string
+		tenant  string
+	}{
+		{"shared key", "Bearer sharedkey", true, "", tenant.Default},
+		{"tenant key", "Bearer acmekey", true, "", "acme"},
+		{"roles claim", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", viewer), true, "james", tenant.Default},
+		{"unknown key", "Bearer nope", false, "", ""},
+		{"no bearer prefix", "sharedkey", false, "", ""},
+		{"hs256", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", valid("james", "acme")), true, "james", "acme"},
+		{"rs256", "Bearer " + sign(t, jwt.SigningMethodRS256, rsaKey, "k1", valid("robert", "")), true, "robert", tenant.Default},
+		{"rs256 unknown kid", "Bearer " + sign(t, jwt.SigningMethodRS256, rsaKey, "k2", valid("robert", "")), false, "", ""},
+		{"rs256 foreign key", "Bearer " + sign(t, jwt.SigningMethodRS256, otherKey, "k1", valid("robert", "")), false, "", ""},
+		{"wrong secret", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("guess"), "", valid("james", "")), false, "", ""},
+		{"expired", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", expired), false, "", ""},
+		{"no expiry", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", noExpiry), false, "", ""},
+		{"wrong issuer", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", wrongIssuer), false, "", ""},
+		{"no subject", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", valid("", "")), false, "", ""},
+		{"api key subject", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", valid("apikey:1", "")), false, "", ""},
+		{"alg none", "Bearer " + sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", valid("james", "")), false, "", ""},
+	} {
+		ctx, err := m.authorize(context.Background(), []string{tc.header})
+		if !tc.ok {
+			a.Equal(status.Code(err), codes.Unauthenticated, tc.name)
+			continue
+		}
+		a.Equal(err, nil, tc.name)
+		a.Equal(tenant.From(ctx), tc.tenant, tc.name)
+		claims, ok := auth.ClaimsFrom(ctx)
+		a.Equal(ok, true, tc.name)
+		a.Equal(claims.Subject, tc.subject, tc.name)
+		a.Equal(len(claims.Roles), 1, tc.name)
+	}
+
+	// static keys get the key role, tokens without roles the token role
+	ctx, err := m.authorize(context.Background(), []string{"Bearer sharedkey"})
+	a.Equal(err, nil)
+	claims, _ := auth.ClaimsFrom(ctx)
+	a.Equal(claims.Roles, []string{"admin"})
+	ctx, err = m.authorize(context.Background(), []string{"Bearer " + sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", valid("james", ""))})
+	a.Equal(err, nil)
+	claims, _ = auth.ClaimsFrom(ctx)
+	a.Equal(claims.Roles, []string{"editor"})
+
+	_, err = m.authorize(context.Background(), nil)
+	a.Equal(status.Code(err), codes.Unauthenticated)
+}
+
+// routes mirrors every HTTP request onto one gRPC method, like the REST gateway does.
+type routes string
+
+func (rt routes) FullMethod(r *http.Request) string {
+	return string(rt)
+}
+
+func (rt routes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestPermit(t *testing.T) {
+	a := assert.New(t)
+
+	m, err := NewMiddleware(config.ConfigApp{EncryptKey: "sharedkey", JwtSecret: "jwtsecret"})
+	a.Equal(err, nil)
+
+	as := func(roles ...string) context.Context {
+		return auth.WithClaims(context.Background(), auth.Claims{Subject: "james", Roles: roles})
+	}
+	a.Equal(m.permit(as("viewer"), "/todoproto.TodoService/GetTodo"), nil)
+	a.Equal(status.Code(m.permit(as("viewer"), "/todoproto.TodoService/DeleteTodo")), codes.PermissionDenied)
+	a.Equal(m.permit(as("editor"), "/todoproto.TodoService/DeleteTodo"), nil)
+	a.Equal(m.permit(as("admin"), "/todoproto.TodoService/GetTodo"), nil)
+	// unlisted methods fall back to "*"
+	a.Equal(m.permit(as("admin"), "/todoproto.TodoService/Unlisted"), nil)
+	a.NotEqual(m.permit(as("editor"), "/todoproto.TodoService/Unlisted"), nil)
+	a.NotEqual(m.permit(as("intern"), "/todoproto.TodoService/GetTodo"), nil)
+	a.NotEqual(m.permit(context.Background(), "/todoproto.TodoService/GetTodo"), nil)
+
+	// the HTTP gateway applies the rule of the mirrored RPC
+	viewer := sign(t, jwt.SigningMethodHS256, []byte("jwtsecret"), "", tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "james", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		Roles:            []string{"viewer"},
+	})
+	for method, code := range map[string]int{
+		"/todoproto.TodoService/GetTodo":    http.StatusOK,
+		"/todoproto.TodoService/DeleteTodo": http.StatusForbidden,
+		"":                                  http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		req.Header.Set("Authorization", "Bearer "+viewer)
+		rec := httptest.NewRecorder()
+		m.HttpAuth(routes(method)).ServeHTTP(rec, req)
+		a.Equal(rec.Code, code, method)
+	}
+
+	// a policy file replaces the default one
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	a.Equal(os.WriteFile(path, []byte("keyRole: reader\nmethods:\n  \"*\": reader\n"), 0o600), nil)
+	policy, err := config.LoadPolicy(path)
+	a.Equal(err, nil)
+	m, err = NewMiddleware(config.ConfigApp{EncryptKey: "sharedkey"})
+	a.Equal(err, nil)
+	m.SetPolicy(policy)
+	ctx, err := m.authorize(context.Background(), []string{"Bearer sharedkey"})
+	a.Equal(err, nil)
+	a.Equal(m.permit(ctx, "/todoproto.TodoService/DeleteTodo"), nil)
+}
+
+// fakeKeys knows a single API key, and pk_2_secret that was revoked.
+type fakeKeys struct{}
+
+func (fakeKeys) VerifyApiKey(ctx context.Context, token string) (model.ApiKeyModel, error) {
+	if token == "pk_2_secret" {
+		return model.ApiKeyModel{}, errors.New("api key 2 is expired or revoked")
+	}
+	if token != "pk_1_secret" {
+		return model.ApiKeyModel{}, errors.New("api key was wrong")
+	}
+	return model.ApiKeyModel{Id: "1", Name: "deploy", TenantId: "acme", Scopes: "read,write"}, nil
+}
+
+func TestAuthorizeApiKey(t *testing.T) {
+	a := assert.New(t)
+
+	m, err := NewMiddleware(config.ConfigApp{EncryptKey: "sharedkey"})
+	a.Equal(err, nil)
+	_, err = m.authorize(context.Background(), []string{"Bearer pk_1_secret"})
+	a.Equal(status.Code(err), codes.Unauthenticated)
+
+	m.SetApiKeys(fakeKeys{})
+	ctx, err := m.authorize(context.Background(), []string{"Bearer pk_1_secret"})
+	a.Equal(err, nil)
+	a.Equal(tenant.From(ctx), "acme")
+	claims, _ := auth.ClaimsFrom(ctx)
+	a.Equal(claims.Subject, "apikey:1")
+	a.Equal(claims.Roles, []string{"viewer", "editor"})
+	a.Equal(m.permit(ctx, "/todoproto.TodoService/AddTodo"), nil)
+	a.NotEqual(m.permit(ctx, "/todoproto.KeyService/CreateApiKey"), nil)
+
+	// rejected keys never reach the log
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	for _, header := range []string{"Bearer pk_1_wrongsecret", "Bearer pk_2_secret", "Bearer sharedkez", "sharedkey"} {
+		_, err = m.authorize(context.Background(), []string{header})
+		a.Equal(status.Code(err), codes.Unauthenticated, header)
+	}
+	a.NotEqual(out.Len(), 0)
+	a.Equal(strings.Contains(out.String(), "wrongsecret"), false)
+	a.Equal(strings.Contains(out.String(), "sharedke"), false)
+}
+
+func TestAuthorizeClientCert(t *testing.T) {
+	a := assert.New(t)
+
+	m, err := NewMiddleware(config.ConfigApp{EncryptKey: "sharedkey"})
+	a.Equal(err, nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "robot", Organization: []string{"acme"}}}
+	verified := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+	}})
+	unverified := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+	}})
+
+	// the certificate alone identifies the caller
+	ctx, err := m.authorize(verified, nil)
+	a.Equal(err, nil)
+	claims, _ := auth.ClaimsFrom(ctx)
+	a.Equal(claims.Subject, "robot")
+	a.Equal(claims.ClientCert, "robot")
+	a.Equal(claims.Roles, []string{"editor"})
+	a.Equal(tenant.From(ctx), "acme")
+
+	// a bearer token still decides who the caller is, the certificate is recorded next to it
+	ctx, err = m.authorize(verified, []string{"Bearer sharedkey"})
+	a.Equal(err, nil)
+	claims, _ = auth.ClaimsFrom(ctx)
+	a.Equal(claims.Subject, "")
+	a.Equal(claims.ClientCert, "robot")
+	a.Equal(tenant.From(ctx), tenant.Default)
+
+	_, err = m.authorize(unverified, nil)
+	a.Equal(status.Code(err), codes.Unauthenticated)
+
+	// without a certificate role a header is required
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	a.Equal(os.WriteFile(path, []byte("methods:\n  \"*\": admin\n"), 0o600), nil)
+	policy, err := config.LoadPolicy(path)
+	a.Equal(err, nil)
+	m, err = NewMiddleware(config.ConfigApp{})
+	a.Equal(err, nil)
+	m.SetPolicy(policy)
+	_, err = m.authorize(verified, nil)
+	a.Equal(status.Code(err), codes.Unauthenticated)
+}
+
+func TestAuthorizeWithoutJwt(t *testing.T) {
+	a := assert.New(t)
+
+	// an unset KEY is not a key, "Bearer " alone must not get in
+	m, err := NewMiddleware(config.ConfigApp{})
+	a.Equal(err, nil)
+	_, err = m.authorize(context.Background(), []string{"Bearer "})
+	a.Equal(status.Code(err), codes.Unauthenticated)
+
+	// with only a JWKS file an HS256 token signed with the public key is refused
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Equal(err, nil)
+	m, err = NewMiddleware(config.ConfigApp{JwtJwksFile: writeJwks(t, "", &rsaKey.PublicKey)})
+	a.Equal(err, nil)
+	token := sign(t, jwt.SigningMethodHS256, rsaKey.PublicKey.N.Bytes(), "", tokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "james",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	_, err = m.authorize(context.Background(), []string{"Bearer " + token})
+	a.Equal(status.Code(err), codes.Unauthenticated)
+
+	_, err = NewMiddleware(config.ConfigApp{JwtJwksFile: filepath.Join(t.TempDir(), "missing.json")})
+	a.NotEqual(err, nil)
+}
+
+func TestRequestId(t *testing.T) {
+	a := assert.New(t)
+
+	m, err := NewMiddleware(config.ConfigApp{EncryptKey: "sharedkey"})
+	a.Equal(err, nil)
+
+	var seen request.Info
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = request.From(ctx)
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/todoproto.TodoService/AddTodo"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer sharedkey", "x-request-id", "req-42"))
+	_, err = m.UnaryAuth(ctx, nil, info, handler)
+	a.Equal(err, nil)
+	a.Equal(seen, request.Info{Id: "req-42", Method: "/todoproto.TodoService/AddTodo"})
+
+	// ids that are unsafe to log are replaced
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer sharedkey", "x-request-id", "bad id\n"))
+	_, err = m.UnaryAuth(ctx, nil, info, handler)
+	a.Equal(err, nil)
+	a.NotEqual(seen.Id, "bad id\n")
+	a.Equal(len(seen.Id), 36)
+
+	// refused callers get a status clients can branch on
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer nope"))
+	_, err = m.UnaryAuth(ctx, nil, info, handler)
+	a.Equal(status.Code(err), codes.Unauthenticated)
+	_, err = m.UnaryAuth(context.Background(), nil, info, handler)
+	a.Equal(status.Code(err), codes.Unauthenticated)
+
+	// the HTTP gateway echoes the id, even on refused requests
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	req.Header.Set("X-Request-Id", "req-43")
+	rec := httptest.NewRecorder()
+	m.HttpAuth(routes("/todoproto.TodoService/GetTodo")).ServeHTTP(rec, req)
+	a.Equal(rec.Code, http.StatusUnauthorized)
+	a.Equal(rec.Header().Get("X-Request-Id"), "req-43")
+	a.Equal(strings.Contains(rec.Body.String(), `"message":"authorization was wrong"`), true)
+}
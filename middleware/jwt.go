@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"todo_pikpo/auth"
+	"todo_pikpo/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenClaims is the payload of the JWTs this service accepts.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Tenant string   `json:"tenant,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// jwtVerifier validates HS256 tokens against JWT_SECRET and RS256 tokens against the keys of JWT_JWKS_FILE.
+type jwtVerifier struct {
+	secret []byte
+	keys   map[string]*rsa.PublicKey
+	parser *jwt.Parser
+}
+
+// jwk is one key of a JWKS document, only RSA keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// loadJwks reads the RSA public keys of a JWKS file by kid.
+func loadJwks(path string) (map[string]*rsa.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("jwks %s: %w", path, err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s key %q: bad modulus", path, k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jwks %s key %q: bad exponent", path, k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s has no RSA signing keys", path)
+	}
+	return keys, nil
+}
+
+// newJwtVerifier returns nil when neither JWT_SECRET nor JWT_JWKS_FILE is configured.
+func newJwtVerifier(conf config.ConfigApp) (*jwtVerifier, error) {
+	if conf.JwtSecret == "" && conf.JwtJwksFile == "" {
+		return nil, nil
+	}
+
+	v := &jwtVerifier{}
+	var methods []string
+	if conf.JwtSecret != "" {
+		v.secret = []byte(conf.JwtSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if conf.JwtJwksFile != "" {
+		keys, err := loadJwks(conf.JwtJwksFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if conf.JwtIssuer != "" {
+		options = append(options, jwt.WithIssuer(conf.JwtIssuer))
+	}
+	if conf.JwtAudience != "" {
+		options = append(options, jwt.WithAudience(conf.JwtAudience))
+	}
+	v.parser = jwt.NewParser(options...)
+	return v, nil
+}
+
+// key picks the verification key of a token, WithValidMethods already limited the algorithms.
+func (v *jwtVerifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		// a single key needs no kid
+		if len(v.keys) == 1 && kid == "" {
+			for _, key := range v.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// verify checks the signature and the time, issuer and audience claims of token and returns its identity.
+func (v *jwtVerifier) verify(token string) (auth.Claims, error) {
+	var claims tokenClaims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.key); err != nil {
+		return auth.Claims{}, err
+	}
+	if claims.Subject == "" {
+		return auth.Claims{}, errors.New("token has no subject")
+	}
+	if auth.IsKeySubject(claims.Subject) {
+		return auth.Claims{}, errors.New("token subject is reserved for api keys")
+	}
+	return auth.Claims{Subject: claims.Subject, Tenant: claims.Tenant, Roles: claims.Roles}, nil
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo_pikpo/auth"
+	"todo_pikpo/config"
+	"todo_pikpo/database"
+	"todo_pikpo/request"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// methodLimit is the token bucket every caller gets for one RPC method.
+type methodLimit struct {
+	rate  float64
+	burst int
+}
+
+// RateLimiter throttles every caller per RPC method, it has to run after the auth interceptor.
+type RateLimiter struct {
+	store database.RateStore
+	// limits is keyed by full method name, bare method name or "*" for every other method
+	limits map[string]methodLimit
+}
+
+// parseRateLimits reads RATE_LIMITS, a comma separated list of method=rate:burst where rate is
+// in requests per second, e.g. "AddTodo=5:10,GetStreamingTodo=0.2:2,*=20:40".
+func parseRateLimits(spec string) (map[string]methodLimit, error) {
+	limits := map[string]methodLimit{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		method, value, ok := strings.Cut(entry, "=")
+		rateVal, burstVal, ok2 := strings.Cut(value, ":")
+		if !ok || !ok2 || method == "" {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q is not method=rate:burst", entry)
+		}
+		rate, err := strconv.ParseFloat(rateVal, 64)
+		if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q needs a positive rate", entry)
+		}
+		burst, err := strconv.Atoi(burstVal)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q needs a burst of at least 1", entry)
+		}
+		limits[method] = methodLimit{rate: rate, burst: burst}
+	}
+	return limits, nil
+}
+
+func NewRateLimiter(conf config.ConfigApp, store database.RateStore) (RateLimiter, error) {
+	limits, err := parseRateLimits(conf.RateLimits)
+	if err != nil {
+		return RateLimiter{}, err
+	}
+	return RateLimiter{store: store, limits: limits}, nil
+}
+
+// limitOf looks fullMethod up by its full name, its bare name and then "*".
+func (rl RateLimiter) limitOf(fullMethod string) (methodLimit, bool) {
+	if limit, ok := rl.limits[fullMethod]; ok {
+		return limit, true
+	}
+	if limit, ok := rl.limits[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]; ok {
+		return limit, true
+	}
+	limit, ok := rl.limits["*"]
+	return limit, ok
+}
+
+// take spends a token of the caller's bucket for fullMethod. When the bucket is empty the
+// error is ResourceExhausted with a RetryInfo detail and header carries retry-after in seconds.
+// A failing store lets the call through, limits are not worth an outage.
+func (rl RateLimiter) take(ctx context.Context, fullMethod string) (header metadata.MD, err error) {
+	limit, ok := rl.limitOf(fullMethod)
+	if !ok || rl.store == nil {
+		return nil, nil
+	}
+	claims, _ := auth.ClaimsFrom(ctx)
+	taken, wait, err := rl.store.Take("rate-"+claims.Caller()+"-"+fullMethod, limit.rate, limit.burst)
+	if err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " rate limit store ", err)
+		return nil, nil
+	}
+	if taken {
+		return nil, nil
+	}
+
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("rate limit of %s exceeded, retry in %s", fullMethod, wait.Round(time.Millisecond)))
+	if detailed, dErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); dErr == nil {
+		st = detailed
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return metadata.Pairs("retry-after", strconv.Itoa(seconds)), st.Err()
+}
+
+func (rl RateLimiter) UnaryLimit(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	header, err := rl.take(ctx, info.FullMethod)
+	if err != nil {
+		_ = grpc.SetHeader(ctx, header)
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (rl RateLimiter) StreamLimit(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	header, err := rl.take(stream.Context(), info.FullMethod)
+	if err != nil {
+		_ = stream.SetHeader(header)
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// httpLimit applies the buckets of the mirrored RPC to the HTTP/JSON gateway.
+type httpLimit struct {
+	rl   RateLimiter
+	next http.Handler
+}
+
+// HttpLimit throttles the HTTP/JSON gateway with the same buckets as the RPCs its routes mirror, so
+// both share one budget per caller. It has to run inside HttpAuth, which resolves the method and
+// the caller, and it passes FullMethod of next through so HttpAuth still sees the routes.
+func (rl RateLimiter) HttpLimit(next http.Handler) http.Handler {
+	return httpLimit{rl: rl, next: next}
+}
+
+func (hl httpLimit) FullMethod(r *http.Request) string {
+	if router, ok := hl.next.(rpcRouter); ok {
+		return router.FullMethod(r)
+	}
+	return ""
+}
+
+func (hl httpLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if method := request.From(r.Context()).Method; method != "" {
+		header, err := hl.rl.take(r.Context(), method)
+		if err != nil {
+			w.Header().Set("Retry-After", header.Get("retry-after")[0])
+			writeHttpError(w, http.StatusTooManyRequests, status.Convert(err).Message())
+			return
+		}
+	}
+	hl.next.ServeHTTP(w, r)
+}
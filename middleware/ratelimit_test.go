@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"todo_pikpo/auth"
+	"todo_pikpo/config"
+	"todo_pikpo/database"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// brokenStore fails every call like an unreachable redis.
+type brokenStore struct{}
+
+func (brokenStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	return false, 0, errors.New("connection refused")
+}
+
+func (brokenStore) Consume(key string, n int, max int, reset time.Time) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestParseRateLimits(t *testing.T) {
+	a := assert.New(t)
+
+	limits, err := parseRateLimits(" AddTodo=5:10, /todoproto.StreamService/GetStreamingTodo=0.2:1,*=20:40,")
+	a.Equal(err, nil)
+	a.Equal(limits, map[string]methodLimit{
+		"AddTodo": {rate: 5, burst: 10},
+		"/todoproto.StreamService/GetStreamingTodo": {rate: 0.2, burst: 1},
+		"*": {rate: 20, burst: 40},
+	})
+	limits, err = parseRateLimits("")
+	a.Equal(err, nil)
+	a.Equal(len(limits), 0)
+
+	for _, spec := range []string{"AddTodo", "AddTodo=5", "=5:10", "AddTodo=0:10", "AddTodo=-1:10", "AddTodo=5:0", "AddTodo=fast:10"} {
+		_, err := parseRateLimits(spec)
+		a.NotEqual(err, nil, spec)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	rl, err := NewRateLimiter(config.ConfigApp{RateLimits: "AddTodo=0.5:2,/todoproto.TodoService/GetTodo=100:100,*=1:1"}, database.NewMemoryRateStore())
+	a.Equal(err, nil)
+
+	limit, _ := rl.limitOf("/todoproto.TodoService/AddTodo")
+	a.Equal(limit.burst, 2)
+	limit, _ = rl.limitOf("/todoproto.TodoService/GetTodo")
+	a.Equal(limit.burst, 100)
+	limit, _ = rl.limitOf("/todoproto.KeyService/ListApiKeys")
+	a.Equal(limit.burst, 1)
+
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(ctx context.Context, method string) error {
+		_, err := rl.UnaryLimit(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, ok)
+		return err
+	}
+	james := auth.WithClaims(context.Background(), auth.Claims{Subject: "james"})
+	robert := auth.WithClaims(context.Background(), auth.Claims{Subject: "robert"})
+
+	a.Equal(call(james, "/todoproto.TodoService/AddTodo"), nil)
+	a.Equal(call(james, "/todoproto.TodoService/AddTodo"), nil)
+	err = call(james, "/todoproto.TodoService/AddTodo")
+	a.Equal(status.Code(err), codes.ResourceExhausted)
+	details := status.Convert(err).Details()
+	if a.Equal(len(details), 1) {
+		retry, isRetry := details[0].(*errdetails.RetryInfo)
+		a.True(isRetry)
+		a.InDelta(retry.GetRetryDelay().AsDuration().Seconds(), 2, 0.1)
+	}
+
+	// buckets are per caller and per method
+	a.Equal(call(robert, "/todoproto.TodoService/AddTodo"), nil)
+	a.Equal(call(james, "/todoproto.TodoService/GetTodo"), nil)
+
+	// a store that is down lets calls through
+	rl.store = brokenStore{}
+	for i := 0; i < 3; i++ {
+		a.Equal(call(james, "/todoproto.TodoService/AddTodo"), nil)
+	}
+
+	_, err = NewRateLimiter(config.ConfigApp{RateLimits: "AddTodo=5"}, database.NewMemoryRateStore())
+	a.NotEqual(err, nil)
+}
+
+func TestHttpLimit(t *testing.T) {
+	a := assert.New(t)
+
+	m, err := NewMiddleware(config.ConfigApp{EncryptKey: "sharedkey"})
+	a.Equal(err, nil)
+	rl, err := NewRateLimiter(config.ConfigApp{RateLimits: "GetTodo=0.5:2"}, database.NewMemoryRateStore())
+	a.Equal(err, nil)
+
+	serve := func(handler http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		req.Header.Set("Authorization", "Bearer sharedkey")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	// the gateway spends the bucket of the mirrored RPC
+	handler := m.HttpAuth(rl.HttpLimit(routes("/todoproto.TodoService/GetTodo")))
+	a.Equal(serve(handler).Code, http.StatusOK)
+	a.Equal(serve(handler).Code, http.StatusOK)
+	rec := serve(handler)
+	a.Equal(rec.Code, http.StatusTooManyRequests)
+	a.Equal(rec.Header().Get("Retry-After"), "2")
+
+	// it is the same bucket the gRPC interceptor takes from
+	ctx := auth.WithClaims(context.Background(), auth.Claims{Tenant: "default"})
+	_, err = rl.UnaryLimit(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/todoproto.TodoService/GetTodo"}, func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+	a.Equal(status.Code(err), codes.ResourceExhausted)
+
+	// routes without a limit pass
+	a.Equal(serve(m.HttpAuth(rl.HttpLimit(routes("/todoproto.TodoService/AddTodo")))).Code, http.StatusOK)
+}
@@ -0,0 +1,25 @@
+// Package request carries the id and the RPC method of the request being served through its context.
+package request
+
+import "context"
+
+// Info identifies a request, the audit log records it with every change the request makes.
+type Info struct {
+	// Id is the caller's x-request-id, or one generated when the caller sent none.
+	Id string
+	// Method is the full gRPC method, HTTP requests carry the method of the RPC they mirror.
+	Method string
+}
+
+type ctxKey struct{}
+
+// With returns a copy of ctx carrying info.
+func With(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, ctxKey{}, info)
+}
+
+// From returns the request info of ctx, the zero Info for work that runs outside any request.
+func From(ctx context.Context) Info {
+	info, _ := ctx.Value(ctxKey{}).(Info)
+	return info
+}
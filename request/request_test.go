@@ -0,0 +1,16 @@
+package request
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrom(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(From(context.Background()), Info{})
+	info := Info{Id: "abc", Method: "/todoproto.TodoService/AddTodo"}
+	a.Equal(From(With(context.Background(), info)), info)
+}
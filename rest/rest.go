@@ -0,0 +1,538 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"todo_pikpo/apperror"
+	"todo_pikpo/controllers"
+	model "todo_pikpo/database/models"
+	_interface "todo_pikpo/interface"
+
+	pb "todo_pikpo/grpc/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RestServer exposes TodoController as an HTTP/JSON API:
+//
+//	GET    /todos          list, filtered by author, title, isDone, startFrom, startTo, endFrom, endTo,
+//	                       overdue, search and searchPrefix, paged by pageToken and limit query params,
+//	                       X-Next-Page-Token and X-Total-Count headers describe the page
+//	GET    /todos/stream   same list written as NDJSON, or SSE when Accept is text/event-stream
+//	GET    /todos/search   full text search, q, pageToken and limit query params
+//	GET    /todos/trash    deleted todos, page and limit query params
+//	POST   /todos/{id}/restore
+//	GET    /todos/{id}/history revisions oldest first, each with the fields it changed
+//	POST   /todos/{id}/revert  version query param names the revision to bring back
+//	GET    /todos/{id}
+//	POST   /todos
+//	PUT    /todos/{id}
+//	PATCH  /todos/{id}     only the fields present in the body change
+//	DELETE /todos/{id}
+//
+// Single todo responses carry the todo version as ETag, PUT, PATCH, DELETE and revert honour
+// If-Match and answer 412 when the todo changed since.
+type RestServer struct {
+	controller *controllers.TodoController
+}
+
+type errorBody struct {
+	Code            int                       `json:"code"`
+	Message         string                    `json:"message"`
+	FieldViolations []apperror.FieldViolation `json:"fieldViolations,omitempty"`
+}
+
+func writeJson(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " rest - writeJson ", err)
+	}
+}
+
+// writeError reports err with its status. Internal errors may quote the database, they are logged and
+// answered with the request id the auth middleware already put on the response.
+func writeError(w http.ResponseWriter, err error) {
+	code := apperror.HttpStatus(err)
+	message := err.Error()
+	if apperror.KindOf(err) == apperror.KindInternal {
+		id := w.Header().Get("X-Request-Id")
+		log.Error(time.Now().Format("2006-01-02 15:04:05"), " rest - request ", id, " internal error ", err)
+		message = "internal error, request id " + id
+	}
+	writeJson(w, code, errorBody{
+		Code:            code,
+		Message:         message,
+		FieldViolations: apperror.FieldsOf(err),
+	})
+}
+
+func writeTodo(w http.ResponseWriter, code int, data model.TodoModel) {
+	w.Header().Set("ETag", fmt.Sprintf("%q", strconv.FormatUint(data.Version, 10)))
+	writeJson(w, code, data)
+}
+
+// ifMatch reads the expected version from the If-Match header, 0 when there is none.
+func ifMatch(r *http.Request) (uint64, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(v, "W/"), `"`), 10, 64)
+	if err != nil || version == 0 {
+		return 0, apperror.Validation(apperror.FieldViolation{Field: "If-Match", Description: "If-Match should be an ETag returned by this API"})
+	}
+	return version, nil
+}
+
+// writeConditionalError reports a lost If-Match race as 412, everything else as writeError does.
+func writeConditionalError(w http.ResponseWriter, err error, conditional bool) {
+	if conditional && apperror.KindOf(err) == apperror.KindConflict {
+		writeJson(w, http.StatusPreconditionFailed, errorBody{
+			Code:    http.StatusPreconditionFailed,
+			Message: err.Error(),
+		})
+		return
+	}
+	writeError(w, err)
+}
+
+// listParams is a list request translated into controller arguments.
+// Page is the deprecated offset paging, it is only used when the page query param is sent.
+type listParams struct {
+	Filter    _interface.TodoQueryFilter
+	Page      uint
+	Offset    bool
+	PageToken string
+	Limit     uint
+}
+
+// boolParam parses an optional boolean query param.
+func boolParam(q url.Values, name string) (*bool, error) {
+	v := q.Get(name)
+	if len(v) == 0 {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, apperror.Validation(apperror.FieldViolation{Field: name, Description: name + " should be true or false"})
+	}
+	return &b, nil
+}
+
+// timeParam parses an optional RFC 3339 query param, the zero time when it is absent.
+func timeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, apperror.Validation(apperror.FieldViolation{Field: name, Description: name + " should be an RFC 3339 timestamp"})
+	}
+	return t, nil
+}
+
+// listQuery translates the query string of a list request into controller arguments.
+// author can be repeated to match any of several authors.
+func listQuery(r *http.Request) (listParams, error) {
+	q := r.URL.Query()
+	query := _interface.TodoQueryFilter{
+		Authors: q["author"],
+		Title:   q.Get("title"),
+		Search:  q.Get("search"),
+	}
+
+	var err error
+	if query.IsDone, err = boolParam(q, "isDone"); err != nil {
+		return listParams{}, err
+	}
+	for name, b := range map[string]*bool{"overdue": &query.Overdue, "searchPrefix": &query.SearchPrefix} {
+		v, err := boolParam(q, name)
+		if err != nil {
+			return listParams{}, err
+		}
+		*b = v != nil && *v
+	}
+	for name, t := range map[string]*time.Time{
+		"startFrom": &query.StartFrom,
+		"startTo":   &query.StartTo,
+		"endFrom":   &query.EndFrom,
+		"endTo":     &query.EndTo,
+	} {
+		if *t, err = timeParam(q, name); err != nil {
+			return listParams{}, err
+		}
+	}
+	if query.OrderBy, err = controllers.ParseOrderBy(q.Get("orderBy")); err != nil {
+		return listParams{}, err
+	}
+
+	params := listParams{Filter: query, PageToken: q.Get("pageToken"), Limit: 10}
+	if v := q.Get("page"); len(v) > 0 {
+		page, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return listParams{}, apperror.Validation(apperror.FieldViolation{Field: "page", Description: "page should be a positive number"})
+		}
+		params.Page, params.Offset = uint(page), true
+	}
+	if v := q.Get("limit"); len(v) > 0 {
+		limit, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || limit == 0 {
+			return listParams{}, apperror.Validation(apperror.FieldViolation{Field: "limit", Description: "limit should be greater than 0"})
+		}
+		params.Limit = uint(limit)
+	}
+
+	return params, nil
+}
+
+// fetchList runs a list request, page headers are only set for token paging.
+func (rs RestServer) fetchList(w http.ResponseWriter, r *http.Request, params listParams) ([]model.TodoModel, error) {
+	if params.Offset {
+		return rs.controller.GetTodos(r.Context(), params.Filter, params.Page, params.Limit)
+	}
+
+	page, err := rs.controller.GetTodosPage(r.Context(), params.Filter, params.PageToken, params.Limit)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("X-Next-Page-Token", page.NextPageToken)
+	w.Header().Set("X-Total-Count", strconv.FormatInt(page.TotalSize, 10))
+	return page.Todos, nil
+}
+
+func decodeTodo(r *http.Request) (model.TodoModel, error) {
+	var data model.TodoModel
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return model.TodoModel{}, apperror.Validation(apperror.FieldViolation{Field: "body", Description: "body should be a todo JSON object"})
+	}
+	return data, nil
+}
+
+// decodePatch decodes a partial todo, the keys present in the body make up the field mask.
+func decodePatch(r *http.Request) (model.TodoModel, []string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return model.TodoModel{}, nil, apperror.Validation(apperror.FieldViolation{Field: "body", Description: "body should be a todo JSON object"})
+	}
+
+	var keys map[string]json.RawMessage
+	var data model.TodoModel
+	if json.Unmarshal(body, &keys) != nil || json.Unmarshal(body, &data) != nil {
+		return model.TodoModel{}, nil, apperror.Validation(apperror.FieldViolation{Field: "body", Description: "body should be a todo JSON object"})
+	}
+
+	var fields []string
+	for k := range keys {
+		// version is the expected version, not a field to change
+		if k != "version" {
+			fields = append(fields, k)
+		}
+	}
+	if len(fields) == 0 {
+		return model.TodoModel{}, nil, apperror.Validation(apperror.FieldViolation{Field: "body", Description: "body should contain at least one field"})
+	}
+	return data, fields, nil
+}
+
+func (rs RestServer) list(w http.ResponseWriter, r *http.Request) {
+	params, err := listQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := rs.fetchList(w, r, params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+func (rs RestServer) stream(w http.ResponseWriter, r *http.Request) {
+	params, err := listQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := rs.fetchList(w, r, params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	for _, d := range res {
+		line, err := json.Marshal(d)
+		if err != nil {
+			log.Error(time.Now().Format("2006-01-02 15:04:05"), " rest - stream ", err)
+			return
+		}
+		if sse {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", line)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", line)
+		}
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func (rs RestServer) search(w http.ResponseWriter, r *http.Request) {
+	params, err := listQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := rs.controller.SearchTodos(r.Context(), r.URL.Query().Get("q"), params.PageToken, params.Limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+func (rs RestServer) trash(w http.ResponseWriter, r *http.Request) {
+	params, err := listQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := rs.controller.ListDeletedTodos(r.Context(), params.Page, params.Limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+func (rs RestServer) restore(w http.ResponseWriter, r *http.Request, id string) {
+	res, err := rs.controller.RestoreTodo(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, res)
+}
+
+func (rs RestServer) history(w http.ResponseWriter, r *http.Request, id string) {
+	res, err := rs.controller.GetTodoHistory(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+func (rs RestServer) revert(w http.ResponseWriter, r *http.Request, id string) {
+	expected, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := strconv.ParseUint(r.URL.Query().Get("version"), 10, 64)
+	if err != nil || version == 0 {
+		writeError(w, apperror.Validation(apperror.FieldViolation{Field: "version", Description: "version should be a positive number"}))
+		return
+	}
+
+	res, err := rs.controller.RevertTodo(r.Context(), id, version, expected)
+	if err != nil {
+		writeConditionalError(w, err, expected != 0)
+		return
+	}
+	writeTodo(w, http.StatusOK, res)
+}
+
+func (rs RestServer) get(w http.ResponseWriter, r *http.Request, id string) {
+	res, err := rs.controller.GetTodo(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, res)
+}
+
+func (rs RestServer) create(w http.ResponseWriter, r *http.Request) {
+	data, err := decodeTodo(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := rs.controller.AddTodo(r.Context(), data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeTodo(w, http.StatusCreated, res)
+}
+
+func (rs RestServer) update(w http.ResponseWriter, r *http.Request, id string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := decodeTodo(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if version != 0 {
+		data.Version = version
+	}
+
+	res, err := rs.controller.EditTodo(r.Context(), id, data)
+	if err != nil {
+		writeConditionalError(w, err, version != 0)
+		return
+	}
+	writeTodo(w, http.StatusOK, res)
+}
+
+func (rs RestServer) patch(w http.ResponseWriter, r *http.Request, id string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, fields, err := decodePatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if version != 0 {
+		data.Version = version
+	}
+
+	res, err := rs.controller.EditTodo(r.Context(), id, data, fields...)
+	if err != nil {
+		writeConditionalError(w, err, version != 0)
+		return
+	}
+	writeTodo(w, http.StatusOK, res)
+}
+
+func (rs RestServer) delete(w http.ResponseWriter, r *http.Request, id string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := rs.controller.DeleteTodo(r.Context(), id, version)
+	if err != nil {
+		writeConditionalError(w, err, version != 0)
+		return
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+// route resolves r to its handler and the gRPC method it mirrors, the handler is nil when no route matches.
+func (rs RestServer) route(r *http.Request) (string, http.HandlerFunc) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path != "todos" && !strings.HasPrefix(path, "todos/") {
+		return "", nil
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(path, "todos"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		return pb.TodoService_GetTodo_FullMethodName, rs.list
+	case id == "" && r.Method == http.MethodPost:
+		return pb.TodoService_AddTodo_FullMethodName, rs.create
+	case id == "stream" && r.Method == http.MethodGet:
+		return pb.StreamService_GetStreamingTodo_FullMethodName, rs.stream
+	case id == "search" && r.Method == http.MethodGet:
+		return pb.TodoService_SearchTodos_FullMethodName, rs.search
+	case id == "trash" && r.Method == http.MethodGet:
+		return pb.TodoService_ListDeletedTodos_FullMethodName, rs.trash
+	case strings.HasSuffix(id, "/restore") && strings.Count(id, "/") == 1 && r.Method == http.MethodPost:
+		return pb.TodoService_RestoreTodo_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.restore(w, r, strings.TrimSuffix(id, "/restore"))
+		}
+	case strings.HasSuffix(id, "/history") && strings.Count(id, "/") == 1 && r.Method == http.MethodGet:
+		return pb.TodoService_GetTodoHistory_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.history(w, r, strings.TrimSuffix(id, "/history"))
+		}
+	case strings.HasSuffix(id, "/revert") && strings.Count(id, "/") == 1 && r.Method == http.MethodPost:
+		return pb.TodoService_RevertTodo_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.revert(w, r, strings.TrimSuffix(id, "/revert"))
+		}
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodGet:
+		return pb.TodoService_GetOneTodo_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.get(w, r, id)
+		}
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodPut:
+		return pb.TodoService_EditTodo_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.update(w, r, id)
+		}
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodPatch:
+		return pb.TodoService_EditTodo_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.patch(w, r, id)
+		}
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodDelete:
+		return pb.TodoService_DeleteTodo_FullMethodName, func(w http.ResponseWriter, r *http.Request) {
+			rs.delete(w, r, id)
+		}
+	}
+	return "", nil
+}
+
+// FullMethod names the gRPC method a request mirrors, so the gRPC access policy applies to it.
+func (rs RestServer) FullMethod(r *http.Request) string {
+	method, _ := rs.route(r)
+	return method
+}
+
+func (rs RestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " rest - ", r.Method, " ", r.URL.String())
+
+	path := strings.Trim(r.URL.Path, "/")
+	if path != "todos" && !strings.HasPrefix(path, "todos/") {
+		writeError(w, apperror.NotFound("route %s not found", r.URL.Path))
+		return
+	}
+
+	_, handle := rs.route(r)
+	if handle == nil {
+		writeJson(w, http.StatusMethodNotAllowed, errorBody{
+			Code:    http.StatusMethodNotAllowed,
+			Message: "method not allowed",
+		})
+		return
+	}
+	handle(w, r)
+}
+
+func StartRest(controller *controllers.TodoController) RestServer {
+	r := RestServer{controller: controller}
+
+	log.Info(time.Now().Format("2006-01-02 15:04:05"), " Initialize new REST Instance ")
+	return r
+}
@@ -0,0 +1,505 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"todo_pikpo/config"
+	"todo_pikpo/controllers"
+	"todo_pikpo/database"
+	model "todo_pikpo/database/models"
+	midw "todo_pikpo/middleware"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type RestTest struct {
+	suite.Suite
+	conf   config.ConfigApp
+	db     *database.Database
+	server *httptest.Server
+}
+
+func (s *RestTest) SetupSuite() {
+	s.conf = config.ConfigApp{
+		DbDriver:    database.DriverMemory,
+		CacheDriver: database.CacheLru,
+		EncryptKey:  "testkey",
+		TenantKeys:  "acme:acmekey, globex:globexkey",
+		JwtSecret:   "jwtsecret",
+	}
+
+	db, err := database.NewDatabase(s.conf)
+	if err != nil {
+		s.T().Error("Failed to create database:", err)
+		return
+	}
+	s.db = &db
+
+	cnt, err := controllers.CreateTodoController(&db)
+	if err != nil {
+		s.T().Error("Failed to create controller:", err)
+		return
+	}
+
+	mdl, err := midw.NewMiddleware(s.conf)
+	if err != nil {
+		s.T().Error("Failed to create middleware:", err)
+		return
+	}
+	limiter, err := midw.NewRateLimiter(s.conf, db.Limits)
+	if err != nil {
+		s.T().Error("Failed to create rate limiter:", err)
+		return
+	}
+	rService := StartRest(&cnt)
+	s.server = httptest.NewServer(mdl.HttpAuth(limiter.HttpLimit(rService)))
+}
+
+func (s *RestTest) TearDownSuite() {
+	s.server.Close()
+}
+
+func (s *RestTest) TearDownTest() {
+	s.db.Flush()
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(RestTest))
+}
+
+func (s *RestTest) do(method string, path string, body interface{}, header map[string]string) *http.Response {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, s.server.URL+path, &buf)
+	s.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+s.conf.EncryptKey)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().Nil(err)
+	return resp
+}
+
+func (s *RestTest) create(author string, title string) model.TodoModel {
+	resp := s.do(http.MethodPost, "/todos", model.TodoModel{
+		Author:    author,
+		Title:     title,
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(24 * time.Hour),
+	}, nil)
+	defer resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusCreated)
+
+	var res model.TodoModel
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	return res
+}
+
+func (s *RestTest) TestNonAuth() {
+	resp, err := http.Get(s.server.URL + "/todos")
+	s.Require().Nil(err)
+	defer resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusUnauthorized)
+}
+
+func (s *RestTest) TestTenant() {
+	a := s.Suite.Assert()
+	acme := map[string]string{"Authorization": "Bearer acmekey"}
+	globex := map[string]string{"Authorization": "Bearer globexkey"}
+
+	resp := s.do(http.MethodPost, "/todos", model.TodoModel{
+		Author:    "james",
+		Title:     "acme only",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(24 * time.Hour),
+	}, acme)
+	var created model.TodoModel
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusCreated)
+	a.Equal(created.TenantId, "acme")
+	s.create("robert", "default only")
+
+	// warm the cache as acme first, globex must still miss
+	resp = s.do(http.MethodGet, "/todos/"+created.Id, nil, acme)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	resp = s.do(http.MethodGet, "/todos/"+created.Id, nil, globex)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusNotFound)
+	resp = s.do(http.MethodGet, "/todos/"+created.Id, nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusNotFound)
+
+	var res []model.TodoModel
+	for _, tc := range []struct {
+		header map[string]string
+		want   int
+	}{{acme, 1}, {globex, 0}, {nil, 1}} {
+		resp = s.do(http.MethodGet, "/todos", nil, tc.header)
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		a.Equal(len(res), tc.want)
+		a.Equal(resp.Header.Get("X-Total-Count"), fmt.Sprint(tc.want))
+	}
+
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, globex)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusNotFound)
+	resp = s.do(http.MethodGet, "/todos/search?q=acme", nil, globex)
+	var page controllers.SearchPage
+	_ = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	a.Equal(page.TotalSize, int64(0))
+
+	resp = s.do(http.MethodGet, "/todos", nil, map[string]string{"Authorization": "Bearer acme:acmekey"})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusUnauthorized)
+}
+
+func (s *RestTest) TestJwt() {
+	a := s.Suite.Assert()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":    "james",
+		"tenant": "acme",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("jwtsecret"))
+	a.Equal(err, nil)
+	bearer := map[string]string{"Authorization": "Bearer " + token}
+
+	resp := s.do(http.MethodPost, "/todos", model.TodoModel{
+		Title:     "no author given",
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(24 * time.Hour),
+	}, bearer)
+	var created model.TodoModel
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusCreated)
+	a.Equal(created.Author, "james")
+	a.Equal(created.TenantId, "acme")
+
+	resp = s.do(http.MethodGet, "/todos/"+created.Id, nil, map[string]string{"Authorization": "Bearer acmekey"})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+
+	resp = s.do(http.MethodGet, "/todos", nil, map[string]string{"Authorization": "Bearer " + token + "x"})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusUnauthorized)
+}
+
+func (s *RestTest) TestRoles() {
+	a := s.Suite.Assert()
+	bearer := func(sub string, roles ...string) map[string]string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   sub,
+			"roles": roles,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("jwtsecret"))
+		a.Equal(err, nil)
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+	created := s.create("james", "written by james")
+
+	// viewers read but never write
+	resp := s.do(http.MethodGet, "/todos/"+created.Id, nil, bearer("robert", "viewer"))
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, bearer("james", "viewer"))
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusForbidden)
+
+	// editors only change their own todos
+	resp = s.do(http.MethodPatch, "/todos/"+created.Id, map[string]string{"title": "robert was here"}, bearer("robert", "editor"))
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusForbidden)
+	resp = s.do(http.MethodPatch, "/todos/"+created.Id, map[string]string{"title": "james was here"}, bearer("james", "editor"))
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, bearer("robert", "admin"))
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+}
+
+func (s *RestTest) TestCrud() {
+	a := s.Suite.Assert()
+	created := s.create("james", "jakarta unit test")
+	a.Greater(len(created.Id), 1)
+
+	resp := s.do(http.MethodGet, "/todos/"+created.Id, nil, nil)
+	var got model.TodoModel
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(got.Title, "jakarta unit test")
+
+	resp = s.do(http.MethodPut, "/todos/"+created.Id, model.TodoModel{
+		Author:    "james",
+		Title:     "changed title",
+		IsDone:    true,
+		StartDate: time.Now(),
+		EndDate:   time.Now().Add(24 * time.Hour),
+	}, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(got.Title, "changed title")
+	a.Equal(got.IsDone, true)
+
+	resp = s.do(http.MethodPut, "/todos/"+created.Id, model.TodoModel{Author: "-", Title: "changed title"}, nil)
+	var eBody errorBody
+	_ = json.NewDecoder(resp.Body).Decode(&eBody)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+	a.Equal(eBody.FieldViolations[0].Field, "author")
+
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+
+	resp = s.do(http.MethodGet, "/todos/"+created.Id, nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func (s *RestTest) TestPatch() {
+	a := s.Suite.Assert()
+	created := s.create("james", "jakarta unit test")
+
+	var got model.TodoModel
+	resp := s.do(http.MethodPatch, "/todos/"+created.Id, map[string]interface{}{"isDone": true}, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(got.IsDone, true)
+	a.Equal(got.Title, "jakarta unit test")
+
+	resp = s.do(http.MethodPatch, "/todos/"+created.Id, map[string]interface{}{"id": "other"}, nil)
+	var eBody errorBody
+	_ = json.NewDecoder(resp.Body).Decode(&eBody)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+	a.Equal(eBody.FieldViolations[0].Field, "updateMask")
+}
+
+func (s *RestTest) TestIfMatch() {
+	a := s.Suite.Assert()
+	created := s.create("james", "jakarta unit test")
+
+	resp := s.do(http.MethodGet, "/todos/"+created.Id, nil, nil)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	a.Equal(etag, `"1"`)
+
+	resp = s.do(http.MethodPatch, "/todos/"+created.Id, map[string]interface{}{"isDone": true}, map[string]string{"If-Match": etag})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(resp.Header.Get("ETag"), `"2"`)
+
+	resp = s.do(http.MethodPatch, "/todos/"+created.Id, map[string]interface{}{"title": "lost update"}, map[string]string{"If-Match": etag})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusPreconditionFailed)
+
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, map[string]string{"If-Match": etag})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusPreconditionFailed)
+
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, map[string]string{"If-Match": "nonsense"})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+
+	resp = s.do(http.MethodDelete, "/todos/"+created.Id, nil, map[string]string{"If-Match": `"2"`})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+}
+
+func (s *RestTest) TestTrash() {
+	a := s.Suite.Assert()
+	created := s.create("james", "jakarta unit test")
+
+	resp := s.do(http.MethodDelete, "/todos/"+created.Id, nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+
+	var res []model.TodoModel
+	resp = s.do(http.MethodGet, "/todos/trash", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(len(res), 1)
+	a.Equal(res[0].Id, created.Id)
+
+	resp = s.do(http.MethodPost, "/todos/"+created.Id+"/restore", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+
+	resp = s.do(http.MethodGet, "/todos/"+created.Id, nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+}
+
+func (s *RestTest) TestHistory() {
+	a := s.Suite.Assert()
+	created := s.create("james", "jakarta unit test")
+
+	resp := s.do(http.MethodPatch, "/todos/"+created.Id, map[string]interface{}{"title": "renamed"}, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+
+	var history []controllers.TodoRevision
+	resp = s.do(http.MethodGet, "/todos/"+created.Id+"/history", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	if a.Equal(len(history), 2) {
+		a.Equal(history[1].Title, "renamed")
+		a.Equal(history[1].Changes[0].Field, "title")
+	}
+
+	resp = s.do(http.MethodPost, "/todos/"+created.Id+"/revert?version=1", nil, map[string]string{"If-Match": `"1"`})
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusPreconditionFailed)
+
+	var got model.TodoModel
+	resp = s.do(http.MethodPost, "/todos/"+created.Id+"/revert?version=1", nil, map[string]string{"If-Match": `"2"`})
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(resp.Header.Get("ETag"), `"3"`)
+	a.Equal(got.Title, "jakarta unit test")
+
+	resp = s.do(http.MethodPost, "/todos/"+created.Id+"/revert", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+}
+
+func (s *RestTest) TestList() {
+	a := s.Suite.Assert()
+	s.create("james", "test this is title")
+	s.create("robert", "jakarta unit test")
+	s.create("ali", "singapore is awesome")
+
+	var res []model.TodoModel
+	resp := s.do(http.MethodGet, "/todos?limit=2", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(len(res), 2)
+
+	resp = s.do(http.MethodGet, "/todos?author=robert&isDone=false", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(len(res), 1)
+	a.Equal(res[0].Author, "robert")
+
+	resp = s.do(http.MethodGet, "/todos?limit=2&author=robert", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.Header.Get("X-Total-Count"), "1")
+	a.Equal(resp.Header.Get("X-Next-Page-Token"), "")
+
+	resp = s.do(http.MethodGet, "/todos?limit=2", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(resp.Header.Get("X-Total-Count"), "3")
+	token := resp.Header.Get("X-Next-Page-Token")
+	a.NotEqual(token, "")
+
+	resp = s.do(http.MethodGet, "/todos?limit=2&pageToken="+token, nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(len(res), 1)
+	a.Equal(res[0].Author, "ali")
+
+	resp = s.do(http.MethodGet, "/todos?author=robert&author=ali&search=SINGA", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(len(res), 1)
+	a.Equal(res[0].Author, "ali")
+
+	resp = s.do(http.MethodGet, "/todos?endFrom="+url.QueryEscape(time.Now().Format(time.RFC3339)), nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(len(res), 3)
+
+	resp = s.do(http.MethodGet, "/todos?orderBy="+url.QueryEscape("title desc"), nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(len(res), 3)
+	a.Equal(res[0].Author, "james")
+	a.Equal(res[2].Author, "robert")
+
+	resp = s.do(http.MethodGet, "/todos?orderBy=author", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+
+	resp = s.do(http.MethodGet, "/todos?endFrom=yesterday", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+
+	resp = s.do(http.MethodGet, "/todos?limit=abc", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+}
+
+func (s *RestTest) TestSearch() {
+	a := s.Suite.Assert()
+	s.create("james", "jakarta unit test")
+	s.create("robert", "singapore is awesome")
+
+	var res controllers.SearchPage
+	resp := s.do(http.MethodGet, "/todos/search?q=Jakarta", nil, nil)
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusOK)
+	a.Equal(res.TotalSize, int64(1))
+	a.Equal(res.Hits[0].Item.Author, "james")
+	a.Contains(res.Hits[0].Snippet, "<b>jakarta</b>")
+
+	resp = s.do(http.MethodGet, "/todos/search", nil, nil)
+	resp.Body.Close()
+	a.Equal(resp.StatusCode, http.StatusBadRequest)
+}
+
+func (s *RestTest) TestStream() {
+	a := s.Suite.Assert()
+	s.create("james", "test this is title")
+	s.create("robert", "jakarta unit test")
+
+	resp := s.do(http.MethodGet, "/todos/stream", nil, nil)
+	a.Equal(resp.Header.Get("Content-Type"), "application/x-ndjson")
+	var c = 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var d model.TodoModel
+		a.Equal(json.Unmarshal(scanner.Bytes(), &d), nil)
+		c += 1
+	}
+	resp.Body.Close()
+	a.Equal(c, 2)
+
+	resp = s.do(http.MethodGet, "/todos/stream", nil, map[string]string{"Accept": "text/event-stream"})
+	a.Equal(resp.Header.Get("Content-Type"), "text/event-stream")
+	c = 0
+	scanner = bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data: ") {
+			c += 1
+		}
+	}
+	resp.Body.Close()
+	a.Equal(c, 2)
+}
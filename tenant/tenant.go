@@ -0,0 +1,23 @@
+// Package tenant carries the workspace a request acts on through its context.
+package tenant
+
+import "context"
+
+// Default is the workspace of callers authenticated with the shared KEY and of
+// background work that runs outside any request.
+const Default = "default"
+
+type ctxKey struct{}
+
+// With returns a copy of ctx bound to the workspace id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// From returns the workspace ctx is bound to, Default when it is bound to none.
+func From(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
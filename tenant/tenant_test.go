@@ -0,0 +1,16 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrom(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(From(context.Background()), Default)
+	a.Equal(From(With(context.Background(), "acme")), "acme")
+	a.Equal(From(With(context.Background(), "")), Default)
+}